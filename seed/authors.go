@@ -0,0 +1,128 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// authorEntry matches an author, e.g. "Jane Doe <jane@example.com>" or "Jane Doe (jdoe)".
+const authorEntry = `\p{L}[\p{L}\p{M}.'\- ]*?(?: <[^<>\s]+>| \([^()]+\))?`
+
+// authorLineRegex matches a line listing authors separated by commas, after
+// an optional comment prefix or bullet.
+var authorLineRegex = regexp.MustCompile(`^([ \t]*(?:(?://|#+|\*|-|;+)[ \t]*)?)(` + authorEntry + `(?:, *` + authorEntry + `)*),?[ \t]*$`)
+
+// author is an entry of an authors list with its sort key.
+type author struct {
+	name, key string
+}
+
+type byAuthorKey []author
+
+func (s byAuthorKey) Len() int           { return len(s) }
+func (s byAuthorKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byAuthorKey) Less(i, j int) bool { return s[i].key < s[j].key }
+
+// SortAuthors sorts the authors listed between the lines containing the start
+// and end markers and removes the duplicates. With the "lastname" param, the
+// authors are sorted by last name. Each run of consecutive author lines is
+// sorted separately and reflowed to one author per line, using the comment
+// prefix of its first line. The other lines, like blank lines or titles, are
+// kept in place.
+//
+// proc:
+//  -
+//    name: SortAuthors
+//    params:
+//      - "BEGIN AUTHORS"
+//      - "END AUTHORS"
+//      - lastname
+func (p *Procedures) SortAuthors(dat []byte, start, end string, by ...string) ([]byte, error) {
+	byLastName := false
+	if len(by) > 0 {
+		if by[0] != "lastname" {
+			return dat, fmt.Errorf("unknown sort order %q, expected lastname", by[0])
+		}
+		byLastName = true
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	var res []string
+	for i := 0; i < len(lines); i++ {
+		res = append(res, lines[i])
+		if !strings.Contains(lines[i], start) {
+			continue
+		}
+		j := i + 1
+		for j < len(lines) && !strings.Contains(lines[j], end) {
+			j++
+		}
+		if j == len(lines) {
+			return dat, fmt.Errorf("no %q marker after the line %v", end, i+1)
+		}
+		res = append(res, sortAuthorLines(lines[i+1:j], byLastName)...)
+		i = j - 1
+	}
+	return []byte(strings.Join(res, "")), nil
+}
+
+// sortAuthorLines sorts the runs of author lines of a block.
+func sortAuthorLines(lines []string, byLastName bool) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(lines); {
+		m := authorLineRegex.FindStringSubmatch(strings.TrimRight(lines[i], "\r\n"))
+		if m == nil {
+			res = append(res, lines[i])
+			i++
+			continue
+		}
+
+		prefix := m[1]
+		eol := lines[i][len(strings.TrimRight(lines[i], "\r\n")):]
+		var authors []author
+		for ; i < len(lines); i++ {
+			m := authorLineRegex.FindStringSubmatch(strings.TrimRight(lines[i], "\r\n"))
+			if m == nil {
+				break
+			}
+			for _, name := range strings.Split(m[2], ",") {
+				name = strings.TrimSpace(name)
+				if seen[strings.ToLower(name)] {
+					continue
+				}
+				seen[strings.ToLower(name)] = true
+				authors = append(authors, author{name, authorKey(name, byLastName)})
+			}
+		}
+		sort.Stable(byAuthorKey(authors))
+		for _, a := range authors {
+			res = append(res, prefix+a.name+eol)
+		}
+	}
+	return res
+}
+
+// authorKey returns the sort key of an author, ignoring the case and the email.
+func authorKey(name string, byLastName bool) string {
+	key := strings.ToLower(name)
+	if i := strings.IndexAny(key, "<("); i != -1 {
+		key = strings.TrimSpace(key[:i])
+	}
+	if byLastName {
+		if i := strings.LastIndex(key, " "); i != -1 {
+			key = key[i+1:] + " " + key[:i]
+		}
+	}
+	return key
+}
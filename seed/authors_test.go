@@ -0,0 +1,66 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import "testing"
+
+var authorsGo = `// Package seed fixes source trees.
+//
+// BEGIN AUTHORS
+// Maintainers:
+// Zoe Walker <zoe@example.com>
+// adam Smith, Bea Jones
+// Zoe Walker <zoe@example.com>
+//
+// Contributors:
+// Carl O'Brien (cobrien)
+// END AUTHORS
+package seed
+`
+
+func TestSortAuthors(t *testing.T) {
+	var p *Procedures
+	expected := `// Package seed fixes source trees.
+//
+// BEGIN AUTHORS
+// Maintainers:
+// adam Smith
+// Bea Jones
+// Zoe Walker <zoe@example.com>
+//
+// Contributors:
+// Carl O'Brien (cobrien)
+// END AUTHORS
+package seed
+`
+	res, err := p.SortAuthors([]byte(authorsGo), "BEGIN AUTHORS", "END AUTHORS")
+	if err != nil || string(res) != expected {
+		t.Errorf("SortAuthors: expected\n%s\nbut found %v\n%s", expected, err, res)
+	}
+
+	byLastName := `# BEGIN AUTHORS
+# Bea Jones
+# adam Smith
+# Zoe Walker <zoe@example.com>
+# END AUTHORS
+`
+	src := "# BEGIN AUTHORS\n# Zoe Walker <zoe@example.com>\n#   adam Smith\n# Bea Jones\n# adam smith\n# END AUTHORS\n"
+	res, err = p.SortAuthors([]byte(src), "BEGIN AUTHORS", "END AUTHORS", "lastname")
+	if err != nil || string(res) != byLastName {
+		t.Errorf("SortAuthors: expected\n%s\nbut found %v\n%s", byLastName, err, res)
+	}
+
+	outside := "Zoe\nAdam\n"
+	if res, _ = p.SortAuthors([]byte(outside), "BEGIN AUTHORS", "END AUTHORS"); string(res) != outside {
+		t.Errorf("SortAuthors should only sort the marked blocks but found %q", res)
+	}
+	if _, err = p.SortAuthors([]byte("BEGIN AUTHORS\nZoe\n"), "BEGIN AUTHORS", "END AUTHORS"); err == nil {
+		t.Error("SortAuthors should fail when the end marker is missing")
+	}
+}
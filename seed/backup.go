@@ -0,0 +1,128 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// writeBackup writes the original content of the file to <path><suffix> before it
+// is overwritten. An existing backup is never clobbered: a counter is appended
+// instead, e.g. main.go.bak.1, the highest counter being the latest backup.
+func writeBackup(path string, data []byte, suffix string) (string, error) {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	name := path + suffix
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%s%s.%v", path, suffix, i)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return name, err
+	}
+}
+
+// backupOf returns the path of the file saved by the backup and the counter of the
+// backup, 0 for the first one. It tells false if the path is not a backup.
+func backupOf(path string, suffix string) (string, int, bool) {
+	if strings.HasSuffix(path, suffix) {
+		return strings.TrimSuffix(path, suffix), 0, true
+	}
+	i := strings.LastIndex(path, suffix+".")
+	if i <= 0 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(path[i+len(suffix)+1:])
+	if err != nil || n < 1 {
+		return "", 0, false
+	}
+	return path[:i], n, true
+}
+
+// restoreBackups restores each file of the directory from its latest backup,
+// which is removed. The other backups are kept, so that running it again restores
+// the files as they were before the previous run. Only the files named exactly
+// <path><suffix>[.N] next to the regular file they back up are backups, the other
+// files with the suffix being left alone. It returns the restored files.
+func restoreBackups(root string, suffix string) ([]string, error) {
+	latest := map[string]string{}
+	counters := map[string]int{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		orig, n, ok := backupOf(path, suffix)
+		if !ok {
+			return nil
+		}
+		if info, err := os.Stat(orig); err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if c, found := counters[orig]; !found || n > c {
+			latest[orig], counters[orig] = path, n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var restored []string
+	for orig := range latest {
+		restored = append(restored, orig)
+	}
+	sort.Strings(restored)
+	for _, orig := range restored {
+		if err = os.Rename(latest[orig], orig); err != nil {
+			return nil, fmt.Errorf("Failed to restore %s: %v", shortPath(orig), err)
+		}
+	}
+	return restored, nil
+}
+
+// undo restores the files of the directory fixed with -backup.
+func undo(dir string) error {
+	if dir == "" {
+		dir = dirPath
+	}
+	if backupSuffix == "" {
+		return errors.New("The -backup-suffix flag must not be empty.")
+	}
+	restored, err := restoreBackups(dir, backupSuffix)
+	if err != nil {
+		return err
+	}
+	for _, path := range restored {
+		if verbose {
+			fmt.Printf("Restored file %s\n", shortPath(path))
+		}
+	}
+	fmt.Printf("Restored %v files\n", len(restored))
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupOf(t *testing.T) {
+	for path, expected := range map[string]struct {
+		orig string
+		n    int
+		ok   bool
+	}{
+		"src/main.go.bak":   {"src/main.go", 0, true},
+		"src/main.go.bak.2": {"src/main.go", 2, true},
+		"src/main.go":       {"", 0, false},
+		"src/main.go.bak.x": {"", 0, false},
+		"src/main.go.bak.0": {"", 0, false},
+	} {
+		orig, n, ok := backupOf(path, ".bak")
+		if orig != expected.orig || n != expected.n || ok != expected.ok {
+			t.Errorf("backupOf(%q): %v %v %v was expected but found %v %v %v",
+				path, expected.orig, expected.n, expected.ok, orig, n, ok)
+		}
+	}
+}
+
+func TestBackupAndUndo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "f.txt")
+	streamed := filepath.Join(dir, "streamed.log")
+	ioutil.WriteFile(f, []byte("old\n"), 0644)
+	ioutil.WriteFile(streamed, []byte("old\n"), 0644)
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"new\n"}}}},
+		Transformation{Filter: "*.log", Mode: lineMode, Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+	}}
+
+	backup, backupSuffix = true, ".bak"
+	defer func() { backup = false }()
+	files := []string{f, streamed}
+	if count, err := processFiles(context.Background(), files, tdf, nil); count != 2 || err != nil {
+		t.Fatalf("processFiles: 2 files should be fixed but found %v (%v)", count, err)
+	}
+	if dat, _ := ioutil.ReadFile(f + ".bak"); string(dat) != "old\n" {
+		t.Errorf("The backup should hold the original content but found %q", dat)
+	}
+	if dat, _ := ioutil.ReadFile(streamed + ".bak"); string(dat) != "old\n" {
+		t.Errorf("The line mode files should be backed up too but found %q", dat)
+	}
+
+	// A second run must not clobber the first backup
+	if _, err = processFiles(context.Background(), []string{f}, tdf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if dat, _ := ioutil.ReadFile(f + ".bak"); string(dat) != "old\n" {
+		t.Errorf("The first backup should be kept but found %q", dat)
+	}
+	if dat, _ := ioutil.ReadFile(f + ".bak.1"); string(dat) != "old\nnew\n" {
+		t.Errorf("A counter should be appended to the second backup but found %q", dat)
+	}
+
+	// The backups are neither fixed nor backed up again
+	if walked := mustWalkDir(t, dir, "", ""); len(walked) != 2 {
+		t.Errorf("walkDir should skip the backups with -backup but found %v", walked)
+	}
+
+	// Each undo restores the files as they were before the previous run
+	ioutil.WriteFile(filepath.Join(dir, "notes.bak"), []byte("mine\n"), 0644)
+	restored, err := restoreBackups(dir, ".bak")
+	if err != nil || len(restored) != 2 {
+		t.Fatalf("restoreBackups should restore 2 files but found %v (%v)", restored, err)
+	}
+	if dat, _ := ioutil.ReadFile(f); string(dat) != "old\nnew\n" {
+		t.Errorf("The latest backup should be restored but found %q", dat)
+	}
+	if dat, _ := ioutil.ReadFile(streamed); string(dat) != "old\n" {
+		t.Errorf("The line mode file should be restored but found %q", dat)
+	}
+	if _, err = os.Stat(f + ".bak.1"); !os.IsNotExist(err) {
+		t.Error("The restored backup should be removed")
+	}
+
+	if restored, err = restoreBackups(dir, ".bak"); err != nil || len(restored) != 1 {
+		t.Fatalf("restoreBackups should restore 1 file but found %v (%v)", restored, err)
+	}
+	if dat, _ := ioutil.ReadFile(f); string(dat) != "old\n" {
+		t.Errorf("The first backup should be restored but found %q", dat)
+	}
+	if remaining, _ := filepath.Glob(filepath.Join(dir, "*.bak*")); len(remaining) != 1 {
+		t.Errorf("All the backups should be removed but found %v", remaining)
+	}
+	if dat, _ := ioutil.ReadFile(filepath.Join(dir, "notes.bak")); string(dat) != "mine\n" {
+		t.Errorf("The .bak file without original should be left alone but found %q", dat)
+	}
+}
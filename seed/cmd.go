@@ -9,6 +9,9 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/BurntSushi/toml"
@@ -16,43 +19,146 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"text/template"
 	"time"
 	"os"
-	"bufio"
 )
 
 const (
 	fixHelp = `Fix the files in a given directory based on a YAML transformation description file. 
 If no directory is passed as argument, the transformations will be applied on current directory.
+On SIGINT or SIGTERM, the files in progress are finished and the other ones are left untouched,
+then the number of fixed files is printed and seed exits with 130.
 
 Usage: 
-  seed [flags] fix [directory/to/transform]
+  seed [flags] fix [directory/to/transform | file/to/transform]
+  seed [flags] -repos repos.txt fix
 
 Available flags:
- -t file/path.yml: the YAML transformation description file
+ -t file/path.yml: the YAML transformation description file, ./tdf.yml by default.
+                   Exits with 3 if the default file does not exist.
+ -repos file/path.txt: fix each repository listed in the file, one path per line, instead of a single
+                       directory. Relative paths are resolved from the file directory, blank lines and
+                       lines starting with "#" are ignored. The repositories are fixed one after the other,
+                       the failure of one not stopping the others, and the number of fixed files is printed
+                       for each of them followed by the total.
+ -git-range A..B: only fix the files changed between the two revisions, as listed by "git diff A..B",
+                 e.g. to fix the files touched by a pull request in CI. The files deleted in the range
+                 are ignored and the renamed ones are fixed under their new name. The Exclude patterns
+                 and the filters of the transformations still apply.
+ -gitignore: skip the directories and files ignored by the .gitignore files of the tree, and of its parent
+            directories up to the top of the git working tree, e.g. vendor/ or the build outputs. Leading and
+            trailing "/", "*", "**" and the "!" negations are supported. The .git directories are skipped too.
+ -incremental file/path.json: only fix the files changed since their last fix, for the large repositories fixed
+                             often. The state file records the git commit at which each file was last fixed, along
+                             with a hash of the transformation file and of the seed version. A file is fixed again
+                             when "git diff" shows it changed since its commit, committed or not, when it is not
+                             tracked or when it failed. Changing the transformation file, its -var values or the
+                             version of seed forgets all the commits. The state file is not written by -check or
+                             -dry-run, nor after an interruption. Delete it to fix all the files again.
+ -no-require-git: allow to fix a directory which is not inside a git working tree
+ -fixpoint: apply the transformations again until the files do not change anymore,
+           for transformations enabling each other
+ -max-iterations N: maximum number of passes over a file in fixpoint mode (default 10).
+                    A file still changing after N passes is reported as an error.
+ -var key=value: render the transformation file as a text/template with the given variables,
+                 e.g. {{.OldPkg}}. The rendering happens before the file is parsed. Can be repeated.
+ -check: report the files which would be fixed without writing them. Exits with 1 if any.
+ -dry-run: print the files which would be fixed with the number of lines they would gain and lose,
+           e.g. "Would fix src/main.go (+2 -2)", without writing any file. Unlike -check, it exits with 0.
+ -report sarif|json|junit: with -check, print a report of the changes to the standard output, the summary
+                     being printed to the standard error. "sarif" reports each change as a SARIF result,
+                     "junit" reports each changed file as a failing test case named by transformation and
+                     each unchanged file as a passing one, for the CI test panels,
+                     "json" adds the number of files matched and changed by each transformation.
+ -events jsonl: print a JSON object per processed file as soon as it is processed, for a live dashboard,
+               e.g. {"path":"main.go","changed":true,"durationMs":1.2,"transformations":["rename"]}.
+               The other outputs are printed to the standard error. It cannot be used with -report.
+ -list-matches: print the matches of the first procedure pattern of each transformation, like "grep -n",
+               e.g. "src/main.go:12:5: rename: \"old\"", without editing any file. The literal strings of
+               Replace are matched as they are, the transformations without pattern are ignored. Only the
+               files selected by a transformation and satisfying its preconditions are searched.
+ -diff: print the unified diff of each changed file, e.g. with -check or -dry-run to preview the changes.
+ -diff-context N: number of unchanged lines shown around each change of the diffs (default 3).
+ -group-by file|transformation: with "transformation", list the changed files under each transformation,
+                                followed by their diffs with -diff, to review one rule at a time. The
+                                diffs show all the changes of the files. Files are listed by path by default.
+ -j N: number of files processed at the same time, the number of CPUs by default.
+ -sort: process the files one at a time in path order instead of concurrently, for deterministic runs.
+ -global-sequence: share the counter of the Sequence procedures between all the files, for globally unique
+                   numbers. It implies -sort, the files being numbered in path order to get the same
+                   numbers on each run.
+ -strict: report as errors the files which cannot be parsed by the procedures needing a valid file,
+          like JSONCanonical or MergeImportBlocks, instead of skipping them.
+ -warn-long N: report the files with more than N lines, e.g. to find the files to refactor. They are
+               listed after the run, and under "longFiles" in the JSON report, but not modified.
+ -summary: print the number of files matched and changed by each transformation, pointing out
+           the ones which had no effect.
+ -skip-tdf: do not transform the transformation file when it is inside the fixed directory (default).
+ -include-tdf: transform the transformation file like the other files, same as -skip-tdf=false.
+ -backup: before overwriting a file, save its original content next to it as <path>.bak. An existing
+          backup is kept and a counter is appended instead, e.g. main.go.bak.1. 'seed undo' restores
+          the files from their latest backup.
+ -backup-suffix .ext: suffix of the backup files (default ".bak").
+ -include-binary: transform the binary files too. The files with a NUL byte in their first 8000 bytes,
+                  like images or archives, are considered binary, as git does, and skipped by default.
 
 YAML transformation description file format:
 
 The description file accepts a list of transformation. Each transformation can have include files or exclude directories. 
-It can also use higher level preconditions with "pre" which uses the file content. Finally, it takes a list of procedure to apply the file. 
+The top-level "Exclude" patterns, separated by "|", skip the matching directories and files of the whole tree, e.g.
+"node_modules|.git|vendor|*.min.js". A pattern with a "/" is matched against the path relative to the fixed directory.
+Include patterns starting with "!" exclude the files they match, e.g. "*.go|!*_test.go" selects the Go files but the tests.
+It can also use higher level preconditions with "pre" which uses the file content, or with "cond" for the preconditions taking
+params, like procedures. A precondition starting with "!" is negated, e.g. "!ValueIn". Finally, it takes a list of procedure to apply the file. 
 Procedures are described with their name and the arguments to pass. See the following 'tdf.yaml' file as example. 
+When a procedure fails, "OnError" decides whether the file is aborted (fail, the default), the error is logged (warn)
+or silently ignored (skip).
+
+A param of the form "$env:NAME" is replaced by the value of the NAME environment variable each time
+the procedure runs, e.g. for a build number known when the transformation is applied. A variable which
+is not set gives an empty value, or an error with -strict. Use "$$env:" for a literal "$env:".
+
+With "Mode: line", the procedures of a transformation are applied to each line separately and the files are
+streamed instead of being loaded in memory. Only line-local procedures, like Replace or DeleteLine, are accepted.
+
+YAML anchors, aliases and merge keys can be used to share blocks between transformations. The shared blocks
+can be declared under a top-level "definitions" key, which seed ignores. Note that 'seed migrate' writes the
+file with the aliases expanded.
+
+In any format, a chain of procedures can also be named under the top-level "macros" map and inserted in
+place of a "UseMacro" procedure taking the name of the macro, e.g. {Name: UseMacro, Params: [normalize]}.
+The macros are expanded when the file is loaded and can use other macros.
+
+The "Version" field tells which version of the format the file uses. Files without version are
+considered as version 1 and can be upgraded with 'seed migrate tdf.yml'.
 
 tdf.yml
 ----------------
 - 
+ Name: Rename old
  Include: "*.go|*.yml"
  Exclude: "*.out"
  pre: 
   - AlwaysTrue
   - ...
+ cond:
+  -
+   Name: ValueIn
+   Params:
+    - "env: *(\\w+)"
+    - "dev"
  proc:
   - Replace
    Name: Replace
    Params:
     - "old"
     - "new"
+   OnError: warn
   - ...
 -
  ...
@@ -61,44 +167,149 @@ tdf.yml
 	seedHelp = `Usage: seed <command> <args>
 
 Commands:
-    fix    Apply source transformation on a directory, based on a YAML transformation file
-    help   Provide help for seed commands 
+    fix      Apply source transformation on a directory, based on a YAML transformation file
+    migrate  Upgrade a transformation file to the current format version
+    convert  Convert a transformation file to another format: seed -to toml [-o path] convert tdf.yml
+    tdf-diff Compare two transformation files, whatever their format
+    undo     Restore the files fixed with -backup from their latest backup: seed undo [directory]
+    validate Check the procedures, preconditions and patterns of a transformation file: seed -t tdf.yml validate
+    version  Print the version, git commit and build date of seed, also printed by -version
+    help     Provide help for seed commands 
 
 See 'seed help <command>' to read about a specific subcommand.
 `
 )
 
+// The version of seed, its git commit and build date, stamped by the release
+// build with -ldflags, e.g. -X main.buildVersion=1.2.0.
+var (
+	buildVersion = "dev"
+	buildCommit  = "dev"
+	buildDate    = "dev"
+)
+
+// defaultTdfPath is the transformation file used when -t is not given.
+const defaultTdfPath = "./tdf.yml"
+
+// exitMissingTdf is the exit code of fix when the default transformation file does not exist.
+const exitMissingTdf = 3
+
+// exitInterrupted is the exit code of fix when it is interrupted by a signal.
+const exitInterrupted = 130
+
+// currentVersion is the version of the transformation file format
+// supported by seed. Version 2 introduced the Name and Include fields
+// of the transformations, Include replacing Filter.
+const currentVersion = 2
+
 // T correspond to the content of a transformation file.
-// It contains exclude directories and an array of transformations.
+// It contains the format version, exclude patterns, the
+// macros shared by the transformations and an array of
+// transformations.
 type T struct {
-	Exclude         string
+	Version         int                    `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Exclude         string                 `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Macros          map[string][]Procedure `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
 	Transformations []Transformation
 }
 
 // Transformation is a strutucture representating a set
-// of procedure to apply on a source code directory
+// of procedure to apply on a source code directory.
+// Files are selected with the Include patterns, Filter
+// being the version 1 equivalent. Cond lists the preconditions
+// taking params, in addition to Pre. With the "line" Mode,
+// the procedures are applied to each line of the files.
 type Transformation struct {
-	Filter string
-	Pre    []string
-	Proc   []Procedure
+	Name    string      `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Filter  string      `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Include []string    `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Mode    string      `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Pre     []string    `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Cond    []Procedure `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Proc    []Procedure
 }
 
 // Procedure is a function call with a method name and
-// its parameters
+// its parameters. OnError tells what to do when the procedure
+// fails: "fail" (default) aborts the file, "warn" logs the error
+// and "skip" silently ignores it. Constructs like WithinCapture
+// apply the nested procedures Proc to a part of the data.
 type Procedure struct {
-	Name   string
-	Params []string
+	Name    string
+	Params  []string    `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	OnError string      `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Proc    []Procedure `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
 }
 
 var transPath string
 var verbose bool
 var vverbose bool
+var noRequireGit bool
+var fixpoint bool
+var maxIterations int
+var check bool
+var dryRun bool
+var report string
+var summary bool
+var showDiff bool
+var groupBy string
+var sortFiles bool
+var globalSequence bool
+var strict bool
+var skipTdf bool
+var warnLong int
+var convertTo string
+var jobs int
+var convertOutput string
+var includeTdf bool
+var backup bool
+var backupSuffix string
+var includeBinary bool
+var reposPath string
+var events string
+var diffContext int
+var listMatches bool
+var gitRange string
+var gitignore bool
+var incremental string
+var showVersion bool
+var tdfVars = varsFlag{}
 var dirPath = "./"
 
 func init() {
-	flag.StringVar(&transPath, "t", "./tdf.yml", "Specify the path to the transformation description file")
+	flag.StringVar(&transPath, "t", defaultTdfPath, "Specify the path to the transformation description file")
 	flag.BoolVar(&verbose, "v", false, "Enable verbose mode.")
 	flag.BoolVar(&vverbose, "vv", false, "Enable very verbose mode.")
+	flag.Var(tdfVars, "var", "Set a key=value variable used to render the transformation file as a template. Can be repeated.")
+	flag.StringVar(&reposPath, "repos", "", "Fix each repository listed in the given file, one path per line.")
+	flag.StringVar(&gitRange, "git-range", "", "Only fix the files changed in the given git range, e.g. main..HEAD.")
+	flag.BoolVar(&gitignore, "gitignore", false, "Skip the paths ignored by the .gitignore files.")
+	flag.StringVar(&incremental, "incremental", "", "Skip the files unchanged in git since their last fix, recorded in the given state file.")
+	flag.BoolVar(&noRequireGit, "no-require-git", false, "Allow to fix a directory which is not inside a git working tree.")
+	flag.BoolVar(&fixpoint, "fixpoint", false, "Apply the transformations to each file until it does not change anymore.")
+	flag.IntVar(&maxIterations, "max-iterations", 10, "Maximum number of passes over a file in fixpoint mode.")
+	flag.BoolVar(&check, "check", false, "Report the files which would be fixed without writing them.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the files which would be fixed, with their number of changed lines, without writing them.")
+	flag.StringVar(&report, "report", "", "Print a report of the changes in the given format (sarif, json or junit), requires -check.")
+	flag.BoolVar(&summary, "summary", false, "Print the number of files matched and changed by each transformation.")
+	flag.StringVar(&events, "events", "", "Print an event per processed file in the given format (jsonl).")
+	flag.BoolVar(&listMatches, "list-matches", false, "Print the matches of the first procedure pattern of each transformation without editing the files.")
+	flag.BoolVar(&showDiff, "diff", false, "Print the unified diff of each changed file.")
+	flag.IntVar(&diffContext, "diff-context", 3, "Number of unchanged lines around each change of the diffs.")
+	flag.IntVar(&warnLong, "warn-long", 0, "Report the files longer than the given number of lines, without modifying them.")
+	flag.StringVar(&groupBy, "group-by", "file", "Group the changed files and their diffs by file or by transformation.")
+	flag.IntVar(&jobs, "j", runtime.NumCPU(), "Number of files processed at the same time.")
+	flag.BoolVar(&sortFiles, "sort", false, "Process the files one at a time in path order.")
+	flag.BoolVar(&globalSequence, "global-sequence", false, "Share the numbers of the Sequence procedures between all the files, implies -sort.")
+	flag.BoolVar(&strict, "strict", false, "Report the files which cannot be parsed by the procedures instead of skipping them.")
+	flag.BoolVar(&skipTdf, "skip-tdf", true, "Do not transform the transformation file when it is inside the fixed directory.")
+	flag.BoolVar(&includeTdf, "include-tdf", false, "Transform the transformation file like the other files, same as -skip-tdf=false.")
+	flag.BoolVar(&backup, "backup", false, "Save the original content of the fixed files next to them before writing them.")
+	flag.StringVar(&backupSuffix, "backup-suffix", ".bak", "Suffix of the backup files written with -backup.")
+	flag.BoolVar(&includeBinary, "include-binary", false, "Transform the binary files, which are skipped by default.")
+	flag.StringVar(&convertTo, "to", "", "Format of the converted transformation file: yaml, toml or json.")
+	flag.StringVar(&convertOutput, "o", "", "Path of the converted transformation file, the input path with the extension of the format by default.")
+	flag.BoolVar(&showVersion, "version", false, "Print the version of seed, same as 'seed version'.")
 	flag.Parse()
 
 	if vverbose {
@@ -107,64 +318,305 @@ func init() {
 }
 
 func main() {
-	switch flag.Arg(0) {
+	opts := Options{Command: flag.Arg(0)}
+	if flag.NArg() > 1 {
+		opts.Args = flag.Args()[1:]
+	}
+	if err := Run(opts); err != nil {
+		if exit, ok := err.(exitError); ok {
+			if exit.err != nil {
+				fmt.Fprintln(os.Stderr, exit.err)
+			}
+			os.Exit(exit.code)
+		}
+		log.Fatal(err)
+	}
+}
+
+// Options are the seed command to run and its arguments, e.g. the directory
+// to fix. The flags are read from the package variables they are bound to,
+// like transPath for -t, which a caller must set before Run. Run is therefore
+// not safe for concurrent use, and seed is not a library yet: Options does not
+// carry the settings of a run.
+type Options struct {
+	Command string
+	Args    []string
+}
+
+// exitError ends seed with a specific exit code. Its error, if any, is printed
+// without the log prefix, the other failures having already been reported.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %v", e.code)
+	}
+	return e.err.Error()
+}
+
+// Run runs the seed command of the options, so that seed can be driven without
+// its command line. It returns the error ending the command, an exitError when
+// the command fails with a specific exit code. An invalid transformation file
+// is reported before any file is written, and a directory which cannot be
+// walked before the files of its tree are.
+func Run(opts Options) error {
+	arg := func(i int) string {
+		if i < len(opts.Args) {
+			return opts.Args[i]
+		}
+		return ""
+	}
+	if showVersion {
+		fmt.Println(versionLine())
+		return nil
+	}
+	switch opts.Command {
+	case "version":
+		fmt.Println(versionLine())
 	case "fix":
-		fix()
+		return fix(arg(0))
 	case "convert":
-		convertTdf(flag.Arg(1), flag.Arg(2))
+		to := convertTo
+		if to == "" {
+			to = arg(1)
+		}
+		return convertTdf(arg(0), to, convertOutput)
+	case "migrate":
+		return migrate(arg(0))
+	case "tdf-diff":
+		return tdfDiff(arg(0), arg(1))
+	case "undo":
+		return undo(arg(0))
+	case "validate":
+		return validate()
 	case "help":
-		if flag.Arg(1) == "fix" {
+		if arg(0) == "fix" {
 			fmt.Println(fixHelp)
 		}
 	default:
 		fmt.Println(seedHelp)
 	}
+	return nil
 }
 
-func fix() {
+func fix(dir string) error {
 	start := time.Now()
 
-	var dat []byte
-	var tdfPath string
-
-	if strings.HasPrefix(transPath, "http://") || strings.HasPrefix(transPath, "https://") {
-		dat = fetchURL(transPath)
-	} else {
-		dat = readFile(transPath)
+	dat, tdfPath, format, err := readTransPath()
+	if err != nil {
+		return err
 	}
-
 	if verbose {
-		fmt.Printf("Apply transformations from: %s.\n\n---\n", transPath)
+		fmt.Fprintf(logOutput(), "Apply transformations from: %s.\n\n---\n", transPath)
 	}
-
-	format, err := getFormat(transPath)
+	transf, err := parseTdf(dat, format)
 	if err != nil {
-		log.Fatalf("Unsupported format for %s", transPath)
+		return err
+	}
+
+	var runStats *runReport
+	if report != "" && !check {
+		return errors.New("The -report flag requires -check.")
+	}
+	if events != "" && events != "jsonl" {
+		return fmt.Errorf("Unknown -events format %q, expected jsonl.", events)
+	}
+	if events != "" && report != "" {
+		return errors.New("The -events and -report flags cannot be used together.")
+	}
+	if diffContext < 0 {
+		return errors.New("The -diff-context flag must not be negative.")
+	}
+	if backup && backupSuffix == "" {
+		return errors.New("The -backup-suffix flag must not be empty.")
+	}
+	if groupBy != "file" && groupBy != "transformation" {
+		return fmt.Errorf("Unknown -group-by value %q, expected file or transformation.", groupBy)
+	}
+	if jobs < 1 {
+		return errors.New("The -j flag must be at least 1.")
+	}
+	if warnLong < 0 {
+		return errors.New("The -warn-long flag must not be negative.")
+	}
+	if report != "" || summary || showDiff || groupBy == "transformation" || warnLong > 0 {
+		runStats = newRunReport(transf)
 	}
-	transf := parseTdf(dat, format)
 
 	// set the directory to parse if specified
-	if flag.Arg(1) != "" {
-		absPath, errFilePath := filepath.Abs(flag.Arg(1))
+	if dir != "" {
+		if reposPath != "" {
+			return errors.New("The -repos flag cannot be used with a directory argument.")
+		}
+		absPath, errFilePath := filepath.Abs(dir)
 		if errFilePath != nil {
-			log.Fatal("Error constructing the file path.\n", errFilePath)
+			return fmt.Errorf("Error constructing the file path.\n%v", errFilePath)
 		}
 		dirPath = absPath
 	}
 
-	files := walkDir(dirPath, transf.Exclude, tdfPath)
-	count := processFiles(files, transf)
+	if incremental != "" && reposPath != "" {
+		return errors.New("The -incremental flag cannot be used with -repos.")
+	}
+	if listMatches {
+		if reposPath != "" {
+			return errors.New("The -list-matches flag cannot be used with -repos.")
+		}
+		files, err := filesToFix(dirPath, transf.Exclude, tdfSkipPath(tdfPath))
+		if err != nil {
+			return err
+		}
+		if files, err = keepGitRange(dirPath, files); err != nil {
+			return err
+		}
+		count, err := writeMatches(os.Stdout, files, transf)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\n%v matches in %v files\n", count, len(files))
+		return nil
+	}
+
+	var repos []string
+	if reposPath != "" {
+		if repos, err = readRepos(reposPath); err != nil {
+			return fmt.Errorf("Failed to read the repositories from %s: %v", reposPath, err)
+		}
+	} else if !noRequireGit {
+		if err := checkGitWorkTree(dirPath); err != nil {
+			return err
+		}
+	}
+
+	var files []string
+	var count int
+	var results []repoResult
+	ctx, stopSignals := interruptContext()
+	if repos != nil {
+		results = fixRepos(ctx, repos, transf, tdfPath, runStats)
+	} else {
+		if files, err = filesToFix(dirPath, transf.Exclude, tdfSkipPath(tdfPath)); err != nil {
+			return err
+		}
+		if files, err = keepGitRange(dirPath, files); err != nil {
+			return err
+		}
+		var state *incrementalState
+		if incremental != "" {
+			if state, files, err = startIncremental(incremental, dirPath, files, dat); err != nil {
+				return err
+			}
+		}
+		count, err = processFiles(ctx, files, transf, runStats)
+		if state != nil {
+			if saveErr := state.finish(files, err); saveErr != nil && err == nil {
+				err = saveErr
+			}
+		}
+	}
+	stopSignals()
 
 	elapsed := time.Since(start)
 	var shortDirPath = filepath.Base(dirPath)
 	if shortDirPath == "." {
 		wd, err := os.Getwd()
 		if err != nil {
-			log.Fatalf("Failed to get current dir: %s", err)
+			return fmt.Errorf("Failed to get current dir: %s", err)
 		}
 		shortDirPath = filepath.Base(wd)
 	}
-	fmt.Printf("\n%s fixed %v/%v files in %s\n", shortDirPath, count, len(files), elapsed)
+
+	// Keep the standard output for the report or the events
+	out := logOutput()
+	if report != "" {
+		res, err := runStats.writeReport(report, transf)
+		if err != nil {
+			return fmt.Errorf("Failed to write the report: %s", err)
+		}
+		os.Stdout.Write(res)
+		out = os.Stderr
+	}
+	if groupBy == "transformation" {
+		runStats.writeGroups(out, transf, showDiff)
+	} else if showDiff {
+		runStats.writeDiffs(out)
+	}
+
+	action := "fixed"
+	if check || dryRun {
+		action = "would fix"
+	}
+	if repos != nil {
+		writeRepoResults(out, results, action)
+		for _, res := range results {
+			count += res.count
+		}
+		err = reposError(results)
+	} else {
+		fmt.Fprintf(out, "\n%s %s %v/%v files in %s\n", shortDirPath, action, count, len(files), elapsed)
+	}
+	if summary {
+		fmt.Fprintln(out)
+		runStats.writeSummary(out, transf)
+	}
+	if warnLong > 0 {
+		runStats.writeLongFiles(out)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "\n%v\n", err)
+		if _, ok := err.(interruptedError); ok {
+			return exitError{code: exitInterrupted}
+		}
+		return exitError{code: 1}
+	}
+	if check && count > 0 {
+		return exitError{code: 1}
+	}
+	return nil
+}
+
+// versionLine returns the version of seed on a single line of key=value pairs,
+// e.g. "seed version=1.2.0 commit=4f2a9c1 date=2024-05-02T10:00:00Z".
+func versionLine() string {
+	return fmt.Sprintf("seed version=%s commit=%s date=%s", buildVersion, buildCommit, buildDate)
+}
+
+// varsFlag collects the values of the repeated -var key=value flags.
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	var pairs []string
+	for key, value := range v {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v varsFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("expected key=value but found %s", s)
+	}
+	v[s[:i]] = s[i+1:]
+	return nil
+}
+
+// renderTdf executes the transformation file as a text/template with the given
+// variables. It runs on the raw bytes, before the file is parsed, so variables
+// can be used anywhere, e.g. in procedure params or filters.
+func renderTdf(dat []byte, vars map[string]string) ([]byte, error) {
+	tmpl, err := template.New("tdf").Option("missingkey=error").Parse(string(dat))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, vars); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 func getFormat(name string) (string, error) {
@@ -179,6 +631,8 @@ func getFormat(name string) (string, error) {
 		ext = "yml"
 	case "toml":
 		ext = "toml"
+	case "json":
+		ext = "json"
 	default:
 		err = fmt.Errorf("%s format unsupported", extension)
 	}
@@ -186,90 +640,316 @@ func getFormat(name string) (string, error) {
 	return ext, err
 }
 
-func fetchURL(url string) []byte {
-	resp, err := http.Get(transPath)
+// tdfFormatName returns the name giving the format of the transformation file:
+// the path itself, or the path of the URL without its query for a remote file,
+// e.g. "/tdf.json" for "https://example.com/tdf.json?ref=main".
+func tdfFormatName(path string) string {
+	if u, err := url.Parse(path); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return u.Path
+	}
+	return path
+}
+
+// httpClient fetches the remote files, the transformation file or the data of
+// the procedures, without waiting forever for a server.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchURL downloads a file, e.g. the transformation file. It returns its
+// content and its final URL, after the redirects.
+func fetchURL(url string) ([]byte, string, error) {
+	resp, err := httpClient.Get(url)
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode > 299 {
-		log.Fatalf("Error %v when fetching %s\n", resp.StatusCode, transPath)
+		return nil, "", fmt.Errorf("Error %v when fetching %s", resp.StatusCode, url)
 	}
 
-	body, err2 := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal("Error reading http reponse.\n", err2)
+		return nil, "", fmt.Errorf("Error reading http reponse.\n%v", err)
 	}
+	return body, resp.Request.URL.String(), nil
+}
 
-	return body
+// tdfSkipPath returns the path of the transformation file to exclude from the
+// fixed files, or an empty string when it should be transformed too.
+func tdfSkipPath(tdfPath string) string {
+	if includeTdf || !skipTdf {
+		return ""
+	}
+	return tdfPath
 }
 
-func readFile(path string) []byte {
+// checkDefaultTdf returns a friendly error when the default transformation file
+// is used but does not exist, the most common mistake on a first run.
+func checkDefaultTdf(path string) error {
+	if path != defaultTdfPath {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("No transformation file found: there is no tdf.yml in the current directory.\n" +
+			"Give the path or URL of the transformation file with -t:\n\n" +
+			"  seed -t path/to/tdf.yml fix\n\n" +
+			"or create tdf.yml here, starting from:\n\n" +
+			"  version: 2\n" +
+			"  transformations:\n" +
+			"    - include: [\"*.go\"]\n" +
+			"      proc:\n" +
+			"        - name: Replace\n" +
+			"          params: [\"old\", \"new\"]\n\n" +
+			"See 'seed help fix' for the format of the file.")
+	}
+	return nil
+}
+
+// readFile reads the transformation file. It returns its content and its
+// absolute path, used to exclude it from the fixed files.
+func readFile(path string) ([]byte, string, error) {
 	absPath, errFilePath := filepath.Abs(path)
 	tdfPath := absPath
 
 	if errFilePath != nil {
-		log.Fatal("Error constructing the file path.\n", errFilePath)
+		return nil, "", fmt.Errorf("Error constructing the file path.\n%v", errFilePath)
 	}
 
 	bytes, err := ioutil.ReadFile(tdfPath)
 	if err != nil {
-		log.Fatal("Unable to read the transformation description file.\n", err)
+		return nil, "", fmt.Errorf("Unable to read the transformation description file.\n%v", err)
 	}
-	return bytes
+	return bytes, tdfPath, nil
 }
 
-func parseTdf(dat []byte, format string) T {
-	var t T
+// readTransPath reads the transformation file given by -t, from a path or an
+// URL, and renders its -var variables. It returns its content, its absolute
+// path when it is a local file and its format. A missing default file is an
+// exitError with the exitMissingTdf code.
+func readTransPath() ([]byte, string, string, error) {
+	var dat []byte
+	var tdfPath string
+	var err error
+
+	if strings.HasPrefix(transPath, "http://") || strings.HasPrefix(transPath, "https://") {
+		if dat, _, err = fetchURL(transPath); err != nil {
+			return nil, "", "", err
+		}
+	} else {
+		if err := checkDefaultTdf(transPath); err != nil {
+			return nil, "", "", exitError{code: exitMissingTdf, err: err}
+		}
+		if dat, tdfPath, err = readFile(transPath); err != nil {
+			return nil, "", "", err
+		}
+	}
 
+	format, err := getFormat(tdfFormatName(transPath))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Unsupported format for %s", transPath)
+	}
+	if len(tdfVars) > 0 {
+		dat, err = renderTdf(dat, tdfVars)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("Failed to render the transformation file %s: %s", transPath, err)
+		}
+	}
+	return dat, tdfPath, format, nil
+}
+
+// loadTdf reads and parses the transformation file from a path or an URL.
+func loadTdf(path string) (T, error) {
+	dat, format, err := readTdf(path)
+	if err != nil {
+		return T{}, err
+	}
+	return parseTdf(dat, format)
+}
+
+// readTdf reads the transformation file, local or remote, and returns its
+// content and format.
+func readTdf(path string) ([]byte, string, error) {
+	format, err := getFormat(tdfFormatName(path))
+	if err != nil {
+		return nil, "", fmt.Errorf("Unsupported format for %s", path)
+	}
+	var dat []byte
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		dat, _, err = fetchURL(path)
+	} else {
+		dat, _, err = readFile(path)
+	}
+	return dat, format, err
+}
+
+// parseTdf parses the transformation file in the given format and checks its
+// version, its patterns and its procedures and preconditions, so that a wrong
+// file is rejected before any file is transformed.
+func parseTdf(dat []byte, format string) (T, error) {
+	t, err := prepareTdf(dat, format)
+	if err != nil {
+		return t, err
+	}
+	if err := checkPatterns(t); err != nil {
+		return t, err
+	}
+	if problems := validateTdf(t); len(problems) > 0 {
+		return t, tdfProblems(problems)
+	}
+	return t, nil
+}
+
+// prepareTdf decodes the transformation file, checks its version and expands
+// its macros.
+func prepareTdf(dat []byte, format string) (T, error) {
+	t, err := decodeTdf(dat, format)
+	if err != nil {
+		return t, err
+	}
+	if err := checkVersion(t); err != nil {
+		return t, err
+	}
+	return expandMacros(t)
+}
+
+// decodeTdf parses the transformation file in the given format.
+func decodeTdf(dat []byte, format string) (T, error) {
+	var t T
+	var err error
 	switch format {
 	case "yml":
-		err := yaml.Unmarshal(dat, &t)
-		if err != nil {
-			log.Fatalf("Failed to parse the yaml file: %s", err)
+		if err = yaml.Unmarshal(dat, &t); err != nil {
+			err = fmt.Errorf("Failed to parse the yaml file: %s", err)
 		}
 	case "toml":
-		err := toml.Unmarshal(dat, &t)
-		if err != nil {
-			log.Fatalf("Failed to parse the toml file: %s", err)
+		if err = toml.Unmarshal(dat, &t); err != nil {
+			err = fmt.Errorf("Failed to parse the toml file: %s", err)
+		}
+	case "json":
+		if err = json.Unmarshal(dat, &t); err != nil {
+			err = fmt.Errorf("Failed to parse the json file: %s", err)
+		}
+	}
+	return t, err
+}
+
+func checkVersion(t T) error {
+	if t.Version > currentVersion {
+		return fmt.Errorf("The transformation file uses the version %v of the format but seed only supports "+
+			"up to the version %v. Please upgrade seed.", t.Version, currentVersion)
+	}
+	return nil
+}
+
+func encodeTdf(t T, format string) ([]byte, error) {
+	switch format {
+	case "yml":
+		return yaml.Marshal(t)
+	case "toml":
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(t)
+		return buf.Bytes(), err
+	case "json":
+		res, err := json.MarshalIndent(t, "", "  ")
+		return append(res, '\n'), err
+	}
+	return nil, fmt.Errorf("%s format unsupported", format)
+}
+
+// migrateTdf upgrades the transformation description to the current version of the format.
+func migrateTdf(t T) T {
+	transformations := make([]Transformation, len(t.Transformations))
+	for i, tr := range t.Transformations {
+		// Version 2: Filter is replaced by Include
+		if tr.Filter != "" {
+			tr.Include = append(strings.Split(tr.Filter, "|"), tr.Include...)
+			tr.Filter = ""
 		}
+		transformations[i] = tr
 	}
+	t.Transformations = transformations
+	t.Version = currentVersion
 	return t
 }
 
-func convertTdf(path, newFormat string) {
-	index := strings.LastIndex(path, ".") + 1
-	format, err := getFormat(path[index:])
+func migrate(path string) error {
+	dat, format, err := readTdf(path)
 	if err != nil {
-		log.Fatalf("Unsupported format for %s", path)
+		return err
 	}
-	
-	bytes, err := ioutil.ReadFile(path)
+	// The macros are kept as they are, not expanded like for fix
+	t, err := decodeTdf(dat, format)
 	if err != nil {
-		log.Fatal("Unable to read the transformation description file.\n", err)
+		return err
+	}
+	if err = checkVersion(t); err != nil {
+		return err
+	}
+
+	version := t.Version
+	if version == 0 {
+		version = 1
+	}
+	if version == currentVersion {
+		fmt.Printf("%s is already at version %v\n", path, currentVersion)
+		return nil
 	}
-	var t T
-	var res []byte
 
-	f, err := os.Create(path[:index] + "toml")
+	res, err := encodeTdf(migrateTdf(t), format)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("Failed to encode %s: %s", path, err)
 	}
-	
-	writer := bufio.NewWriter(f)
-	
-	if newFormat == "toml" && (format == "yaml" || format == "yml") {
-		err := yaml.Unmarshal(bytes, &t)
-		if err != nil {
-			log.Fatalf("Failed to parse the yaml file: %s", err)
-		}
+	if err = ioutil.WriteFile(path, res, 0644); err != nil {
+		return fmt.Errorf("Unable to write the transformation description file.\n%v", err)
+	}
+	fmt.Printf("Migrated %s from version %v to %v\n", path, version, currentVersion)
+	return nil
+}
 
-		if err = toml.NewEncoder(writer).Encode(t); err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		log.Fatalf("%s format is not supported for convertion", format)
+// convertTdf converts the transformation file to the given format, inferring its
+// format from its extension. The converted file is written to output, or next to
+// the file with the extension of the format.
+func convertTdf(path, to, output string) error {
+	if to == "" {
+		return errors.New("The format of the converted file is missing, e.g. seed -to toml convert tdf.yml")
+	}
+	format, err := getFormat(path)
+	if err != nil {
+		return fmt.Errorf("Unsupported format for %s", path)
+	}
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read the transformation description file.\n%v", err)
+	}
+
+	res, newFormat, err := convertTdfData(dat, format, to)
+	if err != nil {
+		return fmt.Errorf("Failed to convert %s: %s", path, err)
+	}
+	if output == "" {
+		output = strings.TrimSuffix(path, filepath.Ext(path)) + "." + newFormat
+	}
+	if err = ioutil.WriteFile(output, res, 0644); err != nil {
+		return fmt.Errorf("Unable to write the converted transformation file.\n%v", err)
+	}
+	fmt.Printf("Converted %s to %s\n", path, output)
+	return nil
+}
+
+// convertTdfData converts the transformation file from a format to another,
+// given as yaml, yml, toml or json. It returns the converted file and the
+// normalized target format, which is also its extension.
+func convertTdfData(dat []byte, from, to string) ([]byte, string, error) {
+	format, err := getFormat("." + to)
+	if err != nil {
+		return nil, "", err
+	}
+	t, err := decodeTdf(dat, from)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = checkVersion(t); err != nil {
+		return nil, "", err
 	}
-	
-	ioutil.WriteFile(path[:index], res, 0666)
+	res, err := encodeTdf(t, format)
+	return res, format, err
 }
@@ -9,7 +9,15 @@
 package main
 
 import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -40,8 +48,17 @@ transformations:
    - name: DoNothing
 `
 
+// mustParseTdf parses the transformation file, failing the test on error.
+func mustParseTdf(t *testing.T, dat []byte, format string) T {
+	tdf, err := parseTdf(dat, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tdf
+}
+
 func TestParseTdf(t *testing.T) {
-	tr := parseTdf([]byte(tdfYml), "yml")
+	tr := mustParseTdf(t, []byte(tdfYml), "yml")
 
 	if tr.Exclude != "*.out" {
 		t.Error("The file should contains exclude directories.")
@@ -81,8 +98,56 @@ var tdfToml = `exclude= "*.out"
     name = "DoNothing"
 `
 
+var anchorsTdfYml = `definitions:
+  rename: &rename
+    - name: Replace
+      params: [old, new]
+  go: &go
+    include: ["*.go"]
+    proc: *rename
+transformations:
+  - name: yaml
+    include: ["*.yml"]
+    proc: *rename
+  - <<: *go
+    name: go
+  - <<: [*go]
+    include: ["*.md"]
+`
+
+func TestParseTdfWithAnchors(t *testing.T) {
+	tdf := mustParseTdf(t, []byte(anchorsTdfYml), "yml")
+	if len(tdf.Transformations) != 3 {
+		t.Fatalf("parseTdf: 3 transformations were expected but found %v", len(tdf.Transformations))
+	}
+
+	rename := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	expected := []Transformation{
+		Transformation{Name: "yaml", Include: []string{"*.yml"}, Proc: rename},
+		Transformation{Name: "go", Include: []string{"*.go"}, Proc: rename},
+		Transformation{Include: []string{"*.md"}, Proc: rename},
+	}
+	for i, tr := range tdf.Transformations {
+		if !reflect.DeepEqual(tr, expected[i]) {
+			t.Errorf("parseTdf: transformation %v should be %+v but found %+v", i, expected[i], tr)
+		}
+	}
+}
+
+func TestParseTdfRejectsUnknownNames(t *testing.T) {
+	for _, c := range []struct{ tdf, problem string }{
+		{"transformations:\n - proc: [{name: Missing}]\n", "Missing: unknown procedure"},
+		{"transformations:\n - pre: [NotAPrecondition]\n", "NotAPrecondition: unknown precondition"},
+		{"transformations:\n - proc: [{name: ToLower, onerror: retry}]\n", `unknown onerror policy "retry"`},
+	} {
+		if _, err := parseTdf([]byte(c.tdf), "yml"); err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("parseTdf should report %q but found %v", c.problem, err)
+		}
+	}
+}
+
 func TestParseTdfWithToml(t *testing.T) {
-	tr := parseTdf([]byte(tdfToml), "toml")
+	tr := mustParseTdf(t, []byte(tdfToml), "toml")
 
 	if tr.Exclude != "*.out" {
 		t.Error("The file should contains exclude directories.")
@@ -104,6 +169,85 @@ func TestParseTdfWithToml(t *testing.T) {
 	}
 }
 
+var tdfJSON = `{
+  "exclude": "*.out",
+  "transformations": [
+    {
+      "filter": "*.go|*.yml",
+      "pre": ["AlwaysTrue"],
+      "proc": [{"name": "Replace", "params": ["old", "new"]}]
+    },
+    {
+      "filter": "*.java",
+      "pre": ["AlwaysTrue"],
+      "proc": [{"name": "DoNothing"}]
+    }
+  ]
+}
+`
+
+func TestParseTdfWithJSON(t *testing.T) {
+	tr := mustParseTdf(t, []byte(tdfJSON), "json")
+	expected := mustParseTdf(t, []byte(tdfYml), "yml")
+	if !reflect.DeepEqual(tr, expected) {
+		t.Errorf("parseTdf: the JSON file should give %+v but found %+v", expected, tr)
+	}
+
+	dat, err := encodeTdf(tr, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back := mustParseTdf(t, dat, "json"); !reflect.DeepEqual(back, tr) {
+		t.Errorf("parseTdf: the encoded JSON file should give %+v back but found %+v", tr, back)
+	}
+}
+
+func TestTdfFormatName(t *testing.T) {
+	cases := map[string]string{
+		"tdf.json":                              "tdf.json",
+		"https://example.com/tdf.json?ref=main": "/tdf.json",
+		"http://example.com/conf/tdf.toml#top":  "/conf/tdf.toml",
+	}
+	for path, expected := range cases {
+		if name := tdfFormatName(path); name != expected {
+			t.Errorf("tdfFormatName(%s): %s was expected but found %s", path, expected, name)
+		}
+	}
+}
+
+func TestFixWithRemoteJSONTdf(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, tdfJSON)
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "seed-fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("old\n"), 0644)
+
+	defer func(path, dir string, noGit bool) {
+		transPath, dirPath, noRequireGit = path, dir, noGit
+		flag.CommandLine.Parse(nil)
+	}(transPath, dirPath, noRequireGit)
+	transPath, noRequireGit = server.URL+"/tdf.json?ref=main", true
+	flag.CommandLine.Parse([]string{"fix", dir})
+	if err := fix(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if dat, _ := ioutil.ReadFile(filepath.Join(dir, "main.go")); string(dat) != "new\n" {
+		t.Errorf("fix should apply the remote JSON transformation file but found %q", dat)
+	}
+
+	// tdf-diff and migrate load the remote file the same way
+	tdf, err := loadTdf(server.URL + "/tdf.json?ref=main")
+	if err != nil || len(tdf.Transformations) == 0 {
+		t.Errorf("loadTdf should infer the format of the URL without its query but found %v", err)
+	}
+}
+
 func TestGetFormat(t *testing.T) {
 	ext, err := getFormat("my/path.yml")
 	if err != nil || ext != "yml" {
@@ -120,6 +264,11 @@ func TestGetFormat(t *testing.T) {
 		t.Errorf("tomml was expected but found %s, %v", ext, err)
 	}
 
+	ext, err = getFormat("my/path.json")
+	if err != nil || ext != "json" {
+		t.Errorf("json was expected but found %s, %v", ext, err)
+	}
+
 	ext, err = getFormat("my/path.TOML")
 	if err != nil || ext != "toml" {
 		t.Errorf("TOML was expected but found %s, %v", ext, err)
@@ -131,8 +280,299 @@ func TestGetFormat(t *testing.T) {
 }
 
 func TestReadFile(t *testing.T) {
-	if bytes := readFile("../test/tdf.yml"); bytes == nil {
+	bytes, path, err := readFile("../test/tdf.yml")
+	if err != nil || bytes == nil {
 		t.Error("ReadFile: Failed to read ./test/conf.yml")
 	}
+	if expected, _ := filepath.Abs("../test/tdf.yml"); path != expected {
+		t.Errorf("ReadFile: the absolute path %s was expected but found %s", expected, path)
+	}
+}
+
+func TestFetchURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tdf.yml" {
+			http.Error(w, "failure", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, tdfYml)
+	}))
+	defer server.Close()
+
+	dat, url, err := fetchURL(server.URL + "/tdf.yml")
+	if err != nil || string(dat) != tdfYml || url != server.URL+"/tdf.yml" {
+		t.Errorf("fetchURL should return the served file but found %v, %s:\n%s", err, url, dat)
+	}
+	if _, _, err = fetchURL(server.URL + "/broken.yml"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("fetchURL should report the status of a failed request but found %v", err)
+	}
+}
+
+func TestFixSkipsTdfInTargetDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tdf := filepath.Join(dir, "tdf.yml")
+	ioutil.WriteFile(tdf, []byte(tdfYml), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("old\n"), 0644)
 
+	defer func(path, dir string, noGit bool) {
+		transPath, dirPath, noRequireGit = path, dir, noGit
+		flag.CommandLine.Parse(nil)
+	}(transPath, dirPath, noRequireGit)
+	transPath, noRequireGit = tdf, true
+	flag.CommandLine.Parse([]string{"fix", dir})
+	if err := fix(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if dat, _ := ioutil.ReadFile(filepath.Join(dir, "main.go")); string(dat) != "new\n" {
+		t.Errorf("fix should transform the files of the directory but found %q", dat)
+	}
+	if dat, _ := ioutil.ReadFile(tdf); string(dat) != tdfYml {
+		t.Errorf("fix should not transform the transformation file but found:\n%s", dat)
+	}
+}
+
+func TestCheckDefaultTdf(t *testing.T) {
+	wd, _ := os.Getwd()
+	dir, err := ioutil.TempDir("", "seed-notdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	defer func(path string) { transPath = path }(transPath)
+
+	transPath = defaultTdfPath
+	err = Run(Options{Command: "fix"})
+	exit, ok := err.(exitError)
+	if !ok || exit.code != exitMissingTdf || exit.err == nil {
+		t.Fatalf("fix should fail with the exit code %v without tdf.yml but found %#v", exitMissingTdf, err)
+	}
+	msg := exit.err.Error()
+	for _, expected := range []string{"No transformation file found", "seed -t path/to/tdf.yml fix", "create tdf.yml"} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("fix should explain how to give the transformation file with %q but found:\n%s", expected, msg)
+		}
+	}
+	// The suggested file must be valid
+	example := msg[strings.Index(msg, "starting from:")+len("starting from:") : strings.Index(msg, "See 'seed help")]
+	if _, err = parseTdf([]byte(strings.Replace(example, "\n  ", "\n", -1)), "yml"); err != nil {
+		t.Errorf("The suggested transformation file should be valid but found: %v", err)
+	}
+	if err = checkDefaultTdf("./other.yml"); err != nil {
+		t.Errorf("checkDefaultTdf should only check the default file but found: %v", err)
+	}
+
+	ioutil.WriteFile("tdf.yml", []byte(tdfYml), 0644)
+	if err = checkDefaultTdf(defaultTdfPath); err != nil {
+		t.Errorf("checkDefaultTdf should accept an existing default file but found: %v", err)
+	}
+}
+
+func TestRunReturnsErrors(t *testing.T) {
+	wd, _ := os.Getwd()
+	dir, err := ioutil.TempDir("", "seed-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	defer func(path string) { transPath = path }(transPath)
+
+	transPath = defaultTdfPath
+	err = Run(Options{Command: "fix"})
+	if exit, ok := err.(exitError); !ok || exit.code != exitMissingTdf || exit.err == nil {
+		t.Errorf("Run should fail with the exit code %v without tdf.yml but found %#v", exitMissingTdf, err)
+	}
+
+	ioutil.WriteFile("bad.yml", []byte("transformations:\n  - proc:\n      - name: RegexReplace\n        params: [\"(\", \"\"]\n"), 0644)
+	transPath = "bad.yml"
+	if err = Run(Options{Command: "fix", Args: []string{dir}}); err == nil || !strings.Contains(err.Error(), "Invalid RegexReplace pattern") {
+		t.Errorf("Run should return the error of the transformation file but found %v", err)
+	}
+	if err = Run(Options{Command: "migrate", Args: []string{"tdf.fancy"}}); err == nil || !strings.Contains(err.Error(), "Unsupported format") {
+		t.Errorf("Run should return the error of migrate but found %v", err)
+	}
+}
+
+func TestMigrateTdf(t *testing.T) {
+	v1 := mustParseTdf(t, []byte(tdfYml), "yml")
+	v2 := migrateTdf(v1)
+
+	if v2.Version != currentVersion {
+		t.Errorf("The migrated file should be at version %v but found %v", currentVersion, v2.Version)
+	}
+	tranf := v2.Transformations[0]
+	if tranf.Filter != "" || !reflect.DeepEqual(tranf.Include, []string{"*.go", "*.yml"}) {
+		t.Errorf("Filter should be moved into Include but found %q and %q", tranf.Filter, tranf.Include)
+	}
+	if v1.Transformations[0].Filter != "*.go|*.yml" {
+		t.Error("The migration should not modify the original transformations.")
+	}
+	if !mustCheckFileName(t, "src/cmd.go", tranf) || mustCheckFileName(t, "src/cmd.java", tranf) {
+		t.Error("The migrated transformation should match the same files.")
+	}
+
+	for _, format := range []string{"yml", "toml"} {
+		dat, err := encodeTdf(v2, format)
+		if err != nil {
+			t.Fatalf("Failed to encode the migrated file in %s: %v", format, err)
+		}
+		if parsed := mustParseTdf(t, dat, format); !reflect.DeepEqual(parsed, v2) {
+			t.Errorf("The migrated %s file should be parsed back identically:\n%s", format, dat)
+		}
+	}
+}
+
+func TestConvertTdf(t *testing.T) {
+	tdf := migrateTdf(mustParseTdf(t, []byte(tdfYml), "yml"))
+	tdf.Transformations[0].Cond = []Procedure{Procedure{Name: "ValueIn", Params: []string{"env: (\\w+)", "dev"}}}
+	sources := make(map[string][]byte)
+	for _, format := range []string{"yml", "toml", "json"} {
+		dat, err := encodeTdf(tdf, format)
+		if err != nil {
+			t.Fatalf("Failed to encode the tdf in %s: %v", format, err)
+		}
+		sources[format] = dat
+	}
+
+	for from, dat := range sources {
+		for _, to := range []string{"yaml", "toml", "json"} {
+			res, format, err := convertTdfData(dat, from, to)
+			if err != nil {
+				t.Fatalf("Failed to convert from %s to %s: %v", from, to, err)
+			}
+			converted, err := decodeTdf(res, format)
+			if err != nil || !reflect.DeepEqual(converted, tdf) {
+				t.Errorf("The conversion from %s to %s should keep the transformations but found %v:\n%s", from, to, err, res)
+			}
+			back, _, err := convertTdfData(res, format, from)
+			if err != nil || string(back) != string(dat) {
+				t.Errorf("The conversion from %s to %s and back should give the same file but found %v:\n%s", from, to, err, back)
+			}
+		}
+	}
+	if _, _, err := convertTdfData(sources["yml"], "yml", "xml"); err == nil {
+		t.Error("The conversion to an unsupported format should fail")
+	}
+
+	dir, err := ioutil.TempDir("", "seed-convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tdf.yml")
+	ioutil.WriteFile(path, sources["yml"], 0644)
+	if err := convertTdf(path, "json", ""); err != nil {
+		t.Fatal(err)
+	}
+	if dat, _ := ioutil.ReadFile(filepath.Join(dir, "tdf.json")); string(dat) != string(sources["json"]) {
+		t.Errorf("convertTdf should write the file with the extension of the format but found:\n%s", dat)
+	}
+	output := filepath.Join(dir, "other.conf")
+	if err := convertTdf(path, "toml", output); err != nil {
+		t.Fatal(err)
+	}
+	if dat, _ := ioutil.ReadFile(output); string(dat) != string(sources["toml"]) {
+		t.Errorf("convertTdf should write the file to the -o path but found:\n%s", dat)
+	}
+}
+
+func TestConvertTdfFromToml(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tdf.toml")
+	ioutil.WriteFile(path, []byte(tdfToml), 0644)
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	err = convertTdf(path, "yaml", "")
+	os.Stdout.Close()
+	os.Stdout = stdout
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, err := ioutil.ReadFile(filepath.Join(dir, "tdf.yml"))
+	if err != nil {
+		t.Fatalf("convertTdf should write tdf.yml: %v", err)
+	}
+	if converted := mustParseTdf(t, dat, "yml"); !reflect.DeepEqual(converted, mustParseTdf(t, []byte(tdfToml), "toml")) {
+		t.Errorf("The converted YAML file should give the TOML transformations but found:\n%s", dat)
+	}
+	infos, _ := ioutil.ReadDir(dir)
+	if len(infos) != 2 {
+		t.Errorf("convertTdf should only write the converted file but found %v files", len(infos))
+	}
+}
+
+func TestVersionLine(t *testing.T) {
+	if line := versionLine(); line != "seed version=dev commit=dev date=dev" {
+		t.Errorf("The version should default to dev but found %q", line)
+	}
+	defer func(v, c, d string) { buildVersion, buildCommit, buildDate = v, c, d }(buildVersion, buildCommit, buildDate)
+	buildVersion, buildCommit, buildDate = "1.2.0", "4f2a9c1", "2024-05-02T10:00:00Z"
+	if line := versionLine(); line != "seed version=1.2.0 commit=4f2a9c1 date=2024-05-02T10:00:00Z" {
+		t.Errorf("The version should print the stamped values but found %q", line)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	for _, version := range []int{0, 1, currentVersion} {
+		if err := checkVersion(T{Version: version}); err != nil {
+			t.Errorf("Version %v should be supported but found %v", version, err)
+		}
+	}
+	if err := checkVersion(T{Version: currentVersion + 1}); err == nil {
+		t.Error("A newer version of the format should be rejected")
+	}
+}
+
+var tdfTemplate = `transformations:
+ - 
+  filter: "{{.Filter}}"
+  proc:
+   - 
+    name: Replace
+    params:
+     - "{{.OldPkg}}"
+     - "{{.NewPkg}}"
+`
+
+func TestRenderTdf(t *testing.T) {
+	vars := varsFlag{}
+	for _, v := range []string{"Filter=*.go", "OldPkg=com.inetpsa", "NewPkg=org.seedstack"} {
+		if err := vars.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dat, err := renderTdf([]byte(tdfTemplate), vars)
+	if err != nil {
+		t.Fatalf("Failed to render the tdf: %v", err)
+	}
+	tr := mustParseTdf(t, dat, "yml").Transformations[0]
+	if !mustCheckFileName(t, "src/cmd.go", tr) {
+		t.Errorf("The rendered filter should match go files but found %q", tr.Filter)
+	}
+	res, err := applyProcs("", []byte("import com.inetpsa.Foo;"), tr)
+	if err != nil || string(res) != "import org.seedstack.Foo;" {
+		t.Errorf("The rendered procedure should replace the package but found %q, %v", res, err)
+	}
+
+	if _, err = renderTdf([]byte(tdfTemplate), varsFlag{"Filter": "*.go"}); err == nil {
+		t.Error("Rendering should fail when a variable is missing")
+	}
+	if err = vars.Set("novalue"); err == nil {
+		t.Error("A variable without value should be rejected")
+	}
 }
@@ -0,0 +1,115 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SortCSV sorts the rows of a CSV file by the column given by its header name
+// or by its index, starting from 1, the header row staying first. The rows with
+// the same value keep their order. The options are "numeric", to compare the
+// values as numbers, and "dedupe", to remove the duplicated rows but the first
+// one. The file is written back with the quotes only where they are needed,
+// keeping its line endings. A malformed file is skipped, or reported with -strict.
+//
+// proc:
+//  -
+//    name: SortCSV
+//    params: ["id", "numeric", "dedupe"]
+func (p *Procedures) SortCSV(dat []byte, column string, opts ...string) ([]byte, error) {
+	numeric, dedupe := false, false
+	for _, opt := range opts {
+		switch opt {
+		case "numeric":
+			numeric = true
+		case "dedupe":
+			dedupe = true
+		default:
+			return dat, fmt.Errorf("unknown option %q, expected numeric or dedupe", opt)
+		}
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(dat)).ReadAll()
+	if err != nil {
+		return dat, unparsable("SortCSV", err)
+	}
+	if len(records) == 0 {
+		return dat, nil
+	}
+	col, err := csvColumn(records[0], column)
+	if err != nil {
+		return dat, err
+	}
+
+	rows := records[1:]
+	if dedupe {
+		seen := make(map[string]bool)
+		unique := rows[:0]
+		for _, row := range rows {
+			key := strings.Join(row, "\x00")
+			if !seen[key] {
+				seen[key] = true
+				unique = append(unique, row)
+			}
+		}
+		rows = unique
+	}
+	var sortErr error
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i][col], rows[j][col]
+		if !numeric {
+			return a < b
+		}
+		x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if errA != nil || errB != nil {
+			if sortErr == nil {
+				sortErr = fmt.Errorf("the column %s holds a value which is not a number", column)
+			}
+			return a < b
+		}
+		return x < y
+	})
+	if sortErr != nil {
+		return dat, sortErr
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.UseCRLF = bytes.Contains(dat, []byte("\r\n"))
+	if err := w.WriteAll(append([][]string{records[0]}, rows...)); err != nil {
+		return dat, err
+	}
+	res := buf.Bytes()
+	if !bytes.HasSuffix(dat, []byte("\n")) {
+		res = bytes.TrimRight(res, "\r\n")
+	}
+	return res, nil
+}
+
+// csvColumn returns the index of the column given by its header name or by its
+// index from 1.
+func csvColumn(header []string, column string) (int, error) {
+	for i, name := range header {
+		if name == column {
+			return i, nil
+		}
+	}
+	n, err := strconv.Atoi(column)
+	if err != nil || n < 1 || n > len(header) {
+		return 0, fmt.Errorf("no column %s in the header %s", column, strings.Join(header, ","))
+	}
+	return n - 1, nil
+}
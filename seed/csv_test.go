@@ -0,0 +1,59 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+var fixtureCSV = `id,name,city
+10,"Smith, Anna",Paris
+2,Bob,"Lyon"
+33,Carla,Nantes
+2,Bob,Lyon
+`
+
+func TestSortCSV(t *testing.T) {
+	var p *Procedures
+	cases := []struct {
+		params   []string
+		expected string
+	}{
+		{[]string{"name"}, "id,name,city\n2,Bob,Lyon\n2,Bob,Lyon\n33,Carla,Nantes\n10,\"Smith, Anna\",Paris\n"},
+		{[]string{"1"}, "id,name,city\n10,\"Smith, Anna\",Paris\n2,Bob,Lyon\n2,Bob,Lyon\n33,Carla,Nantes\n"},
+		{[]string{"id", "numeric", "dedupe"}, "id,name,city\n2,Bob,Lyon\n10,\"Smith, Anna\",Paris\n33,Carla,Nantes\n"},
+	}
+	for _, c := range cases {
+		res, err := p.SortCSV([]byte(fixtureCSV), c.params[0], c.params[1:]...)
+		if err != nil || string(res) != c.expected {
+			t.Errorf("SortCSV %v: expected\n%s\nbut found %v\n%s", c.params, c.expected, err, res)
+		}
+	}
+
+	res, err := p.SortCSV([]byte("b,a\r\n2,x\r\n1,y"), "b")
+	if expected := "b,a\r\n1,y\r\n2,x"; err != nil || string(res) != expected {
+		t.Errorf("SortCSV should keep the line endings: %q was expected but found %q, %v", expected, res, err)
+	}
+
+	for _, params := range [][]string{{"4"}, {"zip"}, {"name", "numeric"}, {"id", "reverse"}} {
+		if _, err := p.SortCSV([]byte(fixtureCSV), params[0], params[1:]...); err == nil {
+			t.Errorf("SortCSV %v should fail", params)
+		}
+	}
+
+	malformed := []byte("id,name\n1,\"unterminated\n")
+	if res, err := p.SortCSV(malformed, "id"); err != nil || string(res) != string(malformed) {
+		t.Errorf("SortCSV should skip a malformed file but found %q, %v", res, err)
+	}
+	strict = true
+	defer func() { strict = false }()
+	if _, err := p.SortCSV(malformed, "id"); err == nil {
+		t.Error("SortCSV should report a malformed file with -strict")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// keepDirective marks the debug prints which must not be removed.
+const keepDirective = "keep"
+
+// debugPrintRegexes match the lines holding a debug print, by language.
+var debugPrintRegexes = map[string]*regexp.Regexp{
+	"js":     regexp.MustCompile(`^\s*console\.(?:log|debug|trace)\(.*\);?\s*(//.*)?$`),
+	"python": regexp.MustCompile(`^\s*print\(.*\)\s*(#.*)?$`),
+}
+
+// languageAliases maps the other names of the languages to the known ones.
+var languageAliases = map[string]string{
+	"javascript": "js", "ts": "js", "typescript": "js", "py": "python",
+}
+
+// goDebugPrints are the fmt functions removed by StripDebugPrints.
+var goDebugPrints = map[string]bool{"Print": true, "Printf": true, "Println": true}
+
+// StripDebugPrints removes the lines printing debug messages, for the given
+// language: fmt.Print, fmt.Printf, fmt.Println, print and println statements
+// for "go", console.log, console.debug and console.trace for "js" and print()
+// for "python". The prints followed by a "// keep" comment, or "# keep" in
+// Python, are kept. Go files are parsed to only remove whole statements and
+// the fmt import is removed when it is not used anymore. Go files which cannot
+// be parsed are left untouched, or reported with -strict. For the other
+// languages, a debug print must fit on a single line.
+//
+// proc:
+//  -
+//    name: StripDebugPrints
+//    params: go
+func (p *Procedures) StripDebugPrints(dat []byte, language string) ([]byte, error) {
+	if alias, ok := languageAliases[language]; ok {
+		language = alias
+	}
+	if language == "go" {
+		return stripGoDebugPrints(dat)
+	}
+	re, ok := debugPrintRegexes[language]
+	if !ok {
+		return dat, fmt.Errorf("unknown language %q, expected go, js or python", language)
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	var res []string
+	for _, line := range lines {
+		m := re.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if m == nil || isKeepComment(m[1]) {
+			res = append(res, line)
+		}
+	}
+	return []byte(strings.Join(res, "")), nil
+}
+
+// isKeepComment tells whether the comment is a keep directive, e.g. "// keep".
+func isKeepComment(comment string) bool {
+	comment = strings.TrimLeft(comment, "/#")
+	return strings.HasPrefix(strings.TrimSpace(comment), keepDirective)
+}
+
+func stripGoDebugPrints(dat []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("StripDebugPrints", err)
+	}
+	name := importName(f, "fmt")
+
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	var edits []edit
+	removed := make(map[*ast.SelectorExpr]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		stmt, ok := n.(*ast.ExprStmt)
+		if !ok {
+			return true
+		}
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		var sel *ast.SelectorExpr
+		switch fun := call.Fun.(type) {
+		case *ast.SelectorExpr:
+			if name == "" || !isIdent(fun.X, name) || !goDebugPrints[fun.Sel.Name] {
+				return true
+			}
+			sel = fun
+		case *ast.Ident:
+			if fun.Name != "print" && fun.Name != "println" {
+				return true
+			}
+		default:
+			return true
+		}
+
+		// Only remove the statements on their own lines
+		start, end := offset(stmt.Pos()), offset(stmt.End())
+		lineStart, lineEnd := lineRange(dat, start, end)
+		after := strings.TrimSpace(string(dat[end:lineEnd]))
+		if strings.TrimSpace(string(dat[lineStart:start])) != "" || (after != "" && !strings.HasPrefix(after, "//")) || isKeepComment(after) {
+			return true
+		}
+		edits = append(edits, edit{lineStart, lineEnd, ""})
+		removed[sel] = true
+		return false
+	})
+	if len(edits) == 0 {
+		return dat, nil
+	}
+
+	if name != "" && name != "_" && name != "." {
+		used := false
+		ast.Inspect(f, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok && isIdent(sel.X, name) && !removed[sel] {
+				used = true
+			}
+			return !used
+		})
+		if !used {
+			if remove, ok := removeImportEdit(fset, f, dat, "fmt"); ok {
+				edits = append(edits, remove)
+			}
+		}
+	}
+	return applyEdits(dat, edits), nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import "testing"
+
+var debugGo = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("here") // TODO remove
+	fmt.Println("usage: run") // keep
+	if len(os.Args) > 1 {
+		println(os.Args[1])
+	}
+}
+`
+
+var strippedDebugGo = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("usage: run") // keep
+	if len(os.Args) > 1 {
+	}
+}
+`
+
+func TestStripDebugPrints(t *testing.T) {
+	var p *Procedures
+	res, err := p.StripDebugPrints([]byte(debugGo), "go")
+	if err != nil || string(res) != strippedDebugGo {
+		t.Errorf("StripDebugPrints: expected\n%s\nbut found %v\n%s", strippedDebugGo, err, res)
+	}
+
+	src := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Printf(\"%v\\n\", 1)\n}\n"
+	expected := "package main\n\nfunc main() {\n}\n"
+	if res, err = p.StripDebugPrints([]byte(src), "go"); err != nil || string(res) != expected {
+		t.Errorf("StripDebugPrints should remove the unused fmt import: expected\n%s\nbut found %v\n%s", expected, err, res)
+	}
+
+	js := "console.log('debug');\nconsole.log('started'); // keep\nlog(x);\n"
+	if res, err = p.StripDebugPrints([]byte(js), "javascript"); err != nil || string(res) != "console.log('started'); // keep\nlog(x);\n" {
+		t.Errorf("StripDebugPrints should remove the console.log lines but found %q, %v", res, err)
+	}
+	py := "def f():\n    print(x)\n    print('ok')  # keep\n    fingerprint(x)\n"
+	if res, err = p.StripDebugPrints([]byte(py), "python"); err != nil || string(res) != "def f():\n    print('ok')  # keep\n    fingerprint(x)\n" {
+		t.Errorf("StripDebugPrints should remove the print lines but found %q, %v", res, err)
+	}
+
+	if _, err = p.StripDebugPrints([]byte(js), "cobol"); err == nil {
+		t.Error("StripDebugPrints should reject an unknown language")
+	}
+	invalid := "package main\n\nfunc main() {\n\tfmt.Println(\n"
+	if res, err = p.StripDebugPrints([]byte(invalid), "go"); err != nil || string(res) != invalid {
+		t.Errorf("StripDebugPrints should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+}
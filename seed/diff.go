@@ -0,0 +1,196 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// diffOp is a line of an edit script: kept (' '), removed ('-') or added ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the shortest edit script turning a into b, computed with
+// the Myers algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	// v[k+max] is the furthest x reached on the diagonal k, trace keeps v for each step
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+max] < v[k+1+max]) {
+				x = v[k+1+max]
+			} else {
+				x = v[k-1+max] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+max] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			trace = append(trace, v)
+			break
+		}
+	}
+
+	// Walk the trace backwards to build the script
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 2; d >= 0 && (x > 0 || y > 0); d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+max] < v[k+1+max]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+max]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, diffOp{'+', b[y]})
+			} else {
+				x--
+				ops = append(ops, diffOp{'-', a[x]})
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// unifiedDiff returns the changes between the original and the new content of
+// the file in the unified format, with the given number of context lines around
+// each change. It is empty when the contents are equal.
+func unifiedDiff(orig, new []byte, path string, context int) string {
+	if bytes.Equal(orig, new) {
+		return ""
+	}
+	ops := diffLines(splitLines(orig), splitLines(new))
+
+	var buf bytes.Buffer
+	path = filepath.ToSlash(path)
+	fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", path, path)
+
+	// a and b are the line numbers, starting from 0, before ops[i]
+	for i, a, b := 0, 0, 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i, a, b = i+1, a+1, b+1
+			continue
+		}
+
+		// The hunk starts with the context preceding the change and ends when the
+		// next change is further than twice the context
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		if end += context; end > len(ops) {
+			end = len(ops)
+		}
+
+		hunkA, hunkB := a-(i-start), b-(i-start)
+		lenA, lenB := 0, 0
+		var lines bytes.Buffer
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				lenA++
+			}
+			if op.kind != '-' {
+				lenB++
+			}
+			lines.WriteByte(op.kind)
+			lines.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				lines.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(hunkA, lenA), hunkRange(hunkB, lenB))
+		buf.Write(lines.Bytes())
+
+		for _, op := range ops[i:end] {
+			if op.kind != '+' {
+				a++
+			}
+			if op.kind != '-' {
+				b++
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+// hunkRange formats the range of a hunk header like diff -u, the start being the
+// line preceding the hunk when it is empty.
+func hunkRange(start, length int) string {
+	switch length {
+	case 0:
+		return fmt.Sprintf("%v,0", start)
+	case 1:
+		return fmt.Sprintf("%v", start+1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, length)
+}
+
+// diffStat returns the number of lines added and removed to turn orig into new.
+func diffStat(orig, new []byte) (int, int) {
+	added, removed := 0, 0
+	for _, op := range diffLines(splitLines(orig), splitLines(new)) {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+func splitLines(dat []byte) []string {
+	lines := strings.SplitAfter(string(dat), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
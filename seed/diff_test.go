@@ -0,0 +1,87 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines "line 1" to "line n".
+func numberedLines(n int) string {
+	var lines []string
+	for i := 1; i <= n; i++ {
+		lines = append(lines, fmt.Sprintf("line %v\n", i))
+	}
+	return strings.Join(lines, "")
+}
+
+func TestUnifiedDiffContext(t *testing.T) {
+	orig := numberedLines(20)
+	changed := strings.Replace(orig, "line 10\n", "line ten\n", 1)
+
+	expected0 := "--- a/f.txt\n+++ b/f.txt\n@@ -10 +10 @@\n-line 10\n+line ten\n"
+	if diff := unifiedDiff([]byte(orig), []byte(changed), "f.txt", 0); diff != expected0 {
+		t.Errorf("unifiedDiff with no context: expected\n%s\nbut found\n%s", expected0, diff)
+	}
+
+	expected5 := "--- a/f.txt\n+++ b/f.txt\n@@ -5,11 +5,11 @@\n" +
+		" line 5\n line 6\n line 7\n line 8\n line 9\n-line 10\n+line ten\n line 11\n line 12\n line 13\n line 14\n line 15\n"
+	if diff := unifiedDiff([]byte(orig), []byte(changed), "f.txt", 5); diff != expected5 {
+		t.Errorf("unifiedDiff with 5 context lines: expected\n%s\nbut found\n%s", expected5, diff)
+	}
+
+	if diff := unifiedDiff([]byte(orig), []byte(orig), "f.txt", 3); diff != "" {
+		t.Errorf("unifiedDiff should be empty for equal contents but found\n%s", diff)
+	}
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	orig := numberedLines(20)
+	changed := strings.Replace(strings.Replace(orig, "line 2\n", "", 1), "line 18\n", "line 18\nline 18b\n", 1)
+
+	expected := "--- a/f.txt\n+++ b/f.txt\n" +
+		"@@ -1,5 +1,4 @@\n line 1\n-line 2\n line 3\n line 4\n line 5\n" +
+		"@@ -16,5 +15,6 @@\n line 16\n line 17\n line 18\n+line 18b\n line 19\n line 20\n"
+	if diff := unifiedDiff([]byte(orig), []byte(changed), "f.txt", 3); diff != expected {
+		t.Errorf("unifiedDiff should have a hunk per distant change: expected\n%s\nbut found\n%s", expected, diff)
+	}
+
+	// Changes closer than twice the context are in the same hunk
+	close := strings.Replace(strings.Replace(orig, "line 5\n", "five\n", 1), "line 10\n", "ten\n", 1)
+	if diff := unifiedDiff([]byte(orig), []byte(close), "f.txt", 3); strings.Count(diff, "@@ -") != 1 ||
+		!strings.Contains(diff, "@@ -2,12 +2,12 @@\n") {
+		t.Errorf("unifiedDiff should merge the close changes in one hunk but found\n%s", diff)
+	}
+
+	noEOL := "--- a/f.txt\n+++ b/f.txt\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n"
+	if diff := unifiedDiff([]byte("a"), []byte("b"), "f.txt", 3); diff != noEOL {
+		t.Errorf("unifiedDiff should mark the missing final newline: expected\n%s\nbut found\n%s", noEOL, diff)
+	}
+
+	added := "--- a/f.txt\n+++ b/f.txt\n@@ -0,0 +1 @@\n+a\n"
+	if diff := unifiedDiff(nil, []byte("a\n"), "f.txt", 3); diff != added {
+		t.Errorf("unifiedDiff of a new content: expected\n%s\nbut found\n%s", added, diff)
+	}
+
+	removed := "--- a/f.txt\n+++ b/f.txt\n@@ -1,2 +0,0 @@\n-a\n-b\n"
+	if diff := unifiedDiff([]byte("a\nb\n"), nil, "f.txt", 3); diff != removed {
+		t.Errorf("unifiedDiff of a removed content: expected\n%s\nbut found\n%s", removed, diff)
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	if added, removed := diffStat([]byte("a\nb\nc\n"), []byte("a\nB\nc\nd\n")); added != 2 || removed != 1 {
+		t.Errorf("diffStat: +2 -1 was expected but found +%v -%v", added, removed)
+	}
+	if added, removed := diffStat([]byte("a\n"), []byte("a\n")); added != 0 || removed != 0 {
+		t.Errorf("diffStat: no change was expected but found +%v -%v", added, removed)
+	}
+}
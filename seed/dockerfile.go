@@ -0,0 +1,194 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dockerInstructions are the keywords of the Dockerfile instructions.
+var dockerInstructions = map[string]bool{
+	"ADD": true, "ARG": true, "CMD": true, "COPY": true, "ENTRYPOINT": true, "ENV": true,
+	"EXPOSE": true, "FROM": true, "HEALTHCHECK": true, "LABEL": true, "MAINTAINER": true,
+	"ONBUILD": true, "RUN": true, "SHELL": true, "STOPSIGNAL": true, "USER": true,
+	"VOLUME": true, "WORKDIR": true,
+}
+
+// dockerInstructionRegex matches the keyword of an instruction line and the blanks following it.
+var dockerInstructionRegex = regexp.MustCompile(`^([ \t]*)([A-Za-z]+)(?:[ \t]+|$)`)
+
+// dockerEscapeRegex matches the parser directive changing the escape character.
+var dockerEscapeRegex = regexp.MustCompile(`^#[ \t]*(?i:escape)[ \t]*=[ \t]*(\S)[ \t]*$`)
+
+// dockerInstruction is an instruction of a Dockerfile with its continuation
+// lines, or a comment or blank line when keyword is empty.
+type dockerInstruction struct {
+	keyword string
+	args    string // arguments on the first line
+	lines   []string
+}
+
+// NormalizeDockerfile uppercases the instruction keywords of a Dockerfile, e.g.
+// "from" becomes "FROM", including the ones triggered by ONBUILD, and leaves a
+// single space between each keyword and its arguments. With the "merge" param,
+// the consecutive RUN instructions in shell form are merged into one with "&&"
+// and a line continuation, to collapse the redundant layers. The arguments, the
+// continuation lines, the comments and the here-documents are left untouched.
+//
+// proc:
+//  -
+//    name: NormalizeDockerfile
+//    params: merge
+func (p *Procedures) NormalizeDockerfile(dat []byte, runs ...string) ([]byte, error) {
+	merge := false
+	if len(runs) > 0 {
+		switch runs[0] {
+		case "keep":
+		case "merge":
+			merge = true
+		default:
+			return dat, fmt.Errorf("unknown RUN mode %q, expected keep or merge", runs[0])
+		}
+	}
+
+	instructions, escape := parseDockerfile(strings.SplitAfter(string(dat), "\n"))
+	var res []dockerInstruction
+	for _, in := range instructions {
+		if n := len(res); merge && n > 0 && mergeableRun(res[n-1]) && mergeableRun(in) {
+			res[n-1] = mergeRuns(res[n-1], in, escape)
+			continue
+		}
+		res = append(res, in)
+	}
+
+	var lines []string
+	for _, in := range res {
+		lines = append(lines, in.lines...)
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// parseDockerfile splits the lines of a Dockerfile into instructions and
+// normalizes the first line of each instruction. It also returns the escape
+// character of the file, "\\" unless changed by a parser directive.
+func parseDockerfile(lines []string) ([]dockerInstruction, string) {
+	escape := `\`
+	directives := true
+	var instructions []dockerInstruction
+	var pending []heredoc
+	for i := 0; i < len(lines); i++ {
+		content := strings.TrimRight(lines[i], "\r\n")
+		trimmed := strings.TrimSpace(content)
+		if isDockerComment(lines[i]) {
+			if m := dockerEscapeRegex.FindStringSubmatch(trimmed); directives && m != nil {
+				escape = m[1]
+			}
+			directives = directives && trimmed != ""
+			if lines[i] != "" {
+				instructions = append(instructions, dockerInstruction{lines: []string{lines[i]}})
+			}
+			continue
+		}
+		directives = false
+
+		in := dockerInstruction{lines: []string{normalizeInstruction(lines[i])}}
+		if m := dockerInstructionRegex.FindStringSubmatch(content); m != nil {
+			in.keyword = strings.ToUpper(m[2])
+			in.args = content[len(m[0]):]
+		}
+		// The continuation lines, comments and blank lines included, and the
+		// here-documents are part of the instruction
+		for strings.HasSuffix(strings.TrimRight(lines[i], " \t\r\n"), escape) && i+1 < len(lines) {
+			i++
+			in.lines = append(in.lines, lines[i])
+			for isDockerComment(lines[i]) && i+1 < len(lines) {
+				i++
+				in.lines = append(in.lines, lines[i])
+			}
+		}
+		for _, line := range in.lines {
+			for _, m := range heredocRegex.FindAllStringSubmatch(strings.TrimRight(line, "\r\n"), -1) {
+				pending = append(pending, heredoc{m[1] == "-", m[2] + m[3] + m[4]})
+			}
+		}
+		for ; len(pending) > 0 && i+1 < len(lines); i++ {
+			body := strings.TrimRight(lines[i+1], "\r\n")
+			if pending[0].dash {
+				body = strings.TrimLeft(body, "\t")
+			}
+			if body == pending[0].delim {
+				pending = pending[1:]
+			}
+			in.lines = append(in.lines, lines[i+1])
+		}
+		pending = nil
+		instructions = append(instructions, in)
+	}
+	return instructions, escape
+}
+
+// isDockerComment tells whether the line of a Dockerfile is a comment or a blank line.
+func isDockerComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// normalizeInstruction uppercases the keyword of the instruction line and leaves
+// a single space before its arguments. The lines not starting with a known
+// keyword are left untouched.
+func normalizeInstruction(line string) string {
+	m := dockerInstructionRegex.FindStringSubmatch(line)
+	if m == nil || !dockerInstructions[strings.ToUpper(m[2])] {
+		return line
+	}
+	keyword, rest := strings.ToUpper(m[2]), line[len(m[0]):]
+	if strings.TrimSpace(rest) == "" {
+		return m[1] + keyword + rest
+	}
+	if keyword == "ONBUILD" {
+		rest = normalizeInstruction(rest)
+	}
+	return m[1] + keyword + " " + rest
+}
+
+// mergeableRun tells whether the instruction is a RUN in shell form, without
+// flags nor here-documents, which can be merged with another one.
+func mergeableRun(in dockerInstruction) bool {
+	if in.keyword != "RUN" || strings.HasPrefix(in.args, "[") || strings.HasPrefix(in.args, "--") {
+		return false
+	}
+	for _, line := range in.lines {
+		if heredocRegex.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeRuns returns the RUN instruction running the commands of a then b, joined
+// by a line continuation with the escape character. The commands of b are indented
+// like the continuation lines of a or b, by 4 spaces by default.
+func mergeRuns(a, b dockerInstruction, escape string) dockerInstruction {
+	indent := "    "
+	for _, in := range []dockerInstruction{b, a} {
+		if len(in.lines) > 1 {
+			next := in.lines[1]
+			indent = next[:len(next)-len(strings.TrimLeft(next, " \t"))]
+		}
+	}
+	last := a.lines[len(a.lines)-1]
+	content := strings.TrimRight(last, "\r\n")
+	lines := append([]string{}, a.lines[:len(a.lines)-1]...)
+	lines = append(lines, strings.TrimRight(content, " \t")+" && "+escape+last[len(content):])
+	lines = append(lines, indent+b.args+b.lines[0][len(strings.TrimRight(b.lines[0], "\r\n")):])
+	lines = append(lines, b.lines[1:]...)
+	return dockerInstruction{keyword: "RUN", args: a.args, lines: lines}
+}
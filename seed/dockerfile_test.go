@@ -0,0 +1,86 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+var dockerfile = `# syntax=docker/dockerfile:1
+from golang:1.21 as build
+workdir   /src
+copy	go.mod go.sum ./
+run go mod download
+run apt-get update \
+  && apt-get install -y   git
+RUN ["go", "build", "-o", "/app"]
+Run   go vet ./...
+onbuild  copy . /src
+cmd ["/app"]
+RUN <<EOF
+from is not an instruction here
+EOF
+run echo done
+`
+
+var normalizedDockerfile = `# syntax=docker/dockerfile:1
+FROM golang:1.21 as build
+WORKDIR /src
+COPY go.mod go.sum ./
+RUN go mod download
+RUN apt-get update \
+  && apt-get install -y   git
+RUN ["go", "build", "-o", "/app"]
+RUN go vet ./...
+ONBUILD COPY . /src
+CMD ["/app"]
+RUN <<EOF
+from is not an instruction here
+EOF
+RUN echo done
+`
+
+var mergedDockerfile = `# syntax=docker/dockerfile:1
+FROM golang:1.21 as build
+WORKDIR /src
+COPY go.mod go.sum ./
+RUN go mod download && \
+  apt-get update \
+  && apt-get install -y   git
+RUN ["go", "build", "-o", "/app"]
+RUN go vet ./...
+ONBUILD COPY . /src
+CMD ["/app"]
+RUN <<EOF
+from is not an instruction here
+EOF
+RUN echo done
+`
+
+func TestNormalizeDockerfile(t *testing.T) {
+	var p *Procedures
+	res, err := p.NormalizeDockerfile([]byte(dockerfile))
+	if err != nil || string(res) != normalizedDockerfile {
+		t.Errorf("NormalizeDockerfile: expected\n%s\nbut found %v\n%s", normalizedDockerfile, err, res)
+	}
+	res, err = p.NormalizeDockerfile([]byte(dockerfile), "merge")
+	if err != nil || string(res) != mergedDockerfile {
+		t.Errorf("NormalizeDockerfile: expected\n%s\nbut found %v\n%s", mergedDockerfile, err, res)
+	}
+
+	escaped := "# escape=`\nrun echo a\nrun echo b\n"
+	res, err = p.NormalizeDockerfile([]byte(escaped), "merge")
+	if expected := "# escape=`\nRUN echo a && `\n    echo b\n"; err != nil || string(res) != expected {
+		t.Errorf("NormalizeDockerfile should merge with the escape character of the file: %q was expected but found %q, %v",
+			expected, res, err)
+	}
+	if _, err = p.NormalizeDockerfile([]byte(dockerfile), "squash"); err == nil {
+		t.Error("NormalizeDockerfile should reject an unknown RUN mode")
+	}
+}
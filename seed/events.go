@@ -0,0 +1,89 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// logOutput returns where the messages of a run are printed, the standard error
+// with -events, whose events keep the standard output for themselves.
+func logOutput() io.Writer {
+	if events != "" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// fileEvent is the JSON line emitted with -events jsonl once a file is processed.
+type fileEvent struct {
+	Path            string   `json:"path"`
+	Changed         bool     `json:"changed"`
+	DurationMs      float64  `json:"durationMs"`
+	Transformations []string `json:"transformations"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// newFileEvent returns the event of a processed file. The transformations are
+// those which fired on the file, i.e. matched its patterns and preconditions,
+// in the order of the transformation file.
+func newFileEvent(filePath string, t T, updated bool, firings []firing, elapsed time.Duration, err error) fileEvent {
+	e := fileEvent{
+		Path:            filepath.ToSlash(shortPath(filePath)),
+		Changed:         updated,
+		DurationMs:      float64(elapsed) / float64(time.Millisecond),
+		Transformations: []string{},
+	}
+	fired := make(map[int]bool)
+	for _, f := range firings {
+		if !fired[f.index] {
+			fired[f.index] = true
+			e.Transformations = append(e.Transformations, ruleID(t.Transformations[f.index], f.index))
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// eventWriter writes the events of the files processed concurrently, one JSON
+// object per line. A single goroutine writes the events so that the lines are
+// never interleaved, each line being written at once as soon as it is received.
+type eventWriter struct {
+	events chan fileEvent
+	done   chan struct{}
+}
+
+func newEventWriter(w io.Writer) *eventWriter {
+	ew := &eventWriter{events: make(chan fileEvent), done: make(chan struct{})}
+	go func() {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for e := range ew.events {
+			enc.Encode(e)
+		}
+		close(ew.done)
+	}()
+	return ew
+}
+
+func (ew *eventWriter) send(e fileEvent) {
+	ew.events <- e
+}
+
+// close waits for the pending events to be written.
+func (ew *eventWriter) close() {
+	close(ew.events)
+	<-ew.done
+}
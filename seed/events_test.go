@@ -0,0 +1,88 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for i := 0; i < 20; i++ {
+		f := filepath.Join(dir, fmt.Sprintf("file%02d.txt", i))
+		content := "kept\n"
+		if i%2 == 0 {
+			content = "old\n"
+		}
+		ioutil.WriteFile(f, []byte(content), 0644)
+		files = append(files, f)
+	}
+	streamed := filepath.Join(dir, "streamed.log")
+	ioutil.WriteFile(streamed, []byte("old\n"), 0644)
+	files = append(files, streamed)
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	tdf := T{Transformations: []Transformation{
+		Transformation{Name: "rename", Filter: "*.txt", Proc: replace},
+		Transformation{Name: "logs", Filter: "*.log", Mode: lineMode, Proc: replace},
+		Transformation{Name: "never", Filter: "*.md", Proc: replace},
+	}}
+
+	// The verbose messages go to the standard error, not between the events
+	events, verbose, vverbose = "jsonl", true, true
+	defer func() { events, verbose, vverbose = "", false, false }()
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	count, err := processFiles(context.Background(), files, tdf, nil)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+
+	if count != 11 || err != nil {
+		t.Errorf("processFiles: 11 files should be fixed but found %v (%v)", count, err)
+	}
+	seen := make(map[string]fileEvent)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var e fileEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Each event should be a JSON line but found %q: %v", scanner.Text(), err)
+		}
+		seen[e.Path] = e
+	}
+	if len(seen) != len(files) {
+		t.Fatalf("An event per file was expected but found %v:\n%s", len(seen), out)
+	}
+	for i, f := range files {
+		e := seen[filepath.ToSlash(shortPath(f))]
+		expected := []string{"rename"}
+		if f == streamed {
+			expected = []string{"logs"}
+		}
+		if e.Changed != (i%2 == 0 || f == streamed) || !reflect.DeepEqual(e.Transformations, expected) ||
+			e.DurationMs < 0 || e.Error != "" {
+			t.Errorf("Unexpected event for %s: %+v", f, e)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// insideGitWorkTree tells whether the directory, or the directory of the file,
+// is inside a git working tree.
+func insideGitWorkTree(dir string) bool {
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// checkGitWorkTree refuses to transform a directory which is not under version
+// control, as the modifications could not be reverted.
+func checkGitWorkTree(dir string) error {
+	if !insideGitWorkTree(dir) {
+		return fmt.Errorf("%s is not inside a git working tree, the changes could not be undone.\n"+
+			"Use -no-require-git to apply the transformations anyway.", dir)
+	}
+	return nil
+}
+
+// keepGitRange returns the files of the root changed in the -git-range, e.g.
+// "main..HEAD", to only fix the files touched by a pull request. All the files
+// are kept without -git-range.
+func keepGitRange(root string, files []string) ([]string, error) {
+	if gitRange == "" {
+		return files, nil
+	}
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		root = filepath.Dir(root)
+	}
+	out, err := gitOutput(root, "diff", "--name-status", "-z", "--relative", gitRange)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the files changed in %s: %v", gitRange, err)
+	}
+	changed, err := parseNameStatus(out)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the files changed in %s: %v", gitRange, err)
+	}
+
+	keep := make(map[string]bool)
+	for _, path := range changed {
+		if absPath, err := filepath.Abs(filepath.Join(root, path)); err == nil {
+			keep[absPath] = true
+		}
+	}
+	var kept []string
+	for _, f := range files {
+		if absPath, err := filepath.Abs(f); err == nil && keep[absPath] {
+			kept = append(kept, f)
+		}
+	}
+	return kept, nil
+}
+
+// parseNameStatus returns the paths of the files existing after the changes listed
+// by "git diff --name-status -z". The deleted files are ignored and the renamed or
+// copied ones are taken under their new path.
+func parseNameStatus(out []byte) ([]string, error) {
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	var paths []string
+	for i := 0; i < len(fields) && fields[i] != ""; {
+		status := fields[i]
+		n := 1
+		if strings.HasPrefix(status, "R") || strings.HasPrefix(status, "C") {
+			n = 2
+		}
+		if i+n >= len(fields) {
+			return nil, fmt.Errorf("no path for the %s status", status)
+		}
+		if !strings.HasPrefix(status, "D") {
+			paths = append(paths, fields[i+n])
+		}
+		i += n + 1
+	}
+	return paths, nil
+}
+
+// gitOutput runs git in the directory and returns its output. The error tells
+// what git printed on its standard error, if anything.
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// gitHead returns the commit checked out in the directory.
+func gitHead(dir string) (string, error) {
+	out, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitChangedSince returns the paths, relative to the directory, of the files
+// which differ from the commit in the working tree, committed or not. Both
+// paths of the renamed files are listed.
+func gitChangedSince(dir, commit string) ([]string, error) {
+	out, err := gitOutput(dir, "diff", "--name-only", "--no-renames", "-z", "--relative", commit, "--")
+	if err != nil {
+		return nil, err
+	}
+	return splitNul(out), nil
+}
+
+// gitTracked returns the paths, relative to the directory, of the files tracked
+// by git.
+func gitTracked(dir string) ([]string, error) {
+	out, err := gitOutput(dir, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return splitNul(out), nil
+}
+
+// splitNul splits the NUL terminated paths printed by git with -z.
+func splitNul(out []byte) []string {
+	if len(out) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+}
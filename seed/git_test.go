@@ -0,0 +1,124 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempGitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "seed-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("git init failed: %v\n%s", err, out)
+	}
+	return dir
+}
+
+func TestCheckGitWorkTree(t *testing.T) {
+	repo := tempGitRepo(t)
+	defer os.RemoveAll(repo)
+
+	sub := filepath.Join(repo, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGitWorkTree(sub); err != nil {
+		t.Errorf("A directory inside a git repository should be accepted but found: %v", err)
+	}
+	file := filepath.Join(sub, "file")
+	if err := ioutil.WriteFile(file, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGitWorkTree(file); err != nil {
+		t.Errorf("A file inside a git repository should be accepted but found: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "seed-nogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if insideGitWorkTree(dir) {
+		t.Skip("the temporary directory is inside a git repository")
+	}
+	if err := checkGitWorkTree(dir); err == nil {
+		t.Error("A directory outside of a git repository should be rejected")
+	}
+}
+
+func TestParseNameStatus(t *testing.T) {
+	out := "M\x00src/main.go\x00D\x00src/old.go\x00R087\x00src/util.go\x00src/strings.go\x00A\x00docs/new file.md\x00C100\x00a.txt\x00b.txt\x00"
+	paths, err := parseNameStatus([]byte(out))
+	expected := []string{"src/main.go", "src/strings.go", "docs/new file.md", "b.txt"}
+	if err != nil || !reflect.DeepEqual(paths, expected) {
+		t.Errorf("parseNameStatus: %v was expected but found %v (%v)", expected, paths, err)
+	}
+	if paths, err = parseNameStatus(nil); err != nil || len(paths) != 0 {
+		t.Errorf("parseNameStatus should accept an empty diff but found %v (%v)", paths, err)
+	}
+	if _, err = parseNameStatus([]byte("R100\x00a.txt\x00")); err == nil {
+		t.Error("parseNameStatus should report a rename without new path")
+	}
+}
+
+func TestKeepGitRange(t *testing.T) {
+	repo := tempGitRepo(t)
+	defer os.RemoveAll(repo)
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=seed", "-c", "user.email=seed@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	for _, f := range []string{"kept.go", "changed.go", "deleted.go", "renamed.go"} {
+		ioutil.WriteFile(filepath.Join(repo, f), []byte("package main // "+f+"\n"), 0644)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "base")
+	git("tag", "base")
+	ioutil.WriteFile(filepath.Join(repo, "changed.go"), []byte("package main // changed\n"), 0644)
+	ioutil.WriteFile(filepath.Join(repo, "added.go"), []byte("package main // added\n"), 0644)
+	git("rm", "-q", "deleted.go")
+	git("mv", "renamed.go", "moved.go")
+	git("add", "-A")
+	git("commit", "-q", "-m", "change")
+
+	defer func() { gitRange = "" }()
+	files := mustWalkDir(t, repo, ".git", "")
+	if kept, err := keepGitRange(repo, files); err != nil || !reflect.DeepEqual(kept, files) {
+		t.Errorf("keepGitRange should keep all the files without -git-range but found %v (%v)", kept, err)
+	}
+	gitRange = "base..HEAD"
+	kept, err := keepGitRange(repo, files)
+	expected := []string{filepath.Join(repo, "added.go"), filepath.Join(repo, "changed.go"), filepath.Join(repo, "moved.go")}
+	if err != nil || !reflect.DeepEqual(kept, expected) {
+		t.Errorf("keepGitRange: %v was expected but found %v (%v)", expected, kept, err)
+	}
+
+	gitRange = "unknown..HEAD"
+	if _, err = keepGitRange(repo, files); err == nil || !strings.Contains(err.Error(), "unknown..HEAD") {
+		t.Errorf("keepGitRange should report an invalid range but found %v", err)
+	}
+}
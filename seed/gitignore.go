@@ -0,0 +1,161 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	dir      string   // absolute path of the directory holding the .gitignore file
+	segments []string // the pattern split on "/"
+	negate   bool     // "!" re-includes the matching paths
+	dirOnly  bool     // a trailing "/" only matches the directories
+	anchored bool     // matched against the path relative to dir, else against the base name
+}
+
+// ignoreRules are the rules of the .gitignore files applying to a tree, the
+// rules of the deepest files coming last.
+type ignoreRules struct {
+	rules []ignoreRule
+}
+
+// newIgnoreRules returns the rules of the .gitignore files of the parent
+// directories of the root, up to the top of its git working tree. The rules
+// of the tree itself are loaded while it is walked.
+func newIgnoreRules(root string) (*ignoreRules, error) {
+	dir, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var parents []string
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Not inside a git working tree
+			parents = nil
+			break
+		}
+		parents = append(parents, parent)
+		dir = parent
+	}
+
+	ignore := &ignoreRules{}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if err := ignore.load(parents[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ignore, nil
+}
+
+// load adds the rules of the .gitignore file of the directory, if any.
+func (g *ignoreRules) load(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	dat, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	g.rules = append(g.rules, parseGitignore(dir, dat)...)
+	return nil
+}
+
+// parseGitignore returns the rules of a .gitignore file of the directory. The
+// blank lines and the comments are skipped, a leading "\" escapes a "#" or "!"
+// starting a pattern.
+func parseGitignore(dir string, dat []byte) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored tells whether the path is ignored by the rules, the last matching
+// rule deciding.
+func (g *ignoreRules) ignored(filePath string, isDir bool) bool {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return false
+	}
+	ignored := false
+	for _, rule := range g.rules {
+		rel, err := filepath.Rel(rule.dir, absPath)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) ||
+			(rule.dirOnly && !isDir) {
+			continue
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if !rule.anchored {
+			parts = parts[len(parts)-1:]
+		}
+		if matchSegments(rule.segments, parts) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments tells whether the path segments match the pattern segments,
+// "**" matching any number of segments.
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchSegments(pattern[1:], parts[1:])
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchSegments(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		expected      bool
+	}{
+		{"*.log", "app.log", true},
+		{"build/*.out", "build/app.out", true},
+		{"build/*.out", "build/sub/app.out", false},
+		{"**/generated", "a/b/generated", true},
+		{"**/generated", "generated", true},
+		{"docs/**/*.html", "docs/a/b/index.html", true},
+		{"docs/**/*.html", "src/index.html", false},
+	}
+	for _, c := range cases {
+		if res := matchSegments(strings.Split(c.pattern, "/"), strings.Split(c.path, "/")); res != c.expected {
+			t.Errorf("matchSegments(%q, %q) should be %v", c.pattern, c.path, c.expected)
+		}
+	}
+}
+
+func TestWalkDirGitignore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-gitignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{".git", "vendor/lib", "build", "src/build", "src/gen", "docs"} {
+		os.MkdirAll(filepath.Join(dir, d), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte("# dependencies\n/vendor/\n*.log\n!keep.log\n/build\nsrc/gen/*.go\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "docs", ".gitignore"), []byte("*.html\n"), 0644)
+	files := []string{".git/HEAD", "main.go", "debug.log", "keep.log", "vendor/lib/lib.go", "build/app",
+		"src/build/build.go", "src/gen/api.go", "src/gen/README.md", "docs/index.html", "index.html"}
+	for _, f := range files {
+		ioutil.WriteFile(filepath.Join(dir, f), []byte("old\n"), 0644)
+	}
+
+	defer func() { gitignore = false }()
+	gitignore = true
+	walked := mustWalkDir(t, dir, "", "")
+	var found []string
+	for _, f := range walked {
+		rel, _ := filepath.Rel(dir, f)
+		found = append(found, filepath.ToSlash(rel))
+	}
+	expected := []string{".gitignore", "docs/.gitignore", "index.html", "keep.log", "main.go", "src/build/build.go", "src/gen/README.md"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("walkDir should skip the ignored paths: %v was expected but found %v", expected, found)
+	}
+
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+	}}
+	if _, err := processFiles(context.Background(), walked, tdf, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"debug.log", "vendor/lib/lib.go", "build/app", "src/gen/api.go", "docs/index.html"} {
+		if dat, _ := ioutil.ReadFile(filepath.Join(dir, f)); string(dat) != "old\n" {
+			t.Errorf("The ignored file %s should be left untouched but found %q", f, dat)
+		}
+	}
+
+	gitignore = false
+	if walked = mustWalkDir(t, dir, "", ""); len(walked) != len(files)+2 {
+		t.Errorf("walkDir should walk all the files without -gitignore but found %v", walked)
+	}
+}
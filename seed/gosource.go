@@ -0,0 +1,1069 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goRawStrings returns the regions of the raw string literals of a Go source file.
+func goRawStrings(dat []byte) ([]region, error) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(dat))
+
+	var errs scanner.ErrorList
+	var s scanner.Scanner
+	s.Init(file, dat, errs.Add, 0)
+
+	var regions []region
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.STRING && lit[0] == '`' {
+			// The literal returned by the scanner has its carriage returns
+			// removed, so look for the closing quote in the original data.
+			start := file.Offset(pos)
+			end := bytes.IndexByte(dat[start+1:], '`') + start + 2
+			regions = append(regions, region{start, end})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs.Err()
+	}
+	return regions, nil
+}
+
+// ExpandTabsSafe replaces the tabs indenting the lines of a Go source file by
+// spaces, using the given tab width (4 by default). Unlike a plain replacement,
+// the lines inside raw string literals are left untouched, as well as the tabs
+// which are not part of the indentation like "\t" escapes. Files which cannot
+// be scanned are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: ExpandTabsSafe
+//    params: "4"
+func (p *Procedures) ExpandTabsSafe(dat []byte, width ...string) ([]byte, error) {
+	n := 4
+	if len(width) > 0 {
+		var err error
+		if n, err = strconv.Atoi(width[0]); err != nil || n < 1 {
+			return dat, fmt.Errorf("invalid tab width: %s", width[0])
+		}
+	}
+
+	literals, err := goRawStrings(dat)
+	if err != nil {
+		return dat, unparsable("ExpandTabsSafe", err)
+	}
+
+	var res []byte
+	offset := 0
+	for _, line := range bytes.SplitAfter(dat, []byte("\n")) {
+		if startsInside(offset, literals) {
+			res = append(res, line...)
+		} else {
+			res = append(res, expandIndent(line, n)...)
+		}
+		offset += len(line)
+	}
+	return res, nil
+}
+
+// startsInside tells whether the offset is strictly inside one of the regions.
+func startsInside(offset int, regions []region) bool {
+	for _, r := range regions {
+		if r.start < offset && offset < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// expandIndent replaces the tabs of the leading whitespace of the line by spaces.
+func expandIndent(line []byte, width int) []byte {
+	col, i := 0, 0
+	for ; i < len(line); i++ {
+		if line[i] == ' ' {
+			col++
+		} else if line[i] == '\t' {
+			col = (col/width + 1) * width
+		} else {
+			break
+		}
+	}
+	if bytes.IndexByte(line[:i], '\t') == -1 {
+		return line
+	}
+	return append(bytes.Repeat([]byte(" "), col), line[i:]...)
+}
+
+// SortGoGenerate gathers the //go:generate directives of a Go source file in a
+// single sorted block placed after the imports. Duplicated directives are only
+// kept once and the commands are preserved verbatim. Files which cannot be
+// parsed are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: SortGoGenerate
+func (p *Procedures) SortGoGenerate(dat []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("SortGoGenerate", err)
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	directives := make(map[int]bool)
+	var block []string
+	seen := make(map[string]bool)
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			line := fset.Position(c.Pos()).Line - 1
+			text := strings.TrimSpace(lines[line])
+			if !strings.HasPrefix(c.Text, "//go:generate") || text != c.Text {
+				continue
+			}
+			directives[line] = true
+			if !seen[text] {
+				seen[text] = true
+				block = append(block, text+"\n")
+			}
+		}
+	}
+	if len(block) == 0 {
+		return dat, nil
+	}
+	sort.Strings(block)
+
+	// Place the directives after the last import or after the package clause
+	var anchor token.Pos = f.Name.End()
+	for _, decl := range f.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			anchor = gen.End()
+		}
+	}
+	anchorLine := fset.Position(anchor).Line - 1
+
+	var res []string
+	isBlank := func(line string) bool { return strings.TrimSpace(line) == "" }
+	lastIsBlank := func() bool { return len(res) > 0 && isBlank(res[len(res)-1]) }
+	dropBlank, needBlank := false, false
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		if directives[i] {
+			// Avoid leaving two blank lines around the removed directive
+			dropBlank = dropBlank || lastIsBlank()
+			continue
+		}
+		if isBlank(line) && (dropBlank || (needBlank && lastIsBlank())) {
+			dropBlank = false
+			continue
+		}
+		if needBlank && !isBlank(line) {
+			res = append(res, "\n")
+		}
+		dropBlank, needBlank = false, false
+		res = append(res, line)
+
+		if i == anchorLine {
+			res = append(res, "\n")
+			res = append(res, block...)
+			needBlank = true
+		}
+	}
+	for dropBlank && lastIsBlank() {
+		res = res[:len(res)-1]
+	}
+	return []byte(strings.Join(res, "")), nil
+}
+
+// goImport is an import spec of a Go source file.
+type goImport struct {
+	name, path, doc, comment string
+}
+
+type byImportPath []goImport
+
+func (b byImportPath) Len() int           { return len(b) }
+func (b byImportPath) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byImportPath) Less(i, j int) bool { return b[i].path < b[j].path }
+
+// isStdImport tells whether the quoted import path is from the standard library,
+// i.e. its first element has no dot.
+func isStdImport(path string) bool {
+	first := strings.SplitN(strings.Trim(path, `"`), "/", 2)[0]
+	return !strings.Contains(first, ".")
+}
+
+// formatImports returns an import declaration with the standard library imports
+// first, then the other ones, each group being sorted by path.
+func formatImports(imports []goImport) string {
+	if len(imports) == 1 && imports[0].doc == "" && imports[0].comment == "" {
+		imp := imports[0]
+		if imp.name != "" {
+			return "import " + imp.name + " " + imp.path
+		}
+		return "import " + imp.path
+	}
+
+	var std, others []goImport
+	for _, imp := range imports {
+		if isStdImport(imp.path) {
+			std = append(std, imp)
+		} else {
+			others = append(others, imp)
+		}
+	}
+
+	var groups []string
+	for _, group := range [][]goImport{std, others} {
+		if len(group) == 0 {
+			continue
+		}
+		sort.Stable(byImportPath(group))
+		var lines []string
+		for _, imp := range group {
+			if imp.doc != "" {
+				lines = append(lines, "\t"+strings.Replace(imp.doc, "\n", "\n\t", -1))
+			}
+			line := "\t" + imp.path
+			if imp.name != "" {
+				line = "\t" + imp.name + " " + imp.path
+			}
+			if imp.comment != "" {
+				line += " " + imp.comment
+			}
+			lines = append(lines, line)
+		}
+		groups = append(groups, strings.Join(lines, "\n")+"\n")
+	}
+	return "import (\n" + strings.Join(groups, "\n") + ")"
+}
+
+// MergeImportBlocks merges the import declarations of a Go source file into a
+// single block, the standard library imports being grouped first. Duplicated
+// imports are only kept once and the cgo "C" import is left apart. Files which
+// cannot be parsed are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: MergeImportBlocks
+func (p *Procedures) MergeImportBlocks(dat []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("MergeImportBlocks", err)
+	}
+
+	var decls []*ast.GenDecl
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		if len(gen.Specs) == 1 && gen.Specs[0].(*ast.ImportSpec).Path.Value == `"C"` {
+			continue
+		}
+		decls = append(decls, gen)
+	}
+	if len(decls) < 2 {
+		return dat, nil
+	}
+
+	text := func(n ast.Node) string {
+		return string(dat[fset.Position(n.Pos()).Offset:fset.Position(n.End()).Offset])
+	}
+	var imports []goImport
+	seen := make(map[string]bool)
+	for _, decl := range decls {
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.ImportSpec)
+			imp := goImport{path: spec.Path.Value}
+			if spec.Name != nil {
+				imp.name = spec.Name.Name
+			}
+			if spec.Doc != nil {
+				imp.doc = text(spec.Doc)
+			}
+			if spec.Comment != nil {
+				imp.comment = text(spec.Comment)
+			}
+			if key := imp.name + " " + imp.path; !seen[key] {
+				seen[key] = true
+				imports = append(imports, imp)
+			}
+		}
+	}
+
+	// Replace the first declaration by the merged one and remove the others
+	// with the blank lines preceding them
+	first := decls[0]
+	res := append([]byte(nil), dat[:fset.Position(first.Pos()).Offset]...)
+	res = append(res, formatImports(imports)...)
+	last := fset.Position(first.End()).Offset
+	for _, decl := range decls[1:] {
+		start := fset.Position(decl.Pos()).Offset
+		start = len(bytes.TrimRight(dat[:start], " \t\r\n"))
+		res = append(res, dat[last:start]...)
+		last = fset.Position(decl.End()).Offset
+	}
+	return append(res, dat[last:]...), nil
+}
+
+// edit replaces the [start, end) byte range of the data by text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+type byEditStart []edit
+
+func (b byEditStart) Len() int           { return len(b) }
+func (b byEditStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byEditStart) Less(i, j int) bool { return b[i].start < b[j].start }
+
+// applyEdits applies edits which do not overlap.
+func applyEdits(dat []byte, edits []edit) []byte {
+	sort.Stable(byEditStart(edits))
+	var res []byte
+	last := 0
+	for _, e := range edits {
+		res = append(res, dat[last:e.start]...)
+		res = append(res, e.text...)
+		last = e.end
+	}
+	return append(res, dat[last:]...)
+}
+
+// lineRange extends the [start, end) range to the whole lines containing it.
+func lineRange(dat []byte, start, end int) (int, int) {
+	start = bytes.LastIndexByte(dat[:start], '\n') + 1
+	if i := bytes.IndexByte(dat[end:], '\n'); i >= 0 {
+		return start, end + i + 1
+	}
+	return start, len(dat)
+}
+
+// importName returns the name under which the file imports the package, or ""
+// if it does not import it.
+func importName(f *ast.File, path string) string {
+	for _, spec := range f.Imports {
+		if spec.Path.Value != strconv.Quote(path) {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return path[strings.LastIndex(path, "/")+1:]
+	}
+	return ""
+}
+
+// addImportEdit returns the edit adding the import of a standard library package to
+// the file, next to the other standard library imports if any.
+func addImportEdit(fset *token.FileSet, f *ast.File, dat []byte, path string) edit {
+	quoted := strconv.Quote(path)
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	var decl *ast.GenDecl
+	for _, d := range f.Decls {
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			decl = gen
+			break
+		}
+	}
+	if decl == nil {
+		end := offset(f.Name.End())
+		return edit{end, end, "\n\nimport " + quoted}
+	}
+	if !decl.Lparen.IsValid() {
+		spec := decl.Specs[0].(*ast.ImportSpec)
+		imports := []goImport{goImport{path: quoted}, goImport{path: spec.Path.Value}}
+		if spec.Name != nil {
+			imports[1].name = spec.Name.Name
+		}
+		return edit{offset(decl.Pos()), offset(decl.End()), formatImports(imports)}
+	}
+
+	// Insert before the first standard import sorted after the package, or after the last one
+	var before, after *ast.ImportSpec
+	for _, s := range decl.Specs {
+		spec := s.(*ast.ImportSpec)
+		if !isStdImport(spec.Path.Value) {
+			continue
+		}
+		if spec.Path.Value > quoted {
+			before = spec
+			break
+		}
+		after = spec
+	}
+	switch {
+	case before != nil:
+		start, _ := lineRange(dat, offset(before.Pos()), offset(before.End()))
+		return edit{start, start, "\t" + quoted + "\n"}
+	case after != nil:
+		_, end := lineRange(dat, offset(after.Pos()), offset(after.End()))
+		return edit{end, end, "\t" + quoted + "\n"}
+	}
+	if len(decl.Specs) > 0 {
+		start, _ := lineRange(dat, offset(decl.Specs[0].Pos()), offset(decl.Specs[0].Pos()))
+		return edit{start, start, "\t" + quoted + "\n\n"}
+	}
+	return edit{offset(decl.Lparen) + 1, offset(decl.Lparen) + 1, "\n\t" + quoted + "\n"}
+}
+
+// removeImportEdit returns the edit removing the import spec, or the whole
+// declaration if it is its only spec.
+func removeImportEdit(fset *token.FileSet, f *ast.File, dat []byte, path string) (edit, bool) {
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	for _, d := range f.Decls {
+		gen, ok := d.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, s := range gen.Specs {
+			spec := s.(*ast.ImportSpec)
+			if spec.Path.Value != strconv.Quote(path) {
+				continue
+			}
+			node := ast.Node(spec)
+			if len(gen.Specs) == 1 {
+				node = gen
+			}
+			start, end := lineRange(dat, offset(node.Pos()), offset(node.End()))
+			// Remove the blank line preceding the spec or the declaration when
+			// it was the last one of its group
+			next := bytes.TrimLeft(dat[end:], " \t")
+			if start > 1 && dat[start-2] == '\n' && (bytes.HasPrefix(next, []byte(")")) || bytes.HasPrefix(next, []byte("\n"))) {
+				start--
+			}
+			return edit{start, end, ""}, true
+		}
+	}
+	return edit{}, false
+}
+
+// pkgErrors is the import path of the package providing errors.Wrap.
+const pkgErrors = "github.com/pkg/errors"
+
+// RewriteErrorWrap rewrites the errors.Wrap(err, "msg") calls of the
+// github.com/pkg/errors package to fmt.Errorf("msg: %w", err), and the
+// errors.Wrapf(err, "format", args...) ones to fmt.Errorf("format: %w", args..., err).
+// The fmt import is added if needed and the github.com/pkg/errors one removed
+// when it is not used anymore. Files which cannot be parsed are left untouched,
+// or reported with -strict.
+// Note that errors.Wrap returns nil for a nil error, unlike fmt.Errorf.
+//
+// proc:
+//  -
+//    name: RewriteErrorWrap
+func (p *Procedures) RewriteErrorWrap(dat []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("RewriteErrorWrap", err)
+	}
+	name := importName(f, pkgErrors)
+	if name == "" || name == "_" || name == "." {
+		return dat, nil
+	}
+
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	text := func(n ast.Node) string { return string(dat[offset(n.Pos()):offset(n.End())]) }
+	var edits []edit
+	rewritten := make(map[*ast.SelectorExpr]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) < 2 || call.Ellipsis.IsValid() {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || !isIdent(sel.X, name) {
+			return true
+		}
+		if !(sel.Sel.Name == "Wrap" && len(call.Args) == 2) && sel.Sel.Name != "Wrapf" {
+			return true
+		}
+		msg, ok := call.Args[1].(*ast.BasicLit)
+		if !ok || msg.Kind != token.STRING {
+			return true
+		}
+
+		// Add ": %w" before the closing quote of the message, keeping it verbatim
+		lit := msg.Value
+		if sel.Sel.Name == "Wrap" {
+			lit = strings.Replace(lit, "%", "%%", -1)
+		}
+		args := []string{lit[:len(lit)-1] + ": %w" + lit[len(lit)-1:]}
+		for _, arg := range call.Args[2:] {
+			args = append(args, text(arg))
+		}
+		args = append(args, text(call.Args[0]))
+		edits = append(edits, edit{offset(call.Pos()), offset(call.End()), "fmt.Errorf(" + strings.Join(args, ", ") + ")"})
+		rewritten[sel] = true
+		// The calls nested in the arguments are kept verbatim, they are
+		// rewritten when the procedure is applied again, e.g. with -fixpoint
+		return false
+	})
+	if len(edits) == 0 {
+		return dat, nil
+	}
+
+	used := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && isIdent(sel.X, name) && !rewritten[sel] {
+			used = true
+		}
+		return !used
+	})
+	var remove edit
+	removed := false
+	if !used {
+		remove, removed = removeImportEdit(fset, f, dat, pkgErrors)
+	}
+	if importName(f, "fmt") == "" {
+		add := addImportEdit(fset, f, dat, "fmt")
+		if removed && add.start < remove.end && remove.start < add.end {
+			// The errors import was the only one, replace it
+			add.text = "import \"fmt\""
+			removed = false
+		}
+		edits = append(edits, add)
+	}
+	if removed {
+		edits = append(edits, remove)
+	}
+	return applyEdits(dat, edits), nil
+}
+
+// RemoveRedundantImportAliases removes the aliases of the imports which are the
+// default name of the package, e.g. foo "path/foo" becomes "path/foo", as done
+// by goimports. The default name is taken as the last element of the import
+// path, so the aliases of paths like "gopkg.in/yaml.v2" or "example.com/go-foo"
+// are kept, as well as the blank and dot imports. The packages declaring another
+// name than the last element of their path must not be used with this procedure.
+// Files which cannot be parsed are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: RemoveRedundantImportAliases
+func (p *Procedures) RemoveRedundantImportAliases(dat []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("RemoveRedundantImportAliases", err)
+	}
+
+	var edits []edit
+	for _, spec := range f.Imports {
+		if spec.Name == nil {
+			continue
+		}
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || spec.Name.Name != path[strings.LastIndex(path, "/")+1:] {
+			continue
+		}
+		edits = append(edits, edit{fset.Position(spec.Name.Pos()).Offset, fset.Position(spec.Path.Pos()).Offset, ""})
+	}
+	return applyEdits(dat, edits), nil
+}
+
+// callArgRegex matches the references to the arguments in the templates of RewriteCall.
+var callArgRegex = regexp.MustCompile(`\$(\d+)`)
+
+// RewriteCall rewrites the arguments of the calls to the function, "Name" or
+// "pkg.Name", following the template, where $1, $2... are the original arguments
+// copied verbatim, e.g. "$2, $1" swaps the two arguments and
+// "context.Background(), $1, $2" inserts a new first argument. The calls whose
+// number of arguments differs from the highest one referenced by the template,
+// or passing a variadic argument with "...", are left untouched, so that no
+// argument is dropped by mistake. The calls nested in the arguments are
+// rewritten too, and the comments between the arguments are dropped. Files
+// which cannot be parsed are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: RewriteCall
+//    params:
+//      - "strings.Replace"
+//      - "$1, $2, $3, -1"
+func (p *Procedures) RewriteCall(dat []byte, function, template string) ([]byte, error) {
+	pkg, name := "", function
+	if i := strings.LastIndex(function, "."); i >= 0 {
+		pkg, name = function[:i], function[i+1:]
+	}
+	if name == "" {
+		return dat, fmt.Errorf("invalid function name %q", function)
+	}
+	used := 0
+	for _, m := range callArgRegex.FindAllStringSubmatch(template, -1) {
+		if n, _ := strconv.Atoi(m[1]); n == 0 {
+			return dat, fmt.Errorf("the arguments are numbered from $1 but found %s", m[0])
+		} else if n > used {
+			used = n
+		}
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("RewriteCall", err)
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	// The calls are visited in source order, the enclosing ones first
+	var calls []*ast.CallExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || call.Ellipsis.IsValid() || len(call.Args) != used {
+			return true
+		}
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			if pkg != "" || fun.Name != name {
+				return true
+			}
+		case *ast.SelectorExpr:
+			if pkg == "" || fun.Sel.Name != name || !isIdent(fun.X, pkg) {
+				return true
+			}
+		default:
+			return true
+		}
+		calls = append(calls, call)
+		return true
+	})
+
+	// render returns the source between the offsets with the calls rewritten,
+	// the calls nested in the arguments being rewritten first
+	var render func(start, end int) string
+	render = func(start, end int) string {
+		var buf bytes.Buffer
+		last := start
+		for _, call := range calls {
+			if offset(call.Pos()) < last || offset(call.End()) > end {
+				continue
+			}
+			buf.Write(dat[last : offset(call.Lparen)+1])
+			buf.WriteString(callArgRegex.ReplaceAllStringFunc(template, func(ref string) string {
+				n, _ := strconv.Atoi(ref[1:])
+				arg := call.Args[n-1]
+				return render(offset(arg.Pos()), offset(arg.End()))
+			}))
+			last = offset(call.Rparen)
+		}
+		buf.Write(dat[last:end])
+		return buf.String()
+	}
+	if len(calls) == 0 {
+		return dat, nil
+	}
+	return []byte(render(0, len(dat))), nil
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// goDirectiveRegex matches the comment lines which are directives, like
+// "//go:generate", and cannot be moved into a block comment.
+var goDirectiveRegex = regexp.MustCompile(`^//(line |extern |export |[a-z0-9]+:[a-z0-9])`)
+
+// starPrefixRegex matches the decoration starting the lines of block comments, e.g. " * ".
+var starPrefixRegex = regexp.MustCompile(`^[ \t]*\*( |$)`)
+
+// NormalizeDocComments converts the /* ... */ doc comments of the declarations
+// to the idiomatic // line comments, or the line comments to block comments
+// with the "block" param. Only the doc comments of the package, declarations,
+// specs and fields are converted, the other comments and the line comments
+// holding directives like "//go:generate" are left untouched. Files which
+// cannot be parsed are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: NormalizeDocComments
+//    params: line
+func (p *Procedures) NormalizeDocComments(dat []byte, style ...string) ([]byte, error) {
+	toBlock := false
+	if len(style) > 0 {
+		switch style[0] {
+		case "line":
+		case "block":
+			toBlock = true
+		default:
+			return dat, fmt.Errorf("unknown doc comment style %q, expected line or block", style[0])
+		}
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("NormalizeDocComments", err)
+	}
+
+	docs := []*ast.CommentGroup{f.Doc}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			docs = append(docs, n.Doc)
+		case *ast.GenDecl:
+			docs = append(docs, n.Doc)
+		case *ast.TypeSpec:
+			docs = append(docs, n.Doc)
+		case *ast.ValueSpec:
+			docs = append(docs, n.Doc)
+		case *ast.Field:
+			docs = append(docs, n.Doc)
+		}
+		return true
+	})
+
+	var edits []edit
+	seen := make(map[*ast.CommentGroup]bool)
+	for _, doc := range docs {
+		if doc == nil || seen[doc] {
+			continue
+		}
+		seen[doc] = true
+		start, end := fset.Position(doc.Pos()).Offset, fset.Position(doc.End()).Offset
+		lineStart, lineEnd := lineRange(dat, start, end)
+		indent := string(dat[lineStart:start])
+		// Skip the comments sharing their lines with code
+		if strings.TrimSpace(indent) != "" || strings.TrimSpace(string(dat[end:lineEnd])) != "" {
+			continue
+		}
+		var text string
+		var ok bool
+		if toBlock {
+			text, ok = blockDocComment(doc, indent)
+		} else {
+			text, ok = lineDocComment(doc, indent)
+		}
+		if ok {
+			edits = append(edits, edit{start, end, text})
+		}
+	}
+	return applyEdits(dat, edits), nil
+}
+
+// lineDocComment formats a /* ... */ doc comment as line comments.
+func lineDocComment(doc *ast.CommentGroup, indent string) (string, bool) {
+	if len(doc.List) != 1 || !strings.HasPrefix(doc.List[0].Text, "/*") {
+		return "", false
+	}
+	raw := doc.List[0].Text
+	lines := strings.Split(raw[2:len(raw)-2], "\n")
+	first, rest := strings.TrimSpace(lines[0]), lines[1:]
+
+	// Remove the " * " decoration of the following lines, or their common indentation
+	starred := true
+	for _, line := range rest {
+		starred = starred && (strings.TrimSpace(line) == "" || starPrefixRegex.MatchString(line))
+	}
+	common := commonIndent(rest)
+	for i, line := range rest {
+		if starred {
+			rest[i] = starPrefixRegex.ReplaceAllString(line, "")
+		} else {
+			rest[i] = strings.TrimPrefix(line, common)
+		}
+	}
+
+	lines = append([]string{first}, rest...)
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	for i, line := range lines {
+		if line = strings.TrimRight(line, " \t"); line == "" {
+			lines[i] = "//"
+		} else {
+			lines[i] = "// " + line
+		}
+	}
+	return strings.Join(lines, "\n"+indent), true
+}
+
+// commonIndent returns the leading whitespaces shared by the non blank lines.
+func commonIndent(lines []string) string {
+	common, first := "", true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			common, first = lead, false
+			continue
+		}
+		for !strings.HasPrefix(lead, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	return common
+}
+
+// blockDocComment formats a doc comment made of line comments as a block comment.
+func blockDocComment(doc *ast.CommentGroup, indent string) (string, bool) {
+	var lines []string
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, "//") || goDirectiveRegex.MatchString(c.Text) || strings.Contains(c.Text, "*/") {
+			return "", false
+		}
+		line := strings.TrimPrefix(c.Text[2:], " ")
+		if line != "" {
+			line = indent + line
+		}
+		lines = append(lines, line)
+	}
+	return "/*\n" + strings.Join(lines, "\n") + "\n" + indent + "*/", true
+}
+
+// defaultTagKeys are the struct tag keys whose options are canonicalized by default.
+var defaultTagKeys = []string{"json", "xml", "yaml", "toml"}
+
+// CanonicalizeTagOptions sorts the options of the struct tags and removes the
+// duplicated ones, the name staying first, e.g. json:"x,string,omitempty,omitempty"
+// becomes json:"x,omitempty,string". Only the tags with the given keys are
+// rewritten, json, xml, yaml and toml by default. The tags which do not follow
+// the key:"value" convention are left untouched, as well as the files which
+// cannot be parsed, unless -strict is set.
+//
+// proc:
+//  -
+//    name: CanonicalizeTagOptions
+//    params:
+//      - json
+//      - yaml
+func (p *Procedures) CanonicalizeTagOptions(dat []byte, keys ...string) ([]byte, error) {
+	if len(keys) == 0 {
+		keys = defaultTagKeys
+	}
+	targets := make(map[string]bool)
+	for _, key := range keys {
+		targets[key] = true
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("CanonicalizeTagOptions", err)
+	}
+
+	var edits []edit
+	ast.Inspect(f, func(n ast.Node) bool {
+		field, ok := n.(*ast.Field)
+		if !ok || field.Tag == nil {
+			return true
+		}
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			return true
+		}
+		res, ok := canonicalTag(tag, targets)
+		if !ok || res == tag {
+			return true
+		}
+		lit := strconv.Quote(res)
+		if strings.HasPrefix(field.Tag.Value, "`") && !strings.Contains(res, "`") {
+			lit = "`" + res + "`"
+		}
+		edits = append(edits, edit{fset.Position(field.Tag.Pos()).Offset, fset.Position(field.Tag.End()).Offset, lit})
+		return true
+	})
+	return applyEdits(dat, edits), nil
+}
+
+// canonicalTag sorts and deduplicates the options of the tag values with the
+// given keys, the trailing empty options being kept last. The rest of the tag is
+// kept as is, values and spaces included. It returns false if the tag does not
+// follow the key:"value" convention.
+func canonicalTag(tag string, keys map[string]bool) (string, bool) {
+	var res bytes.Buffer
+	for tag != "" {
+		trimmed := strings.TrimLeft(tag, " ")
+		res.WriteString(tag[:len(tag)-len(trimmed)])
+		if tag = trimmed; tag == "" {
+			break
+		}
+		i := strings.Index(tag, ":\"")
+		if i <= 0 || strings.ContainsAny(tag[:i], " \"") {
+			return "", false
+		}
+		key := tag[:i]
+		// Find the closing quote, skipping the escaped characters
+		j := i + 2
+		for j < len(tag) && tag[j] != '"' {
+			if tag[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(tag) {
+			return "", false
+		}
+		quoted := tag[i+1 : j+1]
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", false
+		}
+		tag = tag[j+1:]
+
+		if keys[key] {
+			if options := canonicalOptions(value); options != value {
+				quoted = strconv.Quote(options)
+			}
+		}
+		res.WriteString(key + ":" + quoted)
+	}
+	return res.String(), true
+}
+
+// canonicalOptions sorts and deduplicates the options following the name of the
+// tag value. The trailing empty options, e.g. the one of json:"-,", stay last.
+func canonicalOptions(value string) string {
+	parts := strings.Split(value, ",")
+	n := len(parts)
+	for n > 1 && parts[n-1] == "" {
+		n--
+	}
+	seen := make(map[string]bool)
+	var options []string
+	for _, option := range parts[1:n] {
+		if !seen[option] {
+			seen[option] = true
+			options = append(options, option)
+		}
+	}
+	sort.Strings(options)
+	options = append(options, parts[n:]...)
+	return strings.Join(append(parts[:1], options...), ",")
+}
+
+// WrapParams puts each parameter of the function signatures, and each argument
+// of the calls, on its own line when the line holding them is longer than the
+// width, counting a tab as 4 columns. The parameters are indented by one more
+// tab than the line, followed by a trailing comma, and printed like gofmt does:
+//
+//	func copyFile(
+//		src string,
+//		dst string,
+//	) error {
+//
+// The names sharing a type get their own line each. Only the signatures and
+// calls written on a single line and without comments are wrapped, the nested
+// calls staying on the line of their argument. Files which cannot be parsed
+// are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: WrapParams
+//    params: "100"
+func (p *Procedures) WrapParams(dat []byte, width string) ([]byte, error) {
+	limit, err := strconv.Atoi(width)
+	if err != nil || limit < 1 {
+		return dat, fmt.Errorf("invalid width: %s", width)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", dat, parser.ParseComments)
+	if err != nil {
+		return dat, unparsable("WrapParams", err)
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	source := func(node ast.Node) string {
+		var buf bytes.Buffer
+		printer.Fprint(&buf, fset, node)
+		return buf.String()
+	}
+
+	// A line is wrapped once, at its outermost signature or call
+	var edits []edit
+	wrapped := make(map[int]bool)
+	wrap := func(lparen, rparen token.Pos, items []string) {
+		start, end := offset(lparen), offset(rparen)
+		if len(items) == 0 || bytes.IndexByte(dat[start:end], '\n') >= 0 {
+			return
+		}
+		for _, c := range f.Comments {
+			if c.Pos() > lparen && c.End() < rparen {
+				return
+			}
+		}
+		lineStart, lineEnd := lineRange(dat, start, end)
+		line := strings.TrimRight(string(dat[lineStart:lineEnd]), "\r\n")
+		if wrapped[lineStart] || len(line)+3*strings.Count(line, "\t") <= limit {
+			return
+		}
+		wrapped[lineStart] = true
+		eol := "\n"
+		if strings.HasSuffix(string(dat[lineStart:lineEnd]), "\r\n") {
+			eol = "\r\n"
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		var buf bytes.Buffer
+		buf.WriteString("(" + eol)
+		for _, item := range items {
+			buf.WriteString(indent + "\t" + item + "," + eol)
+		}
+		buf.WriteString(indent)
+		edits = append(edits, edit{start, end, buf.String()})
+	}
+
+	// The nodes are visited in source order, the enclosing ones first
+	ast.Inspect(f, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FuncType:
+			if n.Params == nil || !n.Params.Opening.IsValid() {
+				return true
+			}
+			var params []string
+			for _, field := range n.Params.List {
+				typ := source(field.Type)
+				if len(field.Names) == 0 {
+					params = append(params, typ)
+				}
+				for _, name := range field.Names {
+					params = append(params, name.Name+" "+typ)
+				}
+			}
+			wrap(n.Params.Opening, n.Params.Closing, params)
+		case *ast.CallExpr:
+			var args []string
+			for _, arg := range n.Args {
+				args = append(args, source(arg))
+			}
+			if n.Ellipsis.IsValid() && len(args) > 0 {
+				args[len(args)-1] += "..."
+			}
+			wrap(n.Lparen, n.Rparen, args)
+		}
+		return true
+	})
+	return applyEdits(dat, edits), nil
+}
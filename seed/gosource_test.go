@@ -0,0 +1,489 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import "testing"
+
+var tabsGo = "package main\n\nvar usage = `Usage:\n\tseed fix\n`\n\nfunc main() {\n\tif true {\n\t\tprintln(\"a\\tb\", '\\t')\n\t}\n  \tprintln(`\t`)\n}\n"
+
+var expectedTabsGo = "package main\n\nvar usage = `Usage:\n\tseed fix\n`\n\nfunc main() {\n    if true {\n        println(\"a\\tb\", '\\t')\n    }\n    println(`\t`)\n}\n"
+
+func TestExpandTabsSafe(t *testing.T) {
+	var p *Procedures
+	res, err := p.ExpandTabsSafe([]byte(tabsGo))
+	if err != nil || string(res) != expectedTabsGo {
+		t.Errorf("ExpandTabsSafe: expected\n%s\nbut found %v\n%s", expectedTabsGo, err, res)
+	}
+
+	res, err = p.ExpandTabsSafe([]byte("package main\n\nfunc main() {\n\tprintln()\n}\n"), "2")
+	if err != nil || string(res) != "package main\n\nfunc main() {\n  println()\n}\n" {
+		t.Errorf("ExpandTabsSafe should use the given width but found %q, %v", res, err)
+	}
+
+	unterminated := "package main\nvar s = `unterminated\n\tx\n"
+	if res, err = p.ExpandTabsSafe([]byte(unterminated)); err != nil || string(res) != unterminated {
+		t.Errorf("ExpandTabsSafe should skip the files which cannot be scanned but found %q, %v", res, err)
+	}
+	strict = true
+	defer func() { strict = false }()
+	if _, err = p.ExpandTabsSafe([]byte(unterminated)); err == nil {
+		t.Error("ExpandTabsSafe should report the files which cannot be scanned with -strict")
+	}
+}
+
+var generateGo = `package main
+
+//go:generate stringer -type=Kind
+
+import "fmt"
+
+//go:generate mockgen -source=a.go -destination=mock.go
+
+// Kind of things.
+//go:generate stringer -type=Kind
+type Kind int
+
+func main() {
+	fmt.Println("//go:generate is not a directive here")
+}
+
+//go:generate echo done
+`
+
+var expectedGenerateGo = `package main
+
+import "fmt"
+
+//go:generate echo done
+//go:generate mockgen -source=a.go -destination=mock.go
+//go:generate stringer -type=Kind
+
+// Kind of things.
+type Kind int
+
+func main() {
+	fmt.Println("//go:generate is not a directive here")
+}
+`
+
+func TestSortGoGenerate(t *testing.T) {
+	var p *Procedures
+	res, err := p.SortGoGenerate([]byte(generateGo))
+	if err != nil || string(res) != expectedGenerateGo {
+		t.Errorf("SortGoGenerate: expected\n%s\nbut found %v\n%s", expectedGenerateGo, err, res)
+	}
+
+	again, err := p.SortGoGenerate(res)
+	if err != nil || string(again) != expectedGenerateGo {
+		t.Errorf("SortGoGenerate should be idempotent but found %v\n%s", err, again)
+	}
+
+	invalid := "package main\nimport ("
+	if res, err = p.SortGoGenerate([]byte(invalid)); err != nil || string(res) != invalid {
+		t.Errorf("SortGoGenerate should skip the files which cannot be parsed but found %q, %v", res, err)
+	}
+	strict = true
+	defer func() { strict = false }()
+	if _, err = p.SortGoGenerate([]byte(invalid)); err == nil {
+		t.Error("SortGoGenerate should report the files which cannot be parsed with -strict")
+	}
+}
+
+var twoImportBlocksGo = `package main
+
+import (
+	"fmt"
+	"github.com/seedstack/tools/lib" // shared helpers
+)
+
+import "os"
+
+import (
+	"bytes"
+	"fmt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func main() {}
+`
+
+var mergedImportBlocksGo = `package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/seedstack/tools/lib" // shared helpers
+	yaml "gopkg.in/yaml.v2"
+)
+
+func main() {}
+`
+
+func TestMergeImportBlocks(t *testing.T) {
+	var p *Procedures
+	res, err := p.MergeImportBlocks([]byte(twoImportBlocksGo))
+	if err != nil || string(res) != mergedImportBlocksGo {
+		t.Errorf("MergeImportBlocks: expected\n%s\nbut found %v\n%s", mergedImportBlocksGo, err, res)
+	}
+
+	if res, _ = p.MergeImportBlocks(res); string(res) != mergedImportBlocksGo {
+		t.Errorf("MergeImportBlocks should leave a single block untouched but found\n%s", res)
+	}
+
+	cgo := "package main\n\n// #include <stdio.h>\nimport \"C\"\n\nimport \"fmt\"\n"
+	if res, _ = p.MergeImportBlocks([]byte(cgo)); string(res) != cgo {
+		t.Errorf("MergeImportBlocks should leave the C import apart but found\n%s", res)
+	}
+
+	invalid := "package main\n\nimport \"fmt\"\nimport (\n"
+	if res, err = p.MergeImportBlocks([]byte(invalid)); err != nil || string(res) != invalid {
+		t.Errorf("MergeImportBlocks should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+}
+
+var errorWrapGo = `package store
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "cannot open 100% of the store")
+	}
+	defer f.Close()
+	return errors.Wrapf(check(f), "invalid store %s", path)
+}
+`
+
+var rewrittenErrorWrapGo = `package store
+
+import (
+	"fmt"
+	"os"
+)
+
+func load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open 100%% of the store: %w", err)
+	}
+	defer f.Close()
+	return fmt.Errorf("invalid store %s: %w", path, check(f))
+}
+`
+
+func TestRewriteErrorWrap(t *testing.T) {
+	var p *Procedures
+	res, err := p.RewriteErrorWrap([]byte(errorWrapGo))
+	if err != nil || string(res) != rewrittenErrorWrapGo {
+		t.Errorf("RewriteErrorWrap: expected\n%s\nbut found %v\n%s", rewrittenErrorWrapGo, err, res)
+	}
+
+	single := "package a\n\nimport \"github.com/pkg/errors\"\n\nvar e = errors.Wrap(err, \"a\")\n"
+	expected := "package a\n\nimport \"fmt\"\n\nvar e = fmt.Errorf(\"a: %w\", err)\n"
+	if res, _ = p.RewriteErrorWrap([]byte(single)); string(res) != expected {
+		t.Errorf("RewriteErrorWrap should replace the only import, expected\n%s\nbut found\n%s", expected, res)
+	}
+
+	stillUsed := "package a\n\nimport (\n\t\"github.com/pkg/errors\"\n)\n\nvar e = errors.Wrap(errors.New(\"a\"), `b`)\n"
+	expected = "package a\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/pkg/errors\"\n)\n\nvar e = fmt.Errorf(`b: %w`, errors.New(\"a\"))\n"
+	if res, _ = p.RewriteErrorWrap([]byte(stillUsed)); string(res) != expected {
+		t.Errorf("RewriteErrorWrap should keep the import still used, expected\n%s\nbut found\n%s", expected, res)
+	}
+
+	invalid := "package a\n\nvar e = errors.Wrap(err, \"a\"\n"
+	if res, err = p.RewriteErrorWrap([]byte(invalid)); err != nil || string(res) != invalid {
+		t.Errorf("RewriteErrorWrap should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+}
+
+var blockDocGo = `package a
+
+/*
+ * Sum adds the numbers.
+ *
+ *     Sum(1, 2) == 3
+ */
+func Sum(a, b int) int {
+	return a + b /* inline */
+}
+
+/* Config holds the settings. */
+type Config struct {
+	/*
+	   Name of the
+	   service.
+	*/
+	Name string
+}
+`
+
+var lineDocGo = `package a
+
+// Sum adds the numbers.
+//
+//     Sum(1, 2) == 3
+func Sum(a, b int) int {
+	return a + b /* inline */
+}
+
+// Config holds the settings.
+type Config struct {
+	// Name of the
+	// service.
+	Name string
+}
+`
+
+func TestNormalizeDocComments(t *testing.T) {
+	var p *Procedures
+	res, err := p.NormalizeDocComments([]byte(blockDocGo))
+	if err != nil || string(res) != lineDocGo {
+		t.Errorf("NormalizeDocComments: expected\n%s\nbut found %v\n%s", lineDocGo, err, res)
+	}
+	if again, _ := p.NormalizeDocComments(res, "line"); string(again) != lineDocGo {
+		t.Errorf("NormalizeDocComments should keep the line comments but found\n%s", again)
+	}
+
+	src := "package a\n\n// Run starts.\n//\n// It blocks.\nfunc Run() {}\n\n//go:generate stringer\n//\n// Kind is the kind.\ntype Kind int\n"
+	expected := "package a\n\n/*\nRun starts.\n\nIt blocks.\n*/\nfunc Run() {}\n\n//go:generate stringer\n//\n// Kind is the kind.\ntype Kind int\n"
+	if res, err = p.NormalizeDocComments([]byte(src), "block"); err != nil || string(res) != expected {
+		t.Errorf("NormalizeDocComments: expected\n%s\nbut found %v\n%s", expected, err, res)
+	}
+
+	invalid := "package a\n\n/* Doc */\nfunc {\n"
+	if res, err = p.NormalizeDocComments([]byte(invalid)); err != nil || string(res) != invalid {
+		t.Errorf("NormalizeDocComments should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+	if _, err = p.NormalizeDocComments([]byte(src), "fancy"); err == nil {
+		t.Error("NormalizeDocComments should reject an unknown style")
+	}
+}
+
+var tagsGo = `package a
+
+type Config struct {
+	Name    string ` + "`" + `json:"name,omitempty,omitempty" yaml:"name"` + "`" + `
+	Port    int    ` + "`" + `json:"port,string,omitempty" validate:"required,min=1"` + "`" + `
+	Comment string "json:\"-\""
+	Hosts   []string ` + "`" + `xml:"host,attr,omitempty,attr"` + "`" + `
+	Odd     string ` + "`" + `not a convention` + "`" + `
+}
+`
+
+var canonicalTagsGo = `package a
+
+type Config struct {
+	Name    string ` + "`" + `json:"name,omitempty" yaml:"name"` + "`" + `
+	Port    int    ` + "`" + `json:"port,omitempty,string" validate:"required,min=1"` + "`" + `
+	Comment string "json:\"-\""
+	Hosts   []string ` + "`" + `xml:"host,attr,omitempty"` + "`" + `
+	Odd     string ` + "`" + `not a convention` + "`" + `
+}
+`
+
+func TestCanonicalizeTagOptions(t *testing.T) {
+	var p *Procedures
+	res, err := p.CanonicalizeTagOptions([]byte(tagsGo))
+	if err != nil || string(res) != canonicalTagsGo {
+		t.Errorf("CanonicalizeTagOptions: expected\n%s\nbut found %v\n%s", canonicalTagsGo, err, res)
+	}
+
+	src := "package a\n\ntype T struct {\n\tX int `json:\"x,omitempty,omitempty\" yaml:\"x,omitempty,omitempty\"`\n}\n"
+	expected := "package a\n\ntype T struct {\n\tX int `json:\"x,omitempty,omitempty\" yaml:\"x,omitempty\"`\n}\n"
+	if res, err = p.CanonicalizeTagOptions([]byte(src), "yaml"); err != nil || string(res) != expected {
+		t.Errorf("CanonicalizeTagOptions should only rewrite the given keys but found %v\n%s", err, res)
+	}
+	dash := "package a\n\ntype T struct {\n\tX int `json:\"-,\"`\n}\n"
+	if res, err = p.CanonicalizeTagOptions([]byte(dash)); err != nil || string(res) != dash {
+		t.Errorf("CanonicalizeTagOptions should keep the comma of a field named \"-\" but found %v\n%s", err, res)
+	}
+	trailing := "package a\n\ntype T struct {\n\tX int `json:\"x,omitempty,\"  yaml:\"x\"`\n}\n"
+	if res, err = p.CanonicalizeTagOptions([]byte(trailing)); err != nil || string(res) != trailing {
+		t.Errorf("CanonicalizeTagOptions should keep the sorted tags as they are but found %v\n%s", err, res)
+	}
+	spaced := "package a\n\ntype T struct {\n\tX int `json:\"x,string,omitempty,\"  db:\"x\"`\n}\n"
+	expected = "package a\n\ntype T struct {\n\tX int `json:\"x,omitempty,string,\"  db:\"x\"`\n}\n"
+	if res, err = p.CanonicalizeTagOptions([]byte(spaced)); err != nil || string(res) != expected {
+		t.Errorf("CanonicalizeTagOptions should only reorder the options but found %v\n%s", err, res)
+	}
+	invalid := "package a\n\ntype T struct {\n"
+	if res, err = p.CanonicalizeTagOptions([]byte(invalid)); err != nil || string(res) != invalid {
+		t.Errorf("CanonicalizeTagOptions should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+}
+
+var aliasedImportsGo = `package main
+
+import (
+	fmt "fmt"
+	"os"
+
+	errors "github.com/pkg/errors"
+	pkgerrors "github.com/pkg/errors/v2"
+	yaml "gopkg.in/yaml.v2"
+	_ "net/http/pprof"
+)
+
+import strings "strings"
+
+func main() {}
+`
+
+var unaliasedImportsGo = `package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	pkgerrors "github.com/pkg/errors/v2"
+	yaml "gopkg.in/yaml.v2"
+	_ "net/http/pprof"
+)
+
+import "strings"
+
+func main() {}
+`
+
+func TestRemoveRedundantImportAliases(t *testing.T) {
+	var p *Procedures
+	res, err := p.RemoveRedundantImportAliases([]byte(aliasedImportsGo))
+	if err != nil || string(res) != unaliasedImportsGo {
+		t.Errorf("RemoveRedundantImportAliases: expected\n%s\nbut found %v\n%s", unaliasedImportsGo, err, res)
+	}
+	invalid := "package a\n\nimport fmt \"fmt\"\n\nfunc {\n"
+	if res, err = p.RemoveRedundantImportAliases([]byte(invalid)); err != nil || string(res) != invalid {
+		t.Errorf("RemoveRedundantImportAliases should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+}
+
+var callsGo = `package main
+
+import "strings"
+
+func main() {
+	copyFile(dst, src)
+	copyFile(dst, src, // comment
+	)
+	copyFile(dst, copyFile(a, b))
+	other.copyFile(dst, src)
+	s := strings.Replace(s, "a", "b")
+	copyFile(files...)
+}
+`
+
+var rewrittenCallsGo = `package main
+
+import "strings"
+
+func main() {
+	copyFile(src, dst)
+	copyFile(src, dst)
+	copyFile(copyFile(b, a), dst)
+	other.copyFile(dst, src)
+	s := strings.Replace(s, "a", "b", -1)
+	copyFile(files...)
+}
+`
+
+func TestRewriteCall(t *testing.T) {
+	var p *Procedures
+	res, err := p.RewriteCall([]byte(callsGo), "copyFile", "$2, $1")
+	if err == nil {
+		res, err = p.RewriteCall(res, "strings.Replace", "$1, $2, $3, -1")
+	}
+	if err != nil || string(res) != rewrittenCallsGo {
+		t.Errorf("RewriteCall: expected\n%s\nbut found %v\n%s", rewrittenCallsGo, err, res)
+	}
+
+	nested := "package a\n\nvar x = f(1, f(2, f(3, 4)), f(5, 6))\n"
+	if res, err = p.RewriteCall([]byte(nested), "f", "$2, $1"); err != nil || string(res) != "package a\n\nvar x = f(1, f(f(4, 3), 2), f(6, 5))\n" {
+		t.Errorf("RewriteCall should rewrite the nested calls too but found %v\n%s", err, res)
+	}
+
+	extra := "package a\n\nvar x = f(a, b, c)\n"
+	if res, err = p.RewriteCall([]byte(extra), "f", "$2, $1"); err != nil || string(res) != extra {
+		t.Errorf("RewriteCall should not drop the arguments beyond the template but found %v\n%s", err, res)
+	}
+
+	if _, err = p.RewriteCall([]byte(callsGo), "copyFile", "$0"); err == nil {
+		t.Error("RewriteCall should reject the $0 argument")
+	}
+	invalid := "package a\n\nfunc {\n"
+	if res, err = p.RewriteCall([]byte(invalid), "f", "$1"); err != nil || string(res) != invalid {
+		t.Errorf("RewriteCall should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+}
+
+var longParamsGo = `package files
+
+func copyFile(src, dst string, perm os.FileMode, opts ...Option) error {
+	return copyWithBuffer(make([]byte, 32*1024), src, dst, perm, opts...)
+}
+
+func short(a int) { f(a) }
+
+func commented(src string /* source */, dst string, perm os.FileMode, opts ...Option) error {
+	return nil
+}
+`
+
+var wrappedParamsGo = `package files
+
+func copyFile(
+	src string,
+	dst string,
+	perm os.FileMode,
+	opts ...Option,
+) error {
+	return copyWithBuffer(
+		make([]byte, 32*1024),
+		src,
+		dst,
+		perm,
+		opts...,
+	)
+}
+
+func short(a int) { f(a) }
+
+func commented(src string /* source */, dst string, perm os.FileMode, opts ...Option) error {
+	return nil
+}
+`
+
+func TestWrapParams(t *testing.T) {
+	var p *Procedures
+	res, err := p.WrapParams([]byte(longParamsGo), "60")
+	if err != nil || string(res) != wrappedParamsGo {
+		t.Errorf("WrapParams: expected\n%s\nbut found %v\n%s", wrappedParamsGo, err, res)
+	}
+	if res, err = p.WrapParams(res, "60"); err != nil || string(res) != wrappedParamsGo {
+		t.Errorf("WrapParams should leave the wrapped params untouched but found %v\n%s", err, res)
+	}
+	if res, err = p.WrapParams([]byte(longParamsGo), "100"); err != nil || string(res) != longParamsGo {
+		t.Errorf("WrapParams should keep the short lines inline but found %v\n%s", err, res)
+	}
+
+	if _, err = p.WrapParams([]byte(longParamsGo), "wide"); err == nil {
+		t.Error("WrapParams should reject an invalid width")
+	}
+	invalid := "package a\n\nfunc {\n"
+	if res, err = p.WrapParams([]byte(invalid), "10"); err != nil || string(res) != invalid {
+		t.Errorf("WrapParams should skip the files which cannot be parsed but found %v\n%s", err, res)
+	}
+}
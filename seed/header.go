@@ -0,0 +1,355 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// lineComments maps the file extensions to their line comment prefix.
+var lineComments = map[string]string{
+	".go": "//", ".c": "//", ".h": "//", ".cpp": "//", ".hpp": "//", ".java": "//",
+	".js": "//", ".ts": "//", ".cs": "//", ".kt": "//", ".scala": "//", ".swift": "//",
+	".sh": "#", ".bash": "#", ".yml": "#", ".yaml": "#", ".py": "#", ".rb": "#",
+	".toml": "#", ".properties": "#", ".conf": "#",
+}
+
+// blockComments maps the file extensions to their block comment delimiters.
+var blockComments = map[string][2]string{
+	".md": {"<!--", "-->"}, ".html": {"<!--", "-->"}, ".htm": {"<!--", "-->"},
+	".xml": {"<!--", "-->"}, ".svg": {"<!--", "-->"},
+}
+
+// commentHeader wraps the header in the comment syntax of the file extension.
+func commentHeader(header, ext string) (string, error) {
+	lines := strings.Split(strings.TrimRight(header, "\r\n"), "\n")
+	if prefix, ok := lineComments[ext]; ok {
+		for i, line := range lines {
+			if line = strings.TrimRight(line, " \t\r"); line == "" {
+				lines[i] = prefix
+			} else {
+				lines[i] = prefix + " " + line
+			}
+		}
+		return strings.Join(lines, "\n") + "\n", nil
+	}
+	if delims, ok := blockComments[ext]; ok {
+		return delims[0] + "\n" + strings.Join(lines, "\n") + "\n" + delims[1] + "\n", nil
+	}
+	return "", fmt.Errorf("no comment syntax known for the %s files", ext)
+}
+
+// headerOffset returns where the header of the file starts, after a shebang
+// or an XML declaration.
+func headerOffset(dat []byte) int {
+	if bytes.HasPrefix(dat, []byte("#!")) || bytes.HasPrefix(dat, []byte("<?xml")) {
+		if i := bytes.IndexByte(dat, '\n'); i >= 0 {
+			return i + 1
+		}
+		return len(dat)
+	}
+	return 0
+}
+
+// LicenseHeaderByType inserts the header at the beginning of the file, wrapped
+// in the comment syntax of its type: "//" for Go, C or Java, "#" for shell,
+// YAML or Python and "<!-- -->" for markdown, HTML or XML. Files already
+// starting with the header are left untouched. Shebangs and XML declarations
+// are kept first.
+//
+// proc:
+//  -
+//    name: LicenseHeaderByType
+//    params: |
+//      Copyright (c) 2015 by The SeedStack authors. All rights reserved.
+//
+//      This Source Code Form is subject to the terms of the Mozilla Public License, v. 2.0.
+func (p *Procedures) LicenseHeaderByType(dat []byte, header string) ([]byte, error) {
+	if p.fileName == "" {
+		return dat, fmt.Errorf("the name of the file is unknown")
+	}
+	comment, err := commentHeader(header, strings.ToLower(filepath.Ext(p.fileName)))
+	if err != nil {
+		return dat, err
+	}
+
+	offset := headerOffset(dat)
+	if bytes.HasPrefix(dat[offset:], []byte(comment)) {
+		return dat, nil
+	}
+	res := append([]byte(nil), dat[:offset]...)
+	res = append(res, comment...)
+	if len(dat) > offset {
+		res = append(res, '\n')
+	}
+	return append(res, dat[offset:]...), nil
+}
+
+// NormalizeShebang rewrites the shebang of the scripts to look their interpreter
+// up with env, e.g. "#!/bin/bash" becomes "#!/usr/bin/env bash". Only the given
+// interpreters are rewritten, or all of them without params. Files without a
+// shebang are left untouched, as well as the shebangs passing arguments to the
+// interpreter, which env cannot portably forward.
+//
+// proc:
+//  -
+//    name: NormalizeShebang
+//    params:
+//      - bash
+//      - python3
+func (p *Procedures) NormalizeShebang(dat []byte, interpreters ...string) []byte {
+	if !bytes.HasPrefix(dat, []byte("#!")) {
+		return dat
+	}
+	end := bytes.IndexByte(dat, '\n')
+	if end == -1 {
+		end = len(dat)
+	}
+	line := bytes.TrimRight(dat[:end], "\r")
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 {
+		return dat
+	}
+	interpreter, args := path.Base(fields[0]), fields[1:]
+	if interpreter == "env" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		interpreter, args = args[0], args[1:]
+	}
+	if interpreter == "env" || len(args) > 0 {
+		return dat
+	}
+	if len(interpreters) > 0 {
+		known := false
+		for _, i := range interpreters {
+			known = known || i == interpreter
+		}
+		if !known {
+			return dat
+		}
+	}
+
+	shebang := "#!/usr/bin/env " + interpreter
+	if string(line) == shebang {
+		return dat
+	}
+	return append([]byte(shebang), dat[len(line):]...)
+}
+
+// EnsureBlankAfterHeader makes sure that exactly one blank line separates the
+// comment header of the file, e.g. its license, from the first line of code.
+// The missing blank line is inserted and the extra ones are removed. The header
+// is made of the comments starting the file, after a shebang or an XML
+// declaration, and may contain blank lines itself. For the languages commented
+// with "//", a group of line comments directly followed by code is its doc
+// comment, e.g. the package doc of a Go file, and not part of the header: the
+// header then ends at the previous group. Files without a header or without
+// code after it are left untouched.
+//
+// proc:
+//  -
+//    name: EnsureBlankAfterHeader
+func (p *Procedures) EnsureBlankAfterHeader(dat []byte) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(p.fileName))
+	prefix, lineComment := lineComments[ext]
+	delims, blockComment := blockComments[ext]
+	if prefix == "//" {
+		delims, blockComment = [2]string{"/*", "*/"}, true
+	}
+	if !lineComment && !blockComment {
+		return dat, fmt.Errorf("no comment syntax known for the %s files", ext)
+	}
+
+	offset := headerOffset(dat)
+	lines := strings.SplitAfter(string(dat[offset:]), "\n")
+	// end is the index of the line following the header and code the one of
+	// the first line of code, previous is the end of the comment group before
+	// the last one
+	end, code, previous := 0, -1, 0
+	inBlock, lineGroup := false, false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if inBlock {
+			if strings.Contains(trimmed, delims[1]) {
+				inBlock, end = false, i+1
+			}
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+		isLine := lineComment && strings.HasPrefix(trimmed, prefix)
+		isBlock := !isLine && blockComment && strings.HasPrefix(trimmed, delims[0])
+		if !isLine && !isBlock {
+			code = i
+			break
+		}
+		if i > end || end == 0 {
+			// A new comment group starts
+			previous, lineGroup = end, isLine
+		}
+		lineGroup = lineGroup && isLine
+		if isBlock {
+			inBlock = !strings.Contains(trimmed[len(delims[0]):], delims[1])
+		}
+		end = i + 1
+	}
+	if prefix == "//" && lineGroup && code == end {
+		// The last group is the doc comment of the code
+		end = previous
+		for code = end; strings.TrimSpace(lines[code]) == ""; code++ {
+		}
+	}
+	if end == 0 || code == -1 {
+		return dat, nil
+	}
+
+	eol := "\n"
+	if strings.HasSuffix(lines[end-1], "\r\n") {
+		eol = "\r\n"
+	}
+	if code == end+1 && lines[end] == eol {
+		return dat, nil
+	}
+	res := append([]byte(nil), dat[:offset]...)
+	res = append(res, strings.Join(lines[:end], "")...)
+	res = append(res, eol...)
+	return append(res, strings.Join(lines[code:], "")...), nil
+}
+
+// blockHeaderEnds maps the opening delimiters of the block comment headers to
+// their closing one.
+var blockHeaderEnds = map[string]string{"/*": "*/", "<!--": "-->"}
+
+// EnsureHeader makes the file start with the header, given as is, comment
+// syntax included, or as the path of a file holding it. Files already starting
+// with the header are left untouched. A header with the same first line but a
+// different body is outdated and replaced: for a header made of line comments,
+// it spans over the comments and blank lines starting the file, except a doc
+// comment directly followed by code, and for a block comment header up to its
+// closing delimiter. Otherwise the header is inserted, followed by a blank line.
+// The lines are compared without their terminator and the header is written with
+// the line endings of the file, CRLF included. Shebangs and XML declarations are
+// kept first.
+//
+// proc:
+//  -
+//    name: EnsureHeader
+//    params: "headers/mpl.txt"
+func (p *Procedures) EnsureHeader(dat []byte, header string) ([]byte, error) {
+	if !strings.Contains(header, "\n") {
+		// A single line too long to be a file name is the header itself
+		if content, err := ioutil.ReadFile(header); err == nil {
+			header = string(content)
+		} else if !os.IsNotExist(err) && !isNameTooLong(err) {
+			return dat, err
+		}
+	}
+	header = strings.Replace(strings.TrimRight(header, "\r\n"), "\r\n", "\n", -1) + "\n"
+	first := strings.TrimSpace(header[:strings.Index(header, "\n")])
+	if first == "" {
+		return dat, fmt.Errorf("the header must not start with a blank line")
+	}
+
+	offset := headerOffset(dat)
+	rest := string(dat[offset:])
+	lines := strings.SplitAfter(rest, "\n")
+	headerLines := strings.SplitAfter(header, "\n")
+	headerLines = headerLines[:len(headerLines)-1]
+	if startsWithLines(lines, headerLines) {
+		return dat, nil
+	}
+	eol := "\n"
+	if strings.HasSuffix(lines[0], "\r\n") {
+		eol = "\r\n"
+	}
+	header = strings.Replace(header, "\n", eol, -1)
+	var res string
+	if strings.TrimSpace(lines[0]) == first {
+		end := headerEnd(lines, first)
+		res = header + strings.Join(lines[end:], "")
+	} else if rest == "" {
+		res = header
+	} else {
+		res = header + eol + rest
+	}
+	return append(append([]byte(nil), dat[:offset]...), res...), nil
+}
+
+// startsWithLines tells whether the lines start with the expected ones, whatever
+// their terminator, LF or CRLF.
+func startsWithLines(lines, expected []string) bool {
+	if len(lines) < len(expected) {
+		return false
+	}
+	for i, line := range expected {
+		if !strings.HasSuffix(lines[i], "\n") || strings.TrimRight(lines[i], "\r\n") != strings.TrimRight(line, "\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameTooLong tells whether the error is due to a file name longer than the
+// system allows.
+func isNameTooLong(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == syscall.ENAMETOOLONG
+	}
+	return false
+}
+
+// headerEnd returns the index of the line following the header starting the
+// lines, whose first line is given.
+func headerEnd(lines []string, first string) int {
+	for open, close := range blockHeaderEnds {
+		if strings.HasPrefix(first, open) {
+			for i, line := range lines {
+				if i == 0 {
+					line = strings.TrimSpace(line)[len(open):]
+				}
+				if strings.Contains(line, close) {
+					return i + 1
+				}
+			}
+			return len(lines)
+		}
+	}
+
+	prefix := first
+	if i := strings.IndexAny(first, " \t"); i >= 0 {
+		prefix = first[:i]
+	}
+	// end is the end of the last comment group, previous the one of the group before
+	end, previous := 0, 0
+	i := 0
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(trimmed, prefix) {
+			break
+		}
+		if i > end {
+			previous = end
+		}
+		end = i + 1
+	}
+	if i == end && i < len(lines) && previous > 0 {
+		// The last group is the doc comment of the code
+		return previous
+	}
+	return end
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const licenseHeader = "Copyright (c) 2015 The authors.\n\nReleased under the MPL 2.0.\n"
+
+func TestLicenseHeaderByType(t *testing.T) {
+	cases := []struct {
+		file, src, expected string
+	}{
+		{"main.go", "package main\n",
+			"// Copyright (c) 2015 The authors.\n//\n// Released under the MPL 2.0.\n\npackage main\n"},
+		{"run.sh", "#!/bin/sh\necho ok\n",
+			"#!/bin/sh\n# Copyright (c) 2015 The authors.\n#\n# Released under the MPL 2.0.\n\necho ok\n"},
+		{"README.md", "# Title\n",
+			"<!--\nCopyright (c) 2015 The authors.\n\nReleased under the MPL 2.0.\n-->\n\n# Title\n"},
+	}
+
+	for _, c := range cases {
+		p := &Procedures{fileName: c.file}
+		res, err := p.LicenseHeaderByType([]byte(c.src), licenseHeader)
+		if err != nil || string(res) != c.expected {
+			t.Errorf("LicenseHeaderByType(%s): %q was expected but found %q, %v", c.file, c.expected, res, err)
+		}
+
+		// The header is only inserted once
+		if again, _ := p.LicenseHeaderByType(res, licenseHeader); string(again) != c.expected {
+			t.Errorf("LicenseHeaderByType(%s) should not insert the header twice but found %q", c.file, again)
+		}
+	}
+
+	p := &Procedures{fileName: "data.bin"}
+	if _, err := p.LicenseHeaderByType([]byte("x"), licenseHeader); err == nil {
+		t.Error("LicenseHeaderByType should fail for an unknown file type")
+	}
+}
+
+func TestNormalizeShebang(t *testing.T) {
+	cases := []struct {
+		src, expected string
+	}{
+		{"#!/bin/bash\necho ok\n", "#!/usr/bin/env bash\necho ok\n"},
+		{"#! /usr/local/bin/python3\r\nprint()\n", "#!/usr/bin/env python3\r\nprint()\n"},
+		{"#!/usr/bin/env bash\n", "#!/usr/bin/env bash\n"},
+		{"#!/bin/bash -e\n", "#!/bin/bash -e\n"},
+		{"#!/bin/sh\n", "#!/bin/sh\n"},
+		{"echo '#!/bin/bash'\n", "echo '#!/bin/bash'\n"},
+		{"package main\n", "package main\n"},
+	}
+
+	var p *Procedures
+	for _, c := range cases {
+		if res := p.NormalizeShebang([]byte(c.src), "bash", "python3"); string(res) != c.expected {
+			t.Errorf("NormalizeShebang: %q was expected but found %q", c.expected, res)
+		}
+	}
+	if res := p.NormalizeShebang([]byte("#!/bin/sh")); string(res) != "#!/usr/bin/env sh" {
+		t.Errorf("NormalizeShebang should rewrite all the interpreters without params but found %q", res)
+	}
+}
+
+func TestEnsureBlankAfterHeader(t *testing.T) {
+	header := "// Copyright (c) 2015 The authors.\n\n// Released under the MPL 2.0.\n"
+	doc := "// Package seed fixes trees.\npackage seed\n"
+	cases := []struct {
+		file, src, expected string
+	}{
+		{"main.go", "// Copyright\npackage main\n", "// Copyright\npackage main\n"},
+		{"main.go", "// Copyright\n\n\nfunc main() {}\n", "// Copyright\n\nfunc main() {}\n"},
+		{"main.go", header + "package main\n", header + "package main\n"},
+		{"main.go", header + "\n\n" + doc, header + "\n" + doc},
+		{"main.go", header + "\n" + doc, header + "\n" + doc},
+		{"main.go", doc, doc},
+		{"main.go", header + "\npackage main\n", header + "\npackage main\n"},
+		{"main.go", header + "\n \n\npackage main\n", header + "\npackage main\n"},
+		{"lib.c", "/*\n * Copyright\n */\nint x;\n", "/*\n * Copyright\n */\n\nint x;\n"},
+		{"run.sh", "#!/bin/sh\n# Copyright\necho ok\n", "#!/bin/sh\n# Copyright\n\necho ok\n"},
+		{"run.sh", "#!/bin/sh\necho ok\n", "#!/bin/sh\necho ok\n"},
+		{"README.md", "<!--\nCopyright\n-->\n\n\n# Title\n", "<!--\nCopyright\n-->\n\n# Title\n"},
+		{"main.go", "package main\n", "package main\n"},
+		{"doc.go", header, header},
+	}
+
+	for _, c := range cases {
+		p := &Procedures{fileName: c.file}
+		res, err := p.EnsureBlankAfterHeader([]byte(c.src))
+		if err != nil || string(res) != c.expected {
+			t.Errorf("EnsureBlankAfterHeader(%s): %q was expected but found %q, %v", c.file, c.expected, res, err)
+		}
+		if again, _ := p.EnsureBlankAfterHeader(res); string(again) != c.expected {
+			t.Errorf("EnsureBlankAfterHeader(%s) should be idempotent but found %q", c.file, again)
+		}
+	}
+
+	p := &Procedures{fileName: "data.bin"}
+	if _, err := p.EnsureBlankAfterHeader([]byte("data")); err == nil {
+		t.Error("EnsureBlankAfterHeader should fail for an unknown file type")
+	}
+}
+
+const mplHeader = `// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+`
+
+func TestEnsureHeader(t *testing.T) {
+	var p *Procedures
+	withHeader := mplHeader + "\n// Package main is the seed command.\npackage main\n"
+	cases := []struct {
+		name, src string
+	}{
+		{"no header", "// Package main is the seed command.\npackage main\n"},
+		{"correct header", withHeader},
+		{"stale header", "// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.\n\n" +
+			"// Licensed under the Apache License.\n\n// Package main is the seed command.\npackage main\n"},
+	}
+	for _, c := range cases {
+		res, err := p.EnsureHeader([]byte(c.src), mplHeader)
+		if err != nil || string(res) != withHeader {
+			t.Errorf("EnsureHeader with %s: expected\n%s\nbut found %v\n%s", c.name, withHeader, err, res)
+		}
+	}
+
+	stale := "// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.\n// Old body.\n\npackage main\n"
+	if res, err := p.EnsureHeader([]byte(stale), mplHeader); err != nil || string(res) != mplHeader+"\npackage main\n" {
+		t.Errorf("EnsureHeader should replace the stale header but found %v\n%s", err, res)
+	}
+	block := "/*\n * Copyright 2015\n */\n"
+	if res, err := p.EnsureHeader([]byte("#!/bin/sh\n/*\n * Copyright 2013\n */\necho\n"), block); err != nil ||
+		string(res) != "#!/bin/sh\n"+block+"echo\n" {
+		t.Errorf("EnsureHeader should replace the stale block header after the shebang but found %v\n%s", err, res)
+	}
+
+	crlf := strings.Replace(withHeader, "\n", "\r\n", -1)
+	if res, err := p.EnsureHeader([]byte(crlf), mplHeader); err != nil || string(res) != crlf {
+		t.Errorf("EnsureHeader should keep the CRLF file with the header but found %v\n%q", err, res)
+	}
+	noHeader := "// Package main is the seed command.\r\npackage main\r\n"
+	if res, err := p.EnsureHeader([]byte(noHeader), mplHeader); err != nil || string(res) != crlf {
+		t.Errorf("EnsureHeader should insert the header with CRLF but found %v\n%q", err, res)
+	}
+	long := "// " + strings.Repeat("Copyright ", 30)
+	if res, err := p.EnsureHeader([]byte("package main\n"), long); err != nil || string(res) != long+"\n\npackage main\n" {
+		t.Errorf("EnsureHeader should take a long single line as the header but found %v\n%s", err, res)
+	}
+
+	dir, err := ioutil.TempDir("", "seed-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mpl.txt")
+	ioutil.WriteFile(path, []byte(mplHeader), 0644)
+	if res, err := p.EnsureHeader([]byte("package main\n"), path); err != nil || string(res) != mplHeader+"\npackage main\n" {
+		t.Errorf("EnsureHeader should read the header from the file but found %v\n%s", err, res)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// importLineRegexes match the lines holding a whole import statement, by language.
+var importLineRegexes = map[string]*regexp.Regexp{
+	"js": regexp.MustCompile(`^(?:import\s+(?:[^'"]*\sfrom\s+)?['"][^'"]+['"]|` +
+		`(?:const|let|var)\s+[^=]+=\s*require\(\s*['"][^'"]+['"]\s*\)|require\(\s*['"][^'"]+['"]\s*\));?$`),
+	"python": regexp.MustCompile(`^(?:from\s+[\w.]+\s+)?import\s+[^(\\#]+?(?:\s*#.*)?$`),
+}
+
+// DedupeImports removes the import lines repeating a previous one of the file,
+// for the given language: import and require statements for "js", import and
+// from ... import for "python". The first occurrence is kept, in its place.
+// Only the unindented statements holding on a single line are considered, so
+// that the imports of two Python functions are not merged.
+//
+// proc:
+//  -
+//    name: DedupeImports
+//    params: js
+func (p *Procedures) DedupeImports(dat []byte, language string) ([]byte, error) {
+	if alias, ok := languageAliases[language]; ok {
+		language = alias
+	}
+	re, ok := importLineRegexes[language]
+	if !ok {
+		return dat, fmt.Errorf("unknown language %q, expected js or python", language)
+	}
+
+	seen := make(map[string]bool)
+	var res []string
+	for _, line := range strings.SplitAfter(string(dat), "\n") {
+		content := strings.TrimRight(line, " \t\r\n")
+		if re.MatchString(content) {
+			if seen[content] {
+				continue
+			}
+			seen[content] = true
+		}
+		res = append(res, line)
+	}
+	return []byte(strings.Join(res, "")), nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import "testing"
+
+func TestDedupeImports(t *testing.T) {
+	var p *Procedures
+	js := `import React from 'react';
+import { render } from "react-dom";
+import React from 'react';
+const fs = require('fs');
+import './styles.css';
+import {
+  a,
+} from 'lib';
+import './styles.css';
+const fs = require('fs');
+import React from 'react'
+`
+	expected := `import React from 'react';
+import { render } from "react-dom";
+const fs = require('fs');
+import './styles.css';
+import {
+  a,
+} from 'lib';
+import React from 'react'
+`
+	res, err := p.DedupeImports([]byte(js), "js")
+	if err != nil || string(res) != expected {
+		t.Errorf("DedupeImports should keep the first of the identical JS imports but found %v:\n%s", err, res)
+	}
+
+	py := `import os
+from typing import List
+import os
+
+def first():
+    import json
+    return json
+
+def second():
+    import json
+    return json
+from typing import List  # again
+from typing import List
+`
+	expectedPy := `import os
+from typing import List
+
+def first():
+    import json
+    return json
+
+def second():
+    import json
+    return json
+from typing import List  # again
+`
+	res, err = p.DedupeImports([]byte(py), "py")
+	if err != nil || string(res) != expectedPy {
+		t.Errorf("DedupeImports should keep the first of the identical Python imports but found %v:\n%s", err, res)
+	}
+
+	if _, err = p.DedupeImports([]byte(js), "ruby"); err == nil {
+		t.Error("DedupeImports should reject an unknown language")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// incrementalState is the content of the -incremental state file. It records the
+// commit at which each file was last fixed, by path relative to the fixed directory,
+// for the transformations identified by Tdf.
+type incrementalState struct {
+	Tdf   string            `json:"tdf"`
+	Files map[string]string `json:"files"`
+
+	path    string          // the state file
+	root    string          // the fixed directory
+	head    string          // the commit checked out when the run started
+	tracked map[string]bool // the files tracked by git, the only ones recorded
+}
+
+// tdfHash identifies the transformations applied to the files: the transformation
+// file, once its variables are rendered, and the version of seed.
+func tdfHash(dat []byte) string {
+	sum := sha256.Sum256(append([]byte(versionLine()+"\n"), dat...))
+	return hex.EncodeToString(sum[:])
+}
+
+// startIncremental reads the state file and returns the files of the root which
+// changed since the commit at which they were last fixed, or which were never
+// fixed. The files which are not tracked by git are always kept. The recorded
+// commits are forgotten when the transformation file or the version of seed
+// changed. The state file itself is never fixed.
+func startIncremental(path, root string, files []string, tdf []byte) (*incrementalState, []string, error) {
+	head, err := gitHead(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("The -incremental flag needs a git working tree with a commit: %v", err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := &incrementalState{Tdf: tdfHash(tdf), Files: make(map[string]string), path: absPath, root: root, head: head}
+
+	dat, err := ioutil.ReadFile(absPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("Failed to read the state file %s: %v", path, err)
+	}
+	if err == nil {
+		var saved incrementalState
+		if err := json.Unmarshal(dat, &saved); err != nil {
+			return nil, nil, fmt.Errorf("Invalid state file %s: %v", path, err)
+		}
+		if saved.Tdf == state.Tdf && saved.Files != nil {
+			state.Files = saved.Files
+		}
+	}
+
+	tracked, err := gitTracked(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to list the files tracked by git: %v", err)
+	}
+	state.tracked = make(map[string]bool)
+	for _, f := range tracked {
+		state.tracked[f] = true
+	}
+
+	// The files changed since each recorded commit, nil when the commit is unknown,
+	// e.g. after a rebase
+	changed := make(map[string]map[string]bool)
+	var kept []string
+	skipped := 0
+	for _, f := range files {
+		if abs, err := filepath.Abs(f); err == nil && abs == absPath {
+			continue
+		}
+		rel := state.relPath(f)
+		commit, ok := state.Files[rel]
+		if !ok || !state.tracked[rel] {
+			kept = append(kept, f)
+			continue
+		}
+		paths, ok := changed[commit]
+		if !ok {
+			if diff, err := gitChangedSince(root, commit); err == nil {
+				paths = make(map[string]bool)
+				for _, p := range diff {
+					paths[p] = true
+				}
+			}
+			changed[commit] = paths
+		}
+		if paths == nil || paths[rel] {
+			kept = append(kept, f)
+		} else {
+			skipped++
+		}
+	}
+	if verbose {
+		fmt.Fprintf(logOutput(), "Skip %v files unchanged since their last fix\n", skipped)
+	}
+	return state, kept, nil
+}
+
+// relPath returns the path of the file relative to the fixed directory, with "/"
+// separators like git.
+func (s *incrementalState) relPath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	rel, err := filepath.Rel(s.root, abs)
+	if err != nil {
+		return file
+	}
+	return filepath.ToSlash(rel)
+}
+
+// finish records the commit of the run for the fixed files tracked by git and
+// writes the state file. The files which failed are fixed again on the next run.
+// Nothing is saved after an interruption, nor in check or dry-run mode, where the
+// files needing a fix are not written.
+func (s *incrementalState) finish(files []string, runErr error) error {
+	if check || dryRun {
+		return nil
+	}
+	failed := make(map[string]bool)
+	switch err := runErr.(type) {
+	case nil:
+	case failedFiles:
+		for _, e := range err {
+			failed[e.path] = true
+		}
+	default:
+		return nil
+	}
+	for _, f := range files {
+		rel := s.relPath(f)
+		if failed[f] || !s.tracked[rel] {
+			delete(s.Files, rel)
+		} else {
+			s.Files[rel] = s.head
+		}
+	}
+
+	dat, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = writeFileAtomic(s.path, 0644, func(w io.Writer) (bool, error) {
+		_, err := w.Write(append(dat, '\n'))
+		return true, err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to write the state file %s: %v", s.path, err)
+	}
+	return nil
+}
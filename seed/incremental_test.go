@@ -0,0 +1,91 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIncremental(t *testing.T) {
+	repo := tempGitRepo(t)
+	defer os.RemoveAll(repo)
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=seed", "-c", "user.email=seed@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	path := func(name string) string { return filepath.Join(repo, name) }
+	for _, f := range []string{"a.go", "b.go", "d.go"} {
+		ioutil.WriteFile(path(f), []byte("package main\n"), 0644)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+
+	statePath := path(".seed-state.json")
+	tdf := []byte("transformations: []\n")
+	files := []string{path("a.go"), path("b.go"), path("d.go")}
+	state, kept, err := startIncremental(statePath, repo, files, tdf)
+	if err != nil || !reflect.DeepEqual(kept, files) {
+		t.Fatalf("All the files should be fixed without state file but found %v (%v)", kept, err)
+	}
+	if err = state.finish(kept, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a.go is changed in a commit, b.go in the working tree and c.go is not tracked
+	ioutil.WriteFile(path("a.go"), []byte("package seed\n"), 0644)
+	git("commit", "-q", "-am", "second")
+	ioutil.WriteFile(path("b.go"), []byte("package seed\n"), 0644)
+	ioutil.WriteFile(path("c.go"), []byte("package main\n"), 0644)
+	files = []string{path("a.go"), path("b.go"), path("c.go"), path("d.go"), statePath}
+	state, kept, err = startIncremental(statePath, repo, files, tdf)
+	expected := []string{path("a.go"), path("b.go"), path("c.go")}
+	if err != nil || !reflect.DeepEqual(kept, expected) {
+		t.Errorf("The files changed since their last fix should be kept: %v was expected but found %v (%v)", expected, kept, err)
+	}
+
+	// A failed file is fixed again on the next run
+	if err = state.finish(kept, failedFiles{fileError{path("b.go"), errors.New("failure")}}); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "third")
+	_, kept, err = startIncremental(statePath, repo, files, tdf)
+	if expected := []string{path("b.go"), path("c.go")}; err != nil || !reflect.DeepEqual(kept, expected) {
+		t.Errorf("The failed and the changed files should be kept: %v was expected but found %v (%v)", expected, kept, err)
+	}
+
+	// Nothing is recorded in check mode
+	saved, _ := ioutil.ReadFile(statePath)
+	check = true
+	state, kept, _ = startIncremental(statePath, repo, files, tdf)
+	err = state.finish(kept, nil)
+	check = false
+	if dat, _ := ioutil.ReadFile(statePath); err != nil || string(dat) != string(saved) {
+		t.Errorf("The state file should not be written in check mode but found %v:\n%s", err, dat)
+	}
+
+	// Another transformation file forgets the recorded commits
+	if _, kept, err = startIncremental(statePath, repo, files, []byte("transformations: [{}]\n")); err != nil || len(kept) != 4 {
+		t.Errorf("All the files should be fixed with another transformation file but found %v (%v)", kept, err)
+	}
+	// So does an unknown commit, e.g. after a rebase
+	ioutil.WriteFile(statePath, []byte(`{"tdf": "`+tdfHash(tdf)+`", "files": {"d.go": "0123456789abcdef"}}`), 0644)
+	if _, kept, err = startIncremental(statePath, repo, files, tdf); err != nil || len(kept) != 4 {
+		t.Errorf("The files recorded at an unknown commit should be fixed but found %v (%v)", kept, err)
+	}
+}
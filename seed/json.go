@@ -0,0 +1,134 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// FixTrailingCommas removes the trailing commas before a closing brace or
+// bracket, which are not allowed in JSON. With the "add" param, it adds them
+// instead on the last element of the multiline objects and arrays, as usually
+// done in JavaScript or JSONC. Strings and comments are left untouched.
+//
+// proc:
+//  -
+//    name: FixTrailingCommas
+//    params: add
+func (p *Procedures) FixTrailingCommas(dat []byte, mode ...string) ([]byte, error) {
+	add := false
+	if len(mode) > 0 {
+		switch mode[0] {
+		case "add":
+			add = true
+		case "remove":
+		default:
+			return dat, fmt.Errorf("Unknown mode \"%s\", expected add or remove", mode[0])
+		}
+	}
+
+	var res bytes.Buffer
+	// last is the position in res of the last significant character
+	last, newline := -1, false
+	for i := 0; i < len(dat); i++ {
+		c := dat[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := i + 1
+			for end < len(dat) && dat[end] != c {
+				if dat[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(dat) {
+				end = len(dat) - 1
+			}
+			res.Write(dat[i : end+1])
+			last, newline = res.Len()-1, false
+			i = end
+		case c == '/' && i+1 < len(dat) && dat[i+1] == '/':
+			end := bytes.IndexByte(dat[i:], '\n')
+			if end < 0 {
+				end = len(dat) - i
+			}
+			res.Write(dat[i : i+end])
+			i += end - 1
+		case c == '/' && i+1 < len(dat) && dat[i+1] == '*':
+			end := bytes.Index(dat[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(dat) - i - 2
+			} else {
+				end += 2
+			}
+			comment := dat[i : i+2+end]
+			if bytes.IndexByte(comment, '\n') >= 0 {
+				newline = true
+			}
+			res.Write(comment)
+			i += len(comment) - 1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if c == '\n' {
+				newline = true
+			}
+			res.WriteByte(c)
+		case c == '}' || c == ']':
+			out := res.Bytes()
+			if !add && last >= 0 && out[last] == ',' {
+				// drop the comma, keeping what follows it
+				tail := append([]byte(nil), out[last+1:]...)
+				res.Truncate(last)
+				res.Write(tail)
+			} else if add && newline && last >= 0 && bytes.IndexByte([]byte(",{["), out[last]) < 0 {
+				tail := append([]byte(nil), out[last+1:]...)
+				res.Truncate(last + 1)
+				res.WriteByte(',')
+				res.Write(tail)
+			}
+			res.WriteByte(c)
+			last, newline = res.Len()-1, false
+		default:
+			res.WriteByte(c)
+			last, newline = res.Len()-1, false
+		}
+	}
+	return res.Bytes(), nil
+}
+
+// JSONCanonical formats a JSON file in a canonical form: the keys of the objects
+// are sorted, the indentation is two spaces and the file ends with a newline.
+// The numbers and the strings keep their value. Files which are not valid JSON
+// are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: JSONCanonical
+func (p *Procedures) JSONCanonical(dat []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(dat))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return dat, unparsable("JSONCanonical", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return dat, unparsable("JSONCanonical", fmt.Errorf("unexpected data after the JSON value"))
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		return dat, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var trailingCommasJSON = `{
+  "name": "seed, tools",
+  "list": [1, 2, 3,],
+  "nested": {
+    "a": "}",
+    "b": ",]", // trailing
+  },
+}
+`
+
+var fixedCommasJSON = `{
+  "name": "seed, tools",
+  "list": [1, 2, 3],
+  "nested": {
+    "a": "}",
+    "b": ",]" // trailing
+  }
+}
+`
+
+var addCommasJS = `const conf = {
+  name: 'seed',
+  list: [1, 2],
+  lines: [
+    "a",
+    "b" /* last */
+  ],
+  empty: {
+  }
+}
+`
+
+var addedCommasJS = `const conf = {
+  name: 'seed',
+  list: [1, 2],
+  lines: [
+    "a",
+    "b", /* last */
+  ],
+  empty: {
+  },
+}
+`
+
+func TestFixTrailingCommas(t *testing.T) {
+	var p *Procedures
+	res, err := p.FixTrailingCommas([]byte(trailingCommasJSON))
+	if err != nil || string(res) != fixedCommasJSON {
+		t.Errorf("FixTrailingCommas: %s was expected but found %s (%v)", fixedCommasJSON, res, err)
+	}
+
+	res, err = p.FixTrailingCommas([]byte(`{"a": [1, 2,], "b": {"c": true,},}`))
+	if err != nil || string(res) != `{"a": [1, 2], "b": {"c": true}}` || !json.Valid(res) {
+		t.Errorf("FixTrailingCommas should produce valid JSON but found %s (%v)", res, err)
+	}
+
+	res, err = p.FixTrailingCommas([]byte(addCommasJS), "add")
+	if err != nil || string(res) != addedCommasJS {
+		t.Errorf("FixTrailingCommas: %s was expected but found %s (%v)", addedCommasJS, res, err)
+	}
+
+	res, err = p.FixTrailingCommas(res, "add")
+	if err != nil || string(res) != addedCommasJS {
+		t.Errorf("FixTrailingCommas should be idempotent but found %s (%v)", res, err)
+	}
+
+	if _, err = p.FixTrailingCommas([]byte("{}"), "other"); err == nil {
+		t.Error("FixTrailingCommas should reject an unknown mode")
+	}
+}
+
+var messyJSON = `{"name":"seed","tags":["go", "cli"],"nested":{"z":1.50,"a":{},"m":[]},
+  "html": "<b>&</b>", "big": 12345678901234567890, "empty": null}`
+
+var canonicalJSON = `{
+  "big": 12345678901234567890,
+  "empty": null,
+  "html": "<b>&</b>",
+  "name": "seed",
+  "nested": {
+    "a": {},
+    "m": [],
+    "z": 1.50
+  },
+  "tags": [
+    "go",
+    "cli"
+  ]
+}
+`
+
+func TestJSONCanonical(t *testing.T) {
+	var p *Procedures
+	res, err := p.JSONCanonical([]byte(messyJSON))
+	if err != nil || string(res) != canonicalJSON {
+		t.Errorf("JSONCanonical: expected\n%s\nbut found %v\n%s", canonicalJSON, err, res)
+	}
+
+	if again, err := p.JSONCanonical(res); err != nil || string(again) != string(res) {
+		t.Errorf("JSONCanonical should be a fixed point but found %v\n%s", err, again)
+	}
+
+	invalid := []byte(`{"a": 1,}`)
+	if res, err = p.JSONCanonical(invalid); err != nil || string(res) != string(invalid) {
+		t.Errorf("JSONCanonical should skip the invalid files but found %q, %v", res, err)
+	}
+	strict = true
+	defer func() { strict = false }()
+	if _, err = p.JSONCanonical(invalid); err == nil {
+		t.Error("JSONCanonical should report the invalid files with -strict")
+	}
+	if _, err = p.JSONCanonical([]byte(`{} {}`)); err == nil {
+		t.Error("JSONCanonical should report the data after the JSON value with -strict")
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// keyCaseSeparators are the separators of the words of the key styles.
+var keyCaseSeparators = map[string]string{"snake": "_", "kebab": "-", "camel": ""}
+
+// NormalizeKeyCase rewrites the keys of the mappings of a YAML or JSON file in
+// the given style, "snake", "camel" or "kebab", e.g. "maxRetries" becomes
+// "max_retries" in snake case. The keys of the nested mappings are rewritten
+// too, the values and the formatting are left untouched. Files which cannot be
+// parsed, or where two keys of a mapping would get the same name, are left
+// untouched, or reported with -strict. The keys of the YAML flow mappings are
+// not supported.
+//
+// proc:
+//  -
+//    name: NormalizeKeyCase
+//    params: snake
+func (p *Procedures) NormalizeKeyCase(dat []byte, style string) ([]byte, error) {
+	if _, ok := keyCaseSeparators[style]; !ok {
+		return dat, fmt.Errorf("unknown key style %q, expected snake, camel or kebab", style)
+	}
+	convert := func(key string) string { return keyCase(key, style) }
+
+	trimmed := bytes.TrimSpace(dat)
+	isJSON := len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed)
+	var before interface{}
+	var err error
+	if isJSON {
+		err = json.Unmarshal(dat, &before)
+	} else {
+		err = yaml.Unmarshal(dat, &before)
+	}
+	if err != nil {
+		return dat, unparsable("NormalizeKeyCase", err)
+	}
+	expected, err := renameKeys(before, convert, "")
+	if err != nil {
+		return dat, unparsable("NormalizeKeyCase", err)
+	}
+
+	var res []byte
+	var after interface{}
+	if isJSON {
+		res = renameJSONKeys(dat, convert)
+		err = json.Unmarshal(res, &after)
+	} else {
+		res = renameYamlKeys(dat, convert)
+		err = yaml.Unmarshal(res, &after)
+	}
+	if err != nil || !reflect.DeepEqual(expected, after) {
+		return dat, fmt.Errorf("some keys could not be rewritten in the %s style", style)
+	}
+	return res, nil
+}
+
+// keyCase returns the key in the given style. The words of the key are delimited
+// by underscores, dashes and case changes, e.g. "HTTPServer_port" has the words
+// "http", "server" and "port". The leading and trailing separators are kept.
+func keyCase(key, style string) string {
+	body := strings.Trim(key, "_-")
+	if body == "" {
+		return key
+	}
+	prefix := key[:strings.Index(key, body)]
+	suffix := key[len(prefix)+len(body):]
+
+	var words []string
+	var word []rune
+	runes := []rune(body)
+	for i, r := range runes {
+		if r == '_' || r == '-' {
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 {
+			prev := word[len(word)-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				words = append(words, string(word))
+				word = nil
+			}
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	for i, w := range words {
+		w = strings.ToLower(w)
+		if style == "camel" && i > 0 {
+			r := []rune(w)
+			w = string(unicode.ToUpper(r[0])) + string(r[1:])
+		}
+		words[i] = w
+	}
+	return prefix + strings.Join(words, keyCaseSeparators[style]) + suffix
+}
+
+// renameKeys returns a copy of the value with the string keys of its mappings
+// converted, or an error when two keys of a mapping get the same name. path is
+// the dotted path of the value, for the error.
+func renameKeys(value interface{}, convert func(string) string, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		origins := make(map[string]string, len(v))
+		for key, item := range v {
+			name := convert(key)
+			if other, ok := origins[name]; ok {
+				return nil, keyCollision(path, other, key, name)
+			}
+			origins[name] = key
+			renamed, err := renameKeys(item, convert, path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			res[name] = renamed
+		}
+		return res, nil
+	case map[interface{}]interface{}:
+		res := make(map[interface{}]interface{}, len(v))
+		origins := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			name := key
+			if s, ok := key.(string); ok {
+				name = convert(s)
+			}
+			if other, ok := origins[name]; ok {
+				return nil, keyCollision(path, other, key, name)
+			}
+			origins[name] = key
+			renamed, err := renameKeys(item, convert, fmt.Sprintf("%s.%v", path, name))
+			if err != nil {
+				return nil, err
+			}
+			res[name] = renamed
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			renamed, err := renameKeys(item, convert, path)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = renamed
+		}
+		return res, nil
+	}
+	return value, nil
+}
+
+// keyCollision reports two keys of a mapping getting the same name, in a
+// deterministic order.
+func keyCollision(path string, a, b, name interface{}) error {
+	first, second := fmt.Sprint(a), fmt.Sprint(b)
+	if second < first {
+		first, second = second, first
+	}
+	if path == "" {
+		path = "the top-level mapping"
+	} else {
+		path = strings.TrimPrefix(path, ".")
+	}
+	return fmt.Errorf("the keys %q and %q of %s would both be named %q", first, second, path, name)
+}
+
+// renameJSONKeys converts the keys of the objects of a JSON document, the strings
+// followed by a colon, leaving the rest of the text untouched.
+func renameJSONKeys(dat []byte, convert func(string) string) []byte {
+	var res bytes.Buffer
+	for i := 0; i < len(dat); i++ {
+		if dat[i] != '"' {
+			res.WriteByte(dat[i])
+			continue
+		}
+		end := i + 1
+		for end < len(dat) && dat[end] != '"' {
+			if dat[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		raw := dat[i : end+1]
+		next := end + 1
+		for next < len(dat) && strings.IndexByte(" \t\r\n", dat[next]) != -1 {
+			next++
+		}
+		var key string
+		if next < len(dat) && dat[next] == ':' && json.Unmarshal(raw, &key) == nil {
+			if name := convert(key); name != key {
+				if !bytes.ContainsRune(raw, '\\') {
+					raw = []byte(`"` + name + `"`)
+				} else if quoted, err := json.Marshal(name); err == nil {
+					raw = quoted
+				}
+			}
+		}
+		res.Write(raw)
+		i = end
+	}
+	return res.Bytes()
+}
+
+// renameYamlKeys converts the keys of the block mappings of a YAML document,
+// keeping their quotes. The content of the block scalars is skipped.
+func renameYamlKeys(dat []byte, convert func(string) string) []byte {
+	lines := strings.SplitAfter(string(dat), "\n")
+	blockIndent := -1
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(content)
+		indent := len(content) - len(strings.TrimLeft(content, " "))
+		if blockIndent >= 0 {
+			if trimmed == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		m := yamlKeyRegex.FindStringSubmatchIndex(content)
+		if m == nil {
+			continue
+		}
+		if value := strings.TrimSpace(content[m[1]:]); strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+		}
+		raw := content[m[4]:m[5]]
+		quote := ""
+		if strings.HasPrefix(raw, `"`) || strings.HasPrefix(raw, "'") {
+			quote = raw[:1]
+		}
+		key := strings.Trim(raw, `"'`)
+		if strings.ContainsRune(key, '\\') {
+			continue
+		}
+		if name := convert(key); name != key {
+			lines[i] = line[:m[4]] + quote + name + quote + line[m[5]:]
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
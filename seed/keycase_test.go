@@ -0,0 +1,111 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyCase(t *testing.T) {
+	cases := []struct {
+		key, style, expected string
+	}{
+		{"maxRetries", "snake", "max_retries"},
+		{"HTTPServer", "snake", "http_server"},
+		{"userID", "kebab", "user-id"},
+		{"max_retries", "camel", "maxRetries"},
+		{"max-retries", "snake", "max_retries"},
+		{"_internalId", "snake", "_internal_id"},
+		{"v2Api", "snake", "v2_api"},
+		{"name", "camel", "name"},
+		{"app.kubernetes.io/name", "snake", "app.kubernetes.io/name"},
+	}
+	for _, c := range cases {
+		if res := keyCase(c.key, c.style); res != c.expected {
+			t.Errorf("keyCase(%q, %s): %q was expected but found %q", c.key, c.style, c.expected, res)
+		}
+	}
+}
+
+func TestNormalizeKeyCase(t *testing.T) {
+	var p *Procedures
+	yml := `# service configuration
+serviceName: "fooBar" # the value is kept
+maxRetries: 3
+httpClient:
+  connectTimeout: 10s
+  'readTimeout': 5s
+endpoints:
+  - baseUrl: http://localhost
+    isDefault: true
+description: |
+  someKey: not a key
+`
+	expected := `# service configuration
+service_name: "fooBar" # the value is kept
+max_retries: 3
+http_client:
+  connect_timeout: 10s
+  'read_timeout': 5s
+endpoints:
+  - base_url: http://localhost
+    is_default: true
+description: |
+  someKey: not a key
+`
+	res, err := p.NormalizeKeyCase([]byte(yml), "snake")
+	if err != nil || string(res) != expected {
+		t.Errorf("NormalizeKeyCase should rewrite the YAML keys in snake case but found %v:\n%s", err, res)
+	}
+
+	json := `{
+  "serviceName": "fooBar",
+  "retryPolicy": {"maxRetries": 3, "backOff": ["initialDelay"]}
+}
+`
+	expectedJSON := `{
+  "service_name": "fooBar",
+  "retry_policy": {"max_retries": 3, "back_off": ["initialDelay"]}
+}
+`
+	res, err = p.NormalizeKeyCase([]byte(json), "snake")
+	if err != nil || string(res) != expectedJSON {
+		t.Errorf("NormalizeKeyCase should rewrite the JSON keys in snake case but found %v:\n%s", err, res)
+	}
+
+	res, err = p.NormalizeKeyCase([]byte(expectedJSON), "camel")
+	if err != nil || string(res) != json {
+		t.Errorf("NormalizeKeyCase should rewrite the JSON keys in camel case but found %v:\n%s", err, res)
+	}
+
+	if _, err = p.NormalizeKeyCase([]byte(yml), "pascal"); err == nil {
+		t.Error("NormalizeKeyCase should reject an unknown style")
+	}
+}
+
+func TestNormalizeKeyCaseCollision(t *testing.T) {
+	var p *Procedures
+	yml := "db:\n  maxPool: 10\n  max_pool: 20\n"
+	res, err := p.NormalizeKeyCase([]byte(yml), "snake")
+	if err != nil || string(res) != yml {
+		t.Errorf("NormalizeKeyCase should leave the file with colliding keys untouched but found %v:\n%s", err, res)
+	}
+
+	strict = true
+	defer func() { strict = false }()
+	_, err = p.NormalizeKeyCase([]byte(yml), "snake")
+	if err == nil || !strings.Contains(err.Error(), `"maxPool" and "max_pool" of db`) {
+		t.Errorf("NormalizeKeyCase should report the colliding keys with -strict but found %v", err)
+	}
+	_, err = p.NormalizeKeyCase([]byte(`{"a-b": 1, "aB": 2}`), "kebab")
+	if err == nil || !strings.Contains(err.Error(), "top-level mapping") {
+		t.Errorf("NormalizeKeyCase should report the colliding JSON keys with -strict but found %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// lineMode is the mode of the transformations applied line by line.
+const lineMode = "line"
+
+// lineProcs are the procedures which only need the current line, the
+// only ones accepted in line mode.
+var lineProcs = map[string]bool{
+	"DeleteLine":          true,
+	"DeleteLines":         true,
+	"FormatNumbers":       true,
+	"PrefixLines":         true,
+	"RegexReplace":        true,
+	"Replace":             true,
+	"StripAnsi":           true,
+	"SuffixLines":         true,
+	"ToLower":             true,
+	"UpdateCopyrightYear": true,
+}
+
+func checkLineMode(tr Transformation) error {
+	for _, proc := range tr.Proc {
+		if !lineProcs[proc.Name] || len(proc.Proc) > 0 {
+			return fmt.Errorf("%s needs the whole file and cannot be used in line mode", proc.Name)
+		}
+	}
+	return nil
+}
+
+// streamLines copies r to w, applying the procedures of the transformations to
+// each line. The procedures receive the line without its terminator, which is
+// put back unless the line is removed by DeleteLine or DeleteLines. It tells
+// whether any line changed.
+func streamLines(r io.Reader, w io.Writer, fileName string, trs []Transformation) (bool, error) {
+	for _, tr := range trs {
+		if err := checkLineMode(tr); err != nil {
+			return false, err
+		}
+	}
+
+	changed := false
+	reader := bufio.NewReader(r)
+	writer := bufio.NewWriter(w)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return false, readErr
+		}
+		if len(line) == 0 {
+			break
+		}
+
+		content := bytes.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+		data, removed := make([]byte, len(content)), false
+		copy(data, content)
+		for i := 0; i < len(trs) && !removed; i++ {
+			// procedures are applied one at a time to stop once the line is removed
+			for _, proc := range trs[i].Proc {
+				var err error
+				single := Transformation{Proc: []Procedure{proc}}
+				if proc.Name == "DeleteLine" || proc.Name == "DeleteLines" {
+					// the line is given with a terminator to tell its removal
+					// apart from a line left empty by a previous procedure
+					var res []byte
+					if res, err = applyProcs(fileName, append(data, '\n'), single); err != nil {
+						return false, err
+					}
+					if len(res) == 0 {
+						removed = true
+						break
+					}
+					data = res[:len(res)-1]
+					continue
+				}
+				if data, err = applyProcs(fileName, data, single); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		if removed || !bytes.Equal(data, content) {
+			changed = true
+		}
+		if !removed {
+			writer.Write(data)
+			writer.Write(eol)
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return changed, writer.Flush()
+}
+
+// applyLines applies a transformation in line mode to data held in memory.
+func applyLines(fileName string, data []byte, tr Transformation) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := streamLines(bytes.NewReader(data), &buf, fileName, []Transformation{tr}); err != nil {
+		return data, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canStream tells whether the file is only transformed in line mode, without
+// preconditions needing its whole content. A file whose selection fails is not
+// streamed, so that the error is reported when it is processed.
+func canStream(filePath string, t T) bool {
+	if fixpoint {
+		return false
+	}
+	matched := false
+	for _, tr := range t.Transformations {
+		selected, err := checkFileName(filePath, tr)
+		if err != nil {
+			return false
+		}
+		if selected {
+			if tr.Mode != lineMode || len(tr.Pre) > 0 || len(tr.Cond) > 0 {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// streamFile transforms the file line by line into a temporary file, which
+// replaces the original only if a line changed. Binary files are left untouched
+// unless -include-binary is given.
+func streamFile(filePath string, t T) (bool, error) {
+	var trs []Transformation
+	for _, tr := range t.Transformations {
+		selected, err := checkFileName(filePath, tr)
+		if err != nil {
+			return false, err
+		}
+		if selected {
+			trs = append(trs, tr)
+		}
+	}
+
+	in, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return false, err
+	}
+	r := bufio.NewReaderSize(in, binarySniffLen)
+	if head, _ := r.Peek(binarySniffLen); !includeBinary && isBinary(head) {
+		if vverbose {
+			fmt.Fprintf(logOutput(), "Skip binary file %s\n", filePath)
+		}
+		return false, nil
+	}
+
+	return writeFileAtomic(filePath, info.Mode(), func(w io.Writer) (bool, error) {
+		return streamLines(r, w, filePath, trs)
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamLines(t *testing.T) {
+	tr := Transformation{Mode: lineMode, Proc: []Procedure{
+		Procedure{Name: "DeleteLine", Params: []string{"^# "}},
+		Procedure{Name: "Replace", Params: []string{"foo", ""}},
+	}}
+	var out bytes.Buffer
+	changed, err := streamLines(bytes.NewBufferString("a\r\n# comment\n\nfoo\nb"), &out, "", []Transformation{tr})
+	if err != nil || !changed || out.String() != "a\r\n\n\nb" {
+		t.Errorf("streamLines: %q was expected but found %q, %v, %v", "a\r\n\n\nb", out.String(), changed, err)
+	}
+
+	insert := Transformation{Mode: lineMode, Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"x"}}}}
+	if _, err = streamLines(bytes.NewBufferString("a\n"), &out, "", []Transformation{insert}); err == nil {
+		t.Error("streamLines should reject the procedures needing the whole file")
+	}
+}
+
+func TestStreamLinesKeepsBlankLines(t *testing.T) {
+	procs := []Procedure{
+		Procedure{Name: "Replace", Params: []string{"foo", "bar"}},
+		Procedure{Name: "DeleteLine", Params: []string{"^// DEBUG"}},
+	}
+	src := "a foo\n\n// DEBUG x\nb\n"
+	expected := "a bar\n\nb\n"
+
+	res, err := applyLines("", []byte(src), Transformation{Mode: lineMode, Proc: procs})
+	if err != nil || string(res) != expected {
+		t.Errorf("Line mode should only delete the matching lines: %q was expected but found %q, %v", expected, res, err)
+	}
+	buffered, err := applyProcs("", []byte(src), Transformation{Proc: procs})
+	if err != nil || string(buffered) != string(res) {
+		t.Errorf("Line mode should give the buffered output %q but found %q, %v", buffered, res, err)
+	}
+
+	blank := Transformation{Mode: lineMode, Proc: []Procedure{Procedure{Name: "DeleteLine", Params: []string{"^$"}}}}
+	if res, err = applyLines("", []byte(src), blank); err != nil || string(res) != "a foo\n// DEBUG x\nb\n" {
+		t.Errorf("DeleteLine should still remove the blank lines it matches but found %q, %v", res, err)
+	}
+}
+
+func TestStreamFileMatchesBuffered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-lines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&buf, "line %v\n", i)
+		if i%7 == 0 {
+			fmt.Fprintf(&buf, "    // DEBUG %v\n", i)
+		}
+	}
+	src := buf.Bytes()
+	path := filepath.Join(dir, "large.txt")
+	if err = ioutil.WriteFile(path, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deleteDebug := []Procedure{Procedure{Name: "DeleteLine", Params: []string{`^\s*// DEBUG`}}}
+	streamed := T{Transformations: []Transformation{Transformation{Filter: "*.txt", Mode: lineMode, Proc: deleteDebug}}}
+	buffered := T{Transformations: []Transformation{Transformation{Filter: "*.txt", Proc: deleteDebug}}}
+
+	if !canStream(path, streamed) || canStream(path, buffered) {
+		t.Fatal("Only the line mode transformation should be streamed")
+	}
+	_, expected, _, err := processFile(path, buffered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := streamFile(path, streamed)
+	if err != nil || !updated {
+		t.Fatalf("streamFile should update the file but found %v, %v", updated, err)
+	}
+	res, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(res, expected) {
+		t.Error("The streamed and buffered transformations should produce the same content")
+	}
+	if bytes.Contains(res, []byte("DEBUG")) || len(res) >= len(src) {
+		t.Error("The debug lines should be removed")
+	}
+
+	if updated, err = streamFile(path, streamed); updated || err != nil {
+		t.Errorf("An unchanged file should not be updated but found %v, %v", updated, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("The temporary files should be removed but found %v files", len(files))
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// useMacro is the name of the procedure replaced by the procedures of a macro.
+const useMacro = "UseMacro"
+
+// expandMacros replaces the UseMacro procedures of the transformations, nested
+// ones included, by the procedures of the named macro of the transformation file,
+// so that a chain of procedures can be shared without YAML anchors, whatever the
+// format. Macros can use other macros, but not themselves.
+//
+// macros:
+//   normalize:
+//     -
+//       name: StripAnsi
+//     -
+//       name: LimitBlankLines
+//       params: "1"
+// transformations:
+//   -
+//     proc:
+//       -
+//         name: UseMacro
+//         params: normalize
+func expandMacros(t T) (T, error) {
+	var expand func(procs []Procedure, using []string) ([]Procedure, error)
+	expand = func(procs []Procedure, using []string) ([]Procedure, error) {
+		var res []Procedure
+		for _, proc := range procs {
+			if proc.Name != useMacro {
+				nested, err := expand(proc.Proc, using)
+				if err != nil {
+					return nil, err
+				}
+				proc.Proc = nested
+				res = append(res, proc)
+				continue
+			}
+			if len(proc.Params) != 1 {
+				return nil, fmt.Errorf("%s expects the name of a macro but found %v params", useMacro, len(proc.Params))
+			}
+			name := proc.Params[0]
+			macro, ok := t.Macros[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown macro %q", name)
+			}
+			for _, used := range using {
+				if used == name {
+					return nil, fmt.Errorf("The macro %q uses itself: %s", name, strings.Join(append(using, name), " -> "))
+				}
+			}
+			expanded, err := expand(macro, append(using[:len(using):len(using)], name))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, expanded...)
+		}
+		return res, nil
+	}
+
+	res := t
+	res.Transformations = make([]Transformation, len(t.Transformations))
+	for i, tr := range t.Transformations {
+		procs, err := expand(tr.Proc, nil)
+		if err != nil {
+			return t, fmt.Errorf("%v in %s", err, ruleID(tr, i))
+		}
+		tr.Proc = procs
+		res.Transformations[i] = tr
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var macrosYml = `macros:
+  normalize:
+    - name: Replace
+      params: ["\t", "    "]
+    - name: UseMacro
+      params: [trim]
+  trim:
+    - name: LimitBlankLines
+      params: ["1"]
+transformations:
+  - name: go
+    filter: "*.go"
+    proc:
+      - name: UseMacro
+        params: [normalize]
+      - name: ToLower
+  - name: docs
+    filter: "*.md"
+    proc:
+      - name: InCodeFence
+        proc:
+          - name: UseMacro
+            params: [normalize]
+`
+
+var macrosJSON = `{
+  "macros": {"normalize": [{"name": "Replace", "params": ["\t", "    "]}, {"name": "UseMacro", "params": ["trim"]}],
+             "trim": [{"name": "LimitBlankLines", "params": ["1"]}]},
+  "transformations": [
+    {"name": "go", "filter": "*.go", "proc": [{"name": "UseMacro", "params": ["normalize"]}, {"name": "ToLower"}]},
+    {"name": "docs", "filter": "*.md", "proc": [{"name": "InCodeFence", "proc": [{"name": "UseMacro", "params": ["normalize"]}]}]}
+  ]
+}`
+
+func TestExpandMacros(t *testing.T) {
+	normalize := []Procedure{
+		Procedure{Name: "Replace", Params: []string{"\t", "    "}},
+		Procedure{Name: "LimitBlankLines", Params: []string{"1"}},
+	}
+	expected := []Transformation{
+		Transformation{Name: "go", Filter: "*.go", Proc: append(append([]Procedure{}, normalize...), Procedure{Name: "ToLower"})},
+		Transformation{Name: "docs", Filter: "*.md", Proc: []Procedure{Procedure{Name: "InCodeFence", Proc: normalize}}},
+	}
+	for format, dat := range map[string]string{"yml": macrosYml, "json": macrosJSON} {
+		tdf := mustParseTdf(t, []byte(dat), format)
+		if !reflect.DeepEqual(tdf.Transformations, expected) {
+			t.Errorf("The %s macros should be expanded in both transformations but found %+v", format, tdf.Transformations)
+		}
+	}
+
+	tdf := mustParseTdf(t, []byte(macrosYml), "yml")
+	res, err := applyProcs("main.go", []byte("A\tB\n\n\n\nC\n"), tdf.Transformations[0])
+	if err != nil || string(res) != "a    b\n\nc\n" {
+		t.Errorf("The expanded macro should be applied but found %q, %v", res, err)
+	}
+
+	for _, c := range []struct {
+		macros map[string][]Procedure
+		proc   Procedure
+		err    string
+	}{
+		{nil, Procedure{Name: "UseMacro", Params: []string{"missing"}}, `Unknown macro "missing" in rule`},
+		{nil, Procedure{Name: "UseMacro"}, "expects the name of a macro"},
+		{map[string][]Procedure{
+			"a": []Procedure{Procedure{Name: "UseMacro", Params: []string{"b"}}},
+			"b": []Procedure{Procedure{Name: "UseMacro", Params: []string{"a"}}},
+		}, Procedure{Name: "UseMacro", Params: []string{"a"}}, "a -> b -> a"},
+	} {
+		invalid := T{Macros: c.macros, Transformations: []Transformation{Transformation{Name: "rule", Proc: []Procedure{c.proc}}}}
+		if _, err := expandMacros(invalid); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expandMacros should report %q but found %v", c.err, err)
+		}
+	}
+}
+
+func TestMigrateKeepsMacros(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-macros")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tdf.yml")
+	if err = ioutil.WriteFile(path, []byte(macrosYml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	err = migrate(path)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, _ := ioutil.ReadFile(path)
+	migrated, err := decodeTdf(dat, "yml")
+	original, _ := decodeTdf([]byte(macrosYml), "yml")
+	if err != nil || migrated.Version != currentVersion || !reflect.DeepEqual(migrated.Macros, original.Macros) {
+		t.Errorf("migrate should keep the macros but found %v\n%s", err, dat)
+	}
+	if proc := migrated.Transformations[0].Proc[0]; proc.Name != "UseMacro" {
+		t.Errorf("migrate should not expand the UseMacro procedures but found %s\n%s", proc.Name, dat)
+	}
+}
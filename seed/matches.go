@@ -0,0 +1,138 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+)
+
+// patternProcs are the procedures whose first param is a regexp, listed by -list-matches.
+var patternProcs = map[string]bool{
+	"RegexReplace":  true,
+	"DeleteLine":    true,
+	"InsertAfter":   true,
+	"WithinCapture": true,
+	"TemplateMatch": true,
+	"PathTemplate":  true,
+	"Sequence":      true,
+	"FormatNumbers": true,
+}
+
+// literalProcs are the procedures whose first param is a literal string, listed by -list-matches.
+var literalProcs = map[string]bool{
+	"Replace": true,
+}
+
+// lineMatchProcs are the procedures matching their pattern against each line
+// without its terminator, whose matches are listed line by line.
+var lineMatchProcs = map[string]bool{
+	"DeleteLine":  true,
+	"DeleteLines": true,
+	"InsertAfter": true,
+}
+
+// substringProcs are the procedures whose first param is a regexp or a literal
+// substring, listed by -list-matches.
+var substringProcs = map[string]bool{
+	"DeleteLines": true,
+}
+
+// firstPatterns returns the pattern of the first procedure of each transformation,
+// nil for the transformations whose first procedure takes no pattern. The literal
+// strings of procedures like Replace are quoted to be matched as they are.
+func firstPatterns(t T) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, len(t.Transformations))
+	for i, tr := range t.Transformations {
+		if len(tr.Proc) == 0 || len(tr.Proc[0].Params) == 0 {
+			continue
+		}
+		proc := tr.Proc[0]
+		if !patternProcs[proc.Name] && !literalProcs[proc.Name] && !substringProcs[proc.Name] {
+			continue
+		}
+		pattern, err := resolveParam(proc.Params[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s of %s: %v", proc.Name, ruleID(tr, i), err)
+		}
+		if literalProcs[proc.Name] {
+			pattern = regexp.QuoteMeta(pattern)
+		} else if substringProcs[proc.Name] {
+			pattern = substringPattern(pattern)
+		}
+		if patterns[i], err = compileRegexp(pattern); err != nil {
+			return nil, fmt.Errorf("Invalid %s pattern in %s: %v", proc.Name, ruleID(tr, i), err)
+		}
+	}
+	return patterns, nil
+}
+
+// writeMatches prints the matches of the first procedure pattern of each transformation
+// in the files, like "grep -n", without editing them. A match is printed as
+// "path:line:col: transformation: text", the column counting the bytes from 1. Only the
+// files selected by the transformation and satisfying its preconditions are searched,
+// the binary files being skipped as when fixing. The patterns of the procedures like
+// DeleteLine are matched against each line without its terminator, as they are applied.
+// It returns the number of matches.
+func writeMatches(w io.Writer, files []string, t T) (int, error) {
+	patterns, err := firstPatterns(t)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, filePath := range files {
+		var data []byte
+		for i, tr := range t.Transformations {
+			if patterns[i] == nil {
+				continue
+			}
+			if selected, err := checkFileName(filePath, tr); err != nil {
+				return count, err
+			} else if !selected {
+				continue
+			}
+			if data == nil {
+				if data, err = ioutil.ReadFile(filePath); err != nil {
+					return count, fmt.Errorf("Error reading file %s: %v", filePath, err)
+				}
+				if !includeBinary && isBinary(data) {
+					break
+				}
+			}
+			if ok, err := checkCondition(filePath, data, tr); err != nil {
+				return count, err
+			} else if !ok {
+				continue
+			}
+			if lineMatchProcs[tr.Proc[0].Name] {
+				for n, line := range bytes.SplitAfter(data, []byte("\n")) {
+					if len(line) == 0 {
+						break
+					}
+					line = bytes.TrimRight(line, "\r\n")
+					for _, loc := range patterns[i].FindAllIndex(line, -1) {
+						fmt.Fprintf(w, "%s:%v:%v: %s: %q\n", shortPath(filePath), n+1, loc[0]+1, ruleID(tr, i), line[loc[0]:loc[1]])
+						count++
+					}
+				}
+				continue
+			}
+			for _, loc := range patterns[i].FindAllIndex(data, -1) {
+				line := bytes.Count(data[:loc[0]], []byte("\n")) + 1
+				col := loc[0] - bytes.LastIndexByte(data[:loc[0]], '\n')
+				fmt.Fprintf(w, "%s:%v:%v: %s: %q\n", shortPath(filePath), line, col, ruleID(tr, i), data[loc[0]:loc[1]])
+				count++
+			}
+		}
+	}
+	return count, nil
+}
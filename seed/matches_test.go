@@ -0,0 +1,69 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteMatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-matches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	mainGo := "package main\n\nimport \"github.com/old/log\"\n\nfunc main() { log.Print(\"old.Print\") }\n"
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(mainGo), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("See github.com/old/log.\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "skipped.go"), []byte("// github.com/old/log\n"), 0644)
+	files := []string{"README.md", "main.go", "skipped.go"}
+
+	tdf := T{Transformations: []Transformation{
+		Transformation{Name: "imports", Include: []string{"*.go"}, Cond: []Procedure{{Name: "ContainsString", Params: []string{"package"}}},
+			Proc: []Procedure{{Name: "RegexReplace", Params: []string{`github\.com/old/(\w+)`, "github.com/new/$1"}}}},
+		Transformation{Name: "calls", Include: []string{"*.go"},
+			Proc: []Procedure{{Name: "Replace", Params: []string{"old.Print", "new.Print"}}}},
+		Transformation{Name: "format", Include: []string{"*.md"}, Proc: []Procedure{{Name: "ToLower"}}},
+	}}
+	var out bytes.Buffer
+	count, err := writeMatches(&out, files, tdf)
+	expected := `main.go:3:9: imports: "github.com/old/log"
+main.go:5:26: calls: "old.Print"
+`
+	if count != 2 || err != nil || out.String() != expected {
+		t.Errorf("writeMatches: 2 matches were expected but found %v (%v):\n%s", count, err, out.String())
+	}
+	if dat, _ := ioutil.ReadFile("main.go"); string(dat) != mainGo {
+		t.Errorf("writeMatches should not edit the files but found:\n%s", dat)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("a\n# x\r\nb # y\n"), 0644)
+	lines := T{Transformations: []Transformation{
+		Transformation{Name: "comments", Include: []string{"*.txt"},
+			Proc: []Procedure{{Name: "DeleteLine", Params: []string{"^# .$"}}}},
+	}}
+	out.Reset()
+	count, err = writeMatches(&out, []string{"notes.txt"}, lines)
+	if expected = "notes.txt:2:1: comments: \"# x\"\n"; count != 1 || err != nil || out.String() != expected {
+		t.Errorf("writeMatches should match the lines of DeleteLine one by one but found %v (%v):\n%s", count, err, out.String())
+	}
+
+	tdf.Transformations[0].Proc[0].Params[0] = "(unclosed"
+	if _, err = writeMatches(&out, files, tdf); err == nil {
+		t.Error("writeMatches should report an invalid pattern")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// placeholderRegex matches the ${NAME} placeholders of Parameterize.
+var placeholderRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.]*)\}`)
+
+// placeholderNameRegex matches the valid names of the placeholders.
+var placeholderNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// propertiesFiles caches the values of the properties files used by Parameterize,
+// or the error of their read, so that each file is read once per run.
+var propertiesFiles = struct {
+	sync.Mutex
+	values map[string]map[string]string
+	errs   map[string]error
+}{values: make(map[string]map[string]string), errs: make(map[string]error)}
+
+// Parameterize replaces literal values with ${NAME} placeholders, or the reverse.
+// With "externalize", the params are pairs of a regexp and a placeholder name and
+// the matches of each regexp are replaced by the placeholder, e.g. to template the
+// URLs of sample configs. With "inline", the placeholders are replaced by their
+// value from the source param: "env" for the environment variables, or the path
+// of a properties file of key=value lines. A placeholder without value is kept,
+// or reported with -strict.
+//
+// proc:
+//  -
+//    name: Parameterize
+//    params:
+//      - externalize
+//      - "https?://[^\\s\"']+"
+//      - SERVICE_URL
+//  -
+//    name: Parameterize
+//    params:
+//      - inline
+//      - env
+func (p *Procedures) Parameterize(dat []byte, mode string, params ...string) ([]byte, error) {
+	switch mode {
+	case "externalize":
+		return externalize(dat, params)
+	case "inline":
+		if len(params) != 1 {
+			return dat, fmt.Errorf("inline expects the source of the values, env or a properties file")
+		}
+		return inline(dat, params[0])
+	}
+	return dat, fmt.Errorf("unknown mode %q, expected externalize or inline", mode)
+}
+
+// externalize replaces the matches of the regexps with their placeholder.
+func externalize(dat []byte, params []string) ([]byte, error) {
+	if len(params) == 0 || len(params)%2 != 0 {
+		return dat, fmt.Errorf("externalize expects pairs of a regexp and a placeholder name")
+	}
+	for i := 0; i < len(params); i += 2 {
+		re, err := regexp.Compile(params[i])
+		if err != nil {
+			return dat, err
+		}
+		name := params[i+1]
+		if !placeholderNameRegex.MatchString(name) {
+			return dat, fmt.Errorf("invalid placeholder name %q", name)
+		}
+		dat = re.ReplaceAllLiteral(dat, []byte("${"+name+"}"))
+	}
+	return dat, nil
+}
+
+// inline replaces the placeholders with their value from the source.
+func inline(dat []byte, source string) ([]byte, error) {
+	lookup := os.LookupEnv
+	if source != "env" {
+		values, err := readProperties(source)
+		if err != nil {
+			return dat, err
+		}
+		lookup = func(name string) (string, bool) {
+			value, ok := values[name]
+			return value, ok
+		}
+	}
+
+	var missing []string
+	res := placeholderRegex.ReplaceAllFunc(dat, func(placeholder []byte) []byte {
+		name := string(placeholder[2 : len(placeholder)-1])
+		value, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		return []byte(value)
+	})
+	if len(missing) > 0 {
+		if strict {
+			return dat, fmt.Errorf("no value for %s in %s", strings.Join(missing, ", "), source)
+		}
+		if vverbose {
+			fmt.Fprintf(logOutput(), "No value for %s in %s, keeping the placeholders\n", strings.Join(missing, ", "), source)
+		}
+	}
+	return res, nil
+}
+
+// readProperties returns the values of a properties file, made of key=value or
+// key: value lines. The blank lines and the comments starting with "#" or "!"
+// are ignored.
+func readProperties(path string) (map[string]string, error) {
+	propertiesFiles.Lock()
+	defer propertiesFiles.Unlock()
+	if values, ok := propertiesFiles.values[path]; ok {
+		return values, propertiesFiles.errs[path]
+	}
+
+	values := make(map[string]string)
+	dat, err := ioutil.ReadFile(path)
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(dat))
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+				continue
+			}
+			sep := strings.IndexAny(line, "=:")
+			if sep == -1 {
+				err = fmt.Errorf("%s:%v: expected key=value but found %q", path, n, line)
+				break
+			}
+			values[strings.TrimSpace(line[:sep])] = strings.TrimSpace(line[sep+1:])
+		}
+	}
+	propertiesFiles.values[path], propertiesFiles.errs[path] = values, err
+	return values, err
+}
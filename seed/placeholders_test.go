@@ -0,0 +1,77 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var inlinedConfig = `service:
+  url: "https://api.example.com/v1"
+  name: sample
+`
+
+var externalizedConfig = `service:
+  url: "${SERVICE_URL}"
+  name: sample
+`
+
+func TestParameterizeExternalize(t *testing.T) {
+	var p *Procedures
+	res, err := p.Parameterize([]byte(inlinedConfig), "externalize", `https?://[^\s"']+`, "SERVICE_URL")
+	if err != nil || string(res) != externalizedConfig {
+		t.Errorf("Parameterize should replace the URL with a placeholder but found %v:\n%s", err, res)
+	}
+
+	if _, err = p.Parameterize([]byte(inlinedConfig), "externalize", `https?://`); err == nil {
+		t.Error("Parameterize should require a placeholder name for each regexp")
+	}
+	if _, err = p.Parameterize([]byte(inlinedConfig), "externalize", `https?://`, "NOT VALID"); err == nil {
+		t.Error("Parameterize should reject an invalid placeholder name")
+	}
+	if _, err = p.Parameterize([]byte(inlinedConfig), "template"); err == nil {
+		t.Error("Parameterize should reject an unknown mode")
+	}
+}
+
+func TestParameterizeInline(t *testing.T) {
+	var p *Procedures
+	os.Setenv("SERVICE_URL", "https://api.example.com/v1")
+	defer os.Unsetenv("SERVICE_URL")
+	res, err := p.Parameterize([]byte(externalizedConfig), "inline", "env")
+	if err != nil || string(res) != inlinedConfig {
+		t.Errorf("Parameterize should inline the URL from the environment but found %v:\n%s", err, res)
+	}
+
+	dir, err := ioutil.TempDir("", "seed-props")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	props := filepath.Join(dir, "values.properties")
+	ioutil.WriteFile(props, []byte("# sample values\nSERVICE_URL = https://api.example.com/v1\n"), 0644)
+	res, err = p.Parameterize([]byte(externalizedConfig), "inline", props)
+	if err != nil || string(res) != inlinedConfig {
+		t.Errorf("Parameterize should inline the URL from the properties file but found %v:\n%s", err, res)
+	}
+
+	missing := []byte("url: ${OTHER_URL}\n")
+	res, err = p.Parameterize(missing, "inline", props)
+	if err != nil || string(res) != string(missing) {
+		t.Errorf("Parameterize should keep the placeholders without value but found %v:\n%s", err, res)
+	}
+	strict = true
+	defer func() { strict = false }()
+	if _, err = p.Parameterize(missing, "inline", props); err == nil {
+		t.Error("Parameterize should report the placeholders without value with -strict")
+	}
+}
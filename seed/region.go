@@ -0,0 +1,120 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// region is the [start, end) byte range of a part of the data.
+type region struct {
+	start, end int
+}
+
+// codeFences returns the content of the fenced code blocks of a markdown
+// document, i.e. the lines between the ``` or ~~~ fences, fences excluded.
+// A fence which is never closed runs to the end of the data.
+func codeFences(dat []byte) []region {
+	var regions []region
+	var fence string
+	start := 0
+	offset := 0
+	for _, line := range bytes.SplitAfter(dat, []byte("\n")) {
+		trimmed := strings.TrimSpace(string(line))
+		switch {
+		case fence == "" && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			fence = strings.Repeat(trimmed[:1], len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1])))
+			start = offset + len(line)
+		case fence != "" && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "":
+			regions = append(regions, region{start, offset})
+			fence = ""
+		}
+		offset += len(line)
+	}
+	if fence != "" {
+		regions = append(regions, region{start, len(dat)})
+	}
+	return regions
+}
+
+// outside returns the regions of the data of the given length which are not in regions.
+func outside(regions []region, length int) []region {
+	var res []region
+	last := 0
+	for _, r := range regions {
+		if r.start > last {
+			res = append(res, region{last, r.start})
+		}
+		last = r.end
+	}
+	if last < length {
+		res = append(res, region{last, length})
+	}
+	return res
+}
+
+// applyToRegions applies the procedures to each region separately and puts the results back in place.
+func (p *Procedures) applyToRegions(dat []byte, regions []region, procs []Procedure) ([]byte, error) {
+	var res []byte
+	last := 0
+	for _, r := range regions {
+		// Copy the region so procedures appending to it cannot overwrite the rest of the data
+		sub, err := p.apply(append([]byte(nil), dat[r.start:r.end]...), procs)
+		if err != nil {
+			return dat, err
+		}
+		res = append(res, dat[last:r.start]...)
+		res = append(res, sub...)
+		last = r.end
+	}
+	return append(res, dat[last:]...), nil
+}
+
+// InCodeFence applies the nested procedures only to the content of the fenced
+// code blocks of a markdown document.
+//
+// proc:
+//  -
+//    name: InCodeFence
+//    proc:
+//      -
+//        name: LimitBlankLines
+//        params: "1"
+func (p *Procedures) InCodeFence(dat []byte, procs []Procedure) ([]byte, error) {
+	return p.applyToRegions(dat, codeFences(dat), procs)
+}
+
+// OutsideCodeFence applies the nested procedures to a markdown document but
+// leaves the fenced code blocks, fences included, untouched.
+//
+// proc:
+//  -
+//    name: OutsideCodeFence
+//    proc:
+//      -
+//        name: AsciiPunctuation
+func (p *Procedures) OutsideCodeFence(dat []byte, procs []Procedure) ([]byte, error) {
+	var fences []region
+	for _, r := range codeFences(dat) {
+		fences = append(fences, fenceLines(dat, r))
+	}
+	return p.applyToRegions(dat, outside(fences, len(dat)), procs)
+}
+
+// fenceLines extends the region of a code block to its opening and closing fences.
+func fenceLines(dat []byte, r region) region {
+	// r starts after the newline of the opening fence
+	start := bytes.LastIndexByte(dat[:r.start-1], '\n')
+	end := len(dat)
+	if i := bytes.IndexByte(dat[r.end:], '\n'); i >= 0 {
+		end = r.end + i + 1
+	}
+	return region{start + 1, end}
+}
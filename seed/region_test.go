@@ -0,0 +1,58 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var fencedMd = "# Title\n\n\nSome prose.\n\n\n```go\nfunc a() {}\n\n\n\nfunc b() {}\n```\n\n\nEnd.\n"
+
+func TestCodeFences(t *testing.T) {
+	regions := codeFences([]byte(fencedMd))
+	if len(regions) != 1 {
+		t.Fatalf("codeFences: one region was expected but found %v", regions)
+	}
+	if code := fencedMd[regions[0].start:regions[0].end]; code != "func a() {}\n\n\n\nfunc b() {}\n" {
+		t.Errorf("codeFences: the region should only contain the code but found %q", code)
+	}
+
+	unclosed := "text\n~~~~\ncode\n```\nstill code\n"
+	regions = codeFences([]byte(unclosed))
+	if !reflect.DeepEqual(regions, []region{{10, len(unclosed)}}) {
+		t.Errorf("codeFences: an unclosed fence should run to the end but found %v", regions)
+	}
+}
+
+func TestLimitBlankLinesInCodeFence(t *testing.T) {
+	tr := Transformation{Proc: []Procedure{
+		Procedure{Name: "InCodeFence", Proc: []Procedure{Procedure{Name: "LimitBlankLines", Params: []string{"1"}}}},
+		Procedure{Name: "LimitBlankLines", Params: []string{"2"}},
+	}}
+	expected := "# Title\n\n\nSome prose.\n\n\n```go\nfunc a() {}\n\nfunc b() {}\n```\n\n\nEnd.\n"
+
+	res, err := applyProcs("", []byte(fencedMd), tr)
+	if err != nil || string(res) != expected {
+		t.Errorf("Only the blank lines of the code should be limited, expected %q but found %q, %v", expected, res, err)
+	}
+}
+
+func TestAsciiPunctuationOutsideCodeFence(t *testing.T) {
+	md := "It’s “fast”.\n```\nfmt.Println(“quoted”)\n```\nThe end — really.\n"
+	expected := "It's \"fast\".\n```\nfmt.Println(“quoted”)\n```\nThe end -- really.\n"
+	tr := Transformation{Proc: []Procedure{
+		Procedure{Name: "OutsideCodeFence", Proc: []Procedure{Procedure{Name: "AsciiPunctuation"}}},
+	}}
+
+	res, err := applyProcs("", []byte(md), tr)
+	if err != nil || string(res) != expected {
+		t.Errorf("The code blocks should be left untouched, expected %q but found %q, %v", expected, res, err)
+	}
+}
@@ -0,0 +1,473 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+)
+
+// change is a part of a file modified by a transformation. The lines are
+// those of the content received by the transformation.
+type change struct {
+	path      string
+	rule      string
+	startLine int
+	endLine   int
+}
+
+// firing is what a transformation did to a file, which matched its patterns and
+// preconditions. The changed lines are those of the content it received.
+type firing struct {
+	index     int
+	changed   bool
+	startLine int
+	endLine   int
+}
+
+// runReport collects what the transformations did to the files processed concurrently.
+type runReport struct {
+	mu      sync.Mutex
+	changes []change
+	matched []int
+	changed []int
+	diffs   []fileDiff
+	long    []longFile
+	files   []string
+}
+
+// fileDiff is the unified diff of a changed file.
+type fileDiff struct {
+	path string
+	diff string
+}
+
+func newRunReport(t T) *runReport {
+	n := len(t.Transformations)
+	return &runReport{matched: make([]int, n), changed: make([]int, n)}
+}
+
+// add records the firings of the transformations on a file. The files count once
+// per transformation, even when it fires several times in fixpoint mode.
+func (r *runReport) add(filePath string, t T, firings []firing) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, filePath)
+	matched := make(map[int]bool)
+	changed := make(map[int]bool)
+	for _, f := range firings {
+		if !matched[f.index] {
+			matched[f.index] = true
+			r.matched[f.index]++
+		}
+		if !f.changed {
+			continue
+		}
+		r.changes = append(r.changes, change{filePath, ruleID(t.Transformations[f.index], f.index), f.startLine, f.endLine})
+		if !changed[f.index] {
+			changed[f.index] = true
+			r.changed[f.index]++
+		}
+	}
+}
+
+func (r *runReport) addDiff(filePath, diff string) {
+	r.mu.Lock()
+	r.diffs = append(r.diffs, fileDiff{filePath, diff})
+	r.mu.Unlock()
+}
+
+// writeDiffs prints the diffs of the changed files sorted by path.
+func (r *runReport) writeDiffs(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	diffs := append([]fileDiff(nil), r.diffs...)
+	sort.Sort(byDiffPath(diffs))
+	for _, d := range diffs {
+		io.WriteString(w, d.diff)
+	}
+}
+
+// writeGroups prints the changed files grouped by transformation, in the order of
+// the transformations, followed by their diffs with withDiffs. A file changed by
+// several transformations is listed under each of them.
+func (r *runReport) writeGroups(w io.Writer, t T, withDiffs bool) {
+	changes := r.sortedChanges()
+	r.mu.Lock()
+	diffs := make(map[string]string)
+	for _, d := range r.diffs {
+		diffs[d.path] = d.diff
+	}
+	r.mu.Unlock()
+
+	for i, tr := range t.Transformations {
+		id := ruleID(tr, i)
+		var files []string
+		for _, c := range changes {
+			if c.rule == id && (len(files) == 0 || files[len(files)-1] != c.path) {
+				files = append(files, c.path)
+			}
+		}
+		if len(files) == 1 {
+			fmt.Fprintf(w, "%s: 1 file\n", transformationLabel(tr, i))
+		} else {
+			fmt.Fprintf(w, "%s: %v files\n", transformationLabel(tr, i), len(files))
+		}
+		for _, f := range files {
+			fmt.Fprintf(w, "    %s\n", shortPath(f))
+			if withDiffs {
+				io.WriteString(w, diffs[f])
+			}
+		}
+	}
+}
+
+// longFile is a file with more lines than the -warn-long limit.
+type longFile struct {
+	path  string
+	lines int
+}
+
+// checkLength records the file when it is longer than the -warn-long limit,
+// according to the LongerThan precondition. The file is read if dat is nil.
+func (r *runReport) checkLength(filePath string, dat []byte) error {
+	if dat == nil {
+		var err error
+		if dat, err = ioutil.ReadFile(filePath); err != nil {
+			return err
+		}
+	}
+	var c *Conditions
+	if long, err := c.check(filePath, dat, Procedure{Name: "LongerThan", Params: []string{strconv.Itoa(warnLong)}}); !long || err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.long = append(r.long, longFile{filePath, lineCount(dat)})
+	r.mu.Unlock()
+	return nil
+}
+
+// longFiles returns the files longer than the -warn-long limit sorted by path.
+func (r *runReport) longFiles() []longFile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := append([]longFile(nil), r.long...)
+	sort.Sort(byLongPath(files))
+	return files
+}
+
+// writeLongFiles prints the files longer than the -warn-long limit.
+func (r *runReport) writeLongFiles(w io.Writer) {
+	files := r.longFiles()
+	if len(files) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nFiles longer than %v lines:\n", warnLong)
+	for _, f := range files {
+		fmt.Fprintf(w, "    %s (%v lines)\n", shortPath(f.path), f.lines)
+	}
+}
+
+type byLongPath []longFile
+
+func (b byLongPath) Len() int           { return len(b) }
+func (b byLongPath) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byLongPath) Less(i, j int) bool { return b[i].path < b[j].path }
+
+type byDiffPath []fileDiff
+
+func (b byDiffPath) Len() int           { return len(b) }
+func (b byDiffPath) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDiffPath) Less(i, j int) bool { return b[i].path < b[j].path }
+
+// sortedChanges returns the collected changes sorted by file path, keeping the
+// order of the transformations for each file.
+func (r *runReport) sortedChanges() []change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := append([]change(nil), r.changes...)
+	sort.Stable(byChangePath(changes))
+	return changes
+}
+
+type byChangePath []change
+
+func (b byChangePath) Len() int           { return len(b) }
+func (b byChangePath) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byChangePath) Less(i, j int) bool { return b[i].path < b[j].path }
+
+// ruleID identifies a transformation in the reports, by its name if it has one.
+func ruleID(tr Transformation, i int) string {
+	if tr.Name != "" {
+		return tr.Name
+	}
+	return fmt.Sprintf("transformation-%v", i+1)
+}
+
+// writeSummary prints the number of files matched and changed by each
+// transformation, pointing out the ones which had no effect.
+func (r *runReport) writeSummary(w io.Writer, t T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Transformation\tMatched\tChanged\t")
+	for i, tr := range t.Transformations {
+		note := ""
+		if r.changed[i] == 0 {
+			note = "no effect"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%s\n", transformationLabel(tr, i), r.matched[i], r.changed[i], note)
+	}
+	tw.Flush()
+}
+
+// changedLines returns the first and last lines of before which differ in after,
+// starting from 1. Lines only added are reported on the line following them.
+func changedLines(before, after []byte) (int, int) {
+	a := bytes.SplitAfter(before, []byte("\n"))
+	b := bytes.SplitAfter(after, []byte("\n"))
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && bytes.Equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && bytes.Equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+
+	start, end := prefix+1, len(a)-suffix
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+// sarifReport returns the changes as a SARIF log, with a rule per transformation.
+func sarifReport(changes []change, t T) ([]byte, error) {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "seed",
+			InformationURI: "https://github.com/seedstack/tools",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	for i, tr := range t.Transformations {
+		id := ruleID(tr, i)
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{id, sarifMessage{"Transformation " + id}})
+	}
+	for _, c := range changes {
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  c.rule,
+			Level:   "warning",
+			Message: sarifMessage{fmt.Sprintf("%s would change this file", c.rule)},
+			Locations: []sarifLocation{sarifLocation{sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{filepath.ToSlash(shortPath(c.path))},
+				Region:           sarifRegion{c.startLine, c.endLine},
+			}}},
+		})
+	}
+
+	res, err := json.MarshalIndent(sarifLog{"2.1.0", sarifSchema, []sarifRun{run}}, "", "  ")
+	return append(res, '\n'), err
+}
+
+type jsonReport struct {
+	Changes         []jsonChange         `json:"changes"`
+	Transformations []jsonTransformation `json:"transformations"`
+	LongFiles       []jsonLongFile       `json:"longFiles,omitempty"`
+}
+
+type jsonLongFile struct {
+	Path  string `json:"path"`
+	Lines int    `json:"lines"`
+}
+
+type jsonChange struct {
+	Path           string `json:"path"`
+	Transformation string `json:"transformation"`
+	StartLine      int    `json:"startLine"`
+	EndLine        int    `json:"endLine"`
+}
+
+type jsonTransformation struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name,omitempty"`
+	Matched int    `json:"matched"`
+	Changed int    `json:"changed"`
+}
+
+// jsonReport returns the changes and the number of files matched and changed by
+// each transformation as JSON, with the files longer than the -warn-long limit.
+func (r *runReport) jsonReport(t T) ([]byte, error) {
+	report := jsonReport{Changes: []jsonChange{}, Transformations: []jsonTransformation{}}
+	for _, c := range r.sortedChanges() {
+		report.Changes = append(report.Changes, jsonChange{filepath.ToSlash(shortPath(c.path)), c.rule, c.startLine, c.endLine})
+	}
+	r.mu.Lock()
+	for i, tr := range t.Transformations {
+		report.Transformations = append(report.Transformations, jsonTransformation{i + 1, tr.Name, r.matched[i], r.changed[i]})
+	}
+	r.mu.Unlock()
+	for _, f := range r.longFiles() {
+		report.LongFiles = append(report.LongFiles, jsonLongFile{filepath.ToSlash(shortPath(f.path)), f.lines})
+	}
+
+	res, err := json.MarshalIndent(report, "", "  ")
+	return append(res, '\n'), err
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport returns the processed files as JUnit test cases, for the CI test
+// panels. A changed file gives a failing case per transformation changing it,
+// named by the transformation, with the changed lines. An unchanged file gives
+// a passing case named "unchanged". The cases are sorted by file path.
+func (r *runReport) junitReport() ([]byte, error) {
+	changes := r.sortedChanges()
+	r.mu.Lock()
+	files := append([]string(nil), r.files...)
+	r.mu.Unlock()
+	sort.Strings(files)
+
+	suite := junitTestSuite{Name: "seed", Cases: []junitTestCase{}}
+	for _, f := range files {
+		path := filepath.ToSlash(shortPath(f))
+		var cases []junitTestCase
+		byRule := make(map[string]*junitFailure)
+		for _, c := range changes {
+			if c.path != f {
+				continue
+			}
+			lines := fmt.Sprintf("lines %v-%v", c.startLine, c.endLine)
+			if failure, ok := byRule[c.rule]; ok {
+				failure.Text += "\n" + lines
+				continue
+			}
+			failure := &junitFailure{fmt.Sprintf("%s would change this file", c.rule), lines}
+			byRule[c.rule] = failure
+			cases = append(cases, junitTestCase{path, c.rule, failure})
+		}
+		if len(cases) == 0 {
+			cases = append(cases, junitTestCase{path, "unchanged", nil})
+		} else {
+			suite.Failures += len(cases)
+		}
+		suite.Cases = append(suite.Cases, cases...)
+	}
+	suite.Tests = len(suite.Cases)
+
+	res, err := xml.MarshalIndent(junitTestSuites{Name: "seed", Tests: suite.Tests, Failures: suite.Failures,
+		Suites: []junitTestSuite{suite}}, "", "  ")
+	return append(append([]byte(xml.Header), res...), '\n'), err
+}
+
+// writeReport returns the report in the given format.
+func (r *runReport) writeReport(format string, t T) ([]byte, error) {
+	switch format {
+	case "sarif":
+		return sarifReport(r.sortedChanges(), t)
+	case "json":
+		return r.jsonReport(t)
+	case "junit":
+		return r.junitReport()
+	}
+	return nil, fmt.Errorf("%s report format unsupported", format)
+}
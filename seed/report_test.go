@@ -0,0 +1,359 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChangedLines(t *testing.T) {
+	cases := []struct {
+		before, after string
+		start, end    int
+	}{
+		{"a\nb\nc\n", "a\nB\nc\n", 2, 2},
+		{"a\nb\nc\n", "A\nb\nC\n", 1, 3},
+		{"a\nb\n", "a\nx\nb\n", 2, 2},
+		{"a\nb\n", "a\nb\nc\n", 3, 3},
+		{"a\nb\nc\n", "a\nc\n", 2, 2},
+	}
+	for _, c := range cases {
+		if start, end := changedLines([]byte(c.before), []byte(c.after)); start != c.start || end != c.end {
+			t.Errorf("changedLines(%q, %q): %v-%v was expected but found %v-%v", c.before, c.after, c.start, c.end, start, end)
+		}
+	}
+}
+
+func TestSarifReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	changed := filepath.Join(dir, "changed.txt")
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	ioutil.WriteFile(changed, []byte("one\nold\nthree\n"), 0644)
+	ioutil.WriteFile(unchanged, []byte("nothing to do\n"), 0644)
+
+	tdf := T{Transformations: []Transformation{
+		Transformation{Name: "rename", Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+		Transformation{Filter: "*.go", Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"x"}}}},
+	}}
+
+	check = true
+	defer func() { check = false }()
+	report := newRunReport(tdf)
+	count, err := processFiles(context.Background(), []string{changed, unchanged}, tdf, report)
+	if count != 1 || err != nil {
+		t.Fatalf("processFiles: 1 file should be reported but found %v (%v)", count, err)
+	}
+	if dat, _ := ioutil.ReadFile(changed); string(dat) != "one\nold\nthree\n" {
+		t.Errorf("The files should not be written in check mode but found %q", dat)
+	}
+
+	res, err := report.writeReport("sarif", tdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sarif struct {
+		Version string
+		Schema  string `json:"$schema"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				Level     string
+				Message   struct{ Text string }
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, EndLine int }
+					}
+				}
+			}
+		}
+	}
+	if err = json.Unmarshal(res, &sarif); err != nil {
+		t.Fatalf("The report should be valid JSON: %v\n%s", err, res)
+	}
+
+	if sarif.Version != "2.1.0" || sarif.Schema != sarifSchema || len(sarif.Runs) != 1 {
+		t.Fatalf("Unexpected SARIF log:\n%s", res)
+	}
+	run := sarif.Runs[0]
+	if run.Tool.Driver.Name != "seed" || len(run.Tool.Driver.Rules) != 2 ||
+		run.Tool.Driver.Rules[0].ID != "rename" || run.Tool.Driver.Rules[1].ID != "transformation-2" {
+		t.Errorf("Each transformation should be a rule:\n%s", res)
+	}
+	if len(run.Results) != 1 {
+		t.Fatalf("Only the changed file should be reported:\n%s", res)
+	}
+	result := run.Results[0]
+	if result.RuleID != "rename" || result.Level != "warning" || result.Message.Text == "" || len(result.Locations) != 1 {
+		t.Fatalf("Unexpected SARIF result:\n%s", res)
+	}
+	loc := result.Locations[0].PhysicalLocation
+	if filepath.Base(loc.ArtifactLocation.URI) != "changed.txt" || loc.Region.StartLine != 2 || loc.Region.EndLine != 2 {
+		t.Errorf("The result should locate the line 2 of changed.txt:\n%s", res)
+	}
+
+	if _, err = report.writeReport("other", tdf); err == nil {
+		t.Error("writeReport should reject an unknown format")
+	}
+}
+
+func TestTransformationsSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for _, name := range []string{"a.txt", "b.txt", "c.md"} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte("old\n"), 0644)
+		files = append(files, path)
+	}
+
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	tdf := T{Transformations: []Transformation{
+		Transformation{Name: "rename", Filter: "*.txt", Proc: replace},
+		Transformation{Name: "noop", Filter: "*", Proc: []Procedure{Procedure{Name: "ToLower"}}},
+		Transformation{Filter: "*.java", Proc: replace},
+	}}
+	report := newRunReport(tdf)
+	if _, err = processFiles(context.Background(), files, tdf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	report.writeSummary(&buf, tdf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := [][]string{
+		{"Transformation", "Matched", "Changed"},
+		{`"rename"`, "2", "2"},
+		{`"noop"`, "3", "0", "no", "effect"},
+		{"#3", "0", "0", "no", "effect"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("The summary should have a line per transformation but found:\n%s", buf.String())
+	}
+	for i, line := range lines {
+		if fields := strings.Fields(line); strings.Join(fields, " ") != strings.Join(expected[i], " ") {
+			t.Errorf("Summary line %v: %q was expected but found %q", i, expected[i], fields)
+		}
+	}
+
+	res, err := report.writeReport("json", tdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Changes         []struct{ Path, Transformation string }
+		Transformations []struct {
+			Index            int
+			Name             string
+			Matched, Changed int
+		}
+	}
+	if err = json.Unmarshal(res, &parsed); err != nil {
+		t.Fatalf("The report should be valid JSON: %v\n%s", err, res)
+	}
+	if len(parsed.Changes) != 2 || parsed.Changes[0].Transformation != "rename" {
+		t.Errorf("The JSON report should list the changes of the txt files:\n%s", res)
+	}
+	if len(parsed.Transformations) != 3 || parsed.Transformations[2].Index != 3 ||
+		parsed.Transformations[2].Matched != 0 || parsed.Transformations[2].Changed != 0 {
+		t.Errorf("The never matching transformation should show zero:\n%s", res)
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	both := filepath.Join(dir, "both.txt")
+	renamed := filepath.Join(dir, "renamed.txt")
+	unchanged := filepath.Join(dir, "unchanged.go")
+	ioutil.WriteFile(both, []byte("old\nkept\nold & <tag>\n"), 0644)
+	ioutil.WriteFile(renamed, []byte("kept\nold\n"), 0644)
+	ioutil.WriteFile(unchanged, []byte("package main\n"), 0644)
+	tdf := T{Transformations: []Transformation{
+		Transformation{Name: "rename", Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+		Transformation{Filter: "both.txt", Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"x\n"}}}},
+	}}
+
+	check = true
+	defer func() { check = false }()
+	report := newRunReport(tdf)
+	if count, err := processFiles(context.Background(), []string{both, renamed, unchanged}, tdf, report); count != 2 || err != nil {
+		t.Fatalf("processFiles: 2 files should be reported but found %v (%v)", count, err)
+	}
+	res, err := report.writeReport("junit", tdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var junit struct {
+		XMLName  xml.Name `xml:"testsuites"`
+		Tests    int      `xml:"tests,attr"`
+		Failures int      `xml:"failures,attr"`
+		Suites   []struct {
+			Tests    int `xml:"tests,attr"`
+			Failures int `xml:"failures,attr"`
+			Cases    []struct {
+				ClassName string `xml:"classname,attr"`
+				Name      string `xml:"name,attr"`
+				Failure   *struct {
+					Message string `xml:"message,attr"`
+					Text    string `xml:",chardata"`
+				} `xml:"failure"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err = xml.Unmarshal(res, &junit); err != nil {
+		t.Fatalf("The report should be valid XML: %v\n%s", err, res)
+	}
+	if junit.Tests != 4 || junit.Failures != 3 || len(junit.Suites) != 1 ||
+		junit.Suites[0].Tests != 4 || junit.Suites[0].Failures != 3 || len(junit.Suites[0].Cases) != 4 {
+		t.Fatalf("4 test cases with 3 failures were expected:\n%s", res)
+	}
+	expected := []struct{ path, name, failure string }{
+		{both, "rename", "lines 1-3"},
+		{both, "transformation-2", "lines 4-4"},
+		{renamed, "rename", "lines 2-2"},
+		{unchanged, "unchanged", ""},
+	}
+	for i, c := range junit.Suites[0].Cases {
+		e := expected[i]
+		failure := ""
+		if c.Failure != nil {
+			failure = c.Failure.Text
+			if c.Failure.Message != e.name+" would change this file" {
+				t.Errorf("Unexpected failure message %q", c.Failure.Message)
+			}
+		}
+		if c.ClassName != filepath.ToSlash(shortPath(e.path)) || c.Name != e.name || failure != e.failure {
+			t.Errorf("Test case %v: %+v was expected but found %s/%s %q", i, e, c.ClassName, c.Name, failure)
+		}
+	}
+}
+
+func TestGroupByTransformation(t *testing.T) {
+	tdf := T{Transformations: []Transformation{
+		Transformation{Name: "rename"},
+		Transformation{},
+		Transformation{Name: "unused"},
+	}}
+	report := newRunReport(tdf)
+	report.add("b.go", tdf, []firing{firing{0, true, 1, 1}, firing{1, true, 2, 2}, firing{2, false, 0, 0}})
+	report.add("a.go", tdf, []firing{firing{0, true, 3, 3}, firing{0, true, 4, 4}})
+	report.add("c.go", tdf, []firing{firing{1, false, 0, 0}})
+	report.addDiff("a.go", "--- a/a.go\n")
+	report.addDiff("b.go", "--- a/b.go\n")
+
+	var buf bytes.Buffer
+	report.writeGroups(&buf, tdf, false)
+	expected := `"rename": 2 files
+    a.go
+    b.go
+#2: 1 file
+    b.go
+"unused": 0 files
+`
+	if buf.String() != expected {
+		t.Errorf("writeGroups: expected\n%s\nbut found\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	report.writeGroups(&buf, tdf, true)
+	expected = `"rename": 2 files
+    a.go
+--- a/a.go
+    b.go
+--- a/b.go
+#2: 1 file
+    b.go
+--- a/b.go
+"unused": 0 files
+`
+	if buf.String() != expected {
+		t.Errorf("writeGroups: expected\n%s\nbut found\n%s", expected, buf.String())
+	}
+}
+
+func TestWarnLong(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	long := filepath.Join(dir, "long.go")
+	unmatched := filepath.Join(dir, "long.txt")
+	short := filepath.Join(dir, "short.go")
+	ioutil.WriteFile(long, []byte("a\nb\nc\nd"), 0644)
+	ioutil.WriteFile(unmatched, []byte("a\nb\nc\nd\ne\n"), 0644)
+	ioutil.WriteFile(short, []byte("a\nb\nc\n"), 0644)
+
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.go", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"a", "b"}}}},
+	}}
+	warnLong = 3
+	defer func() { warnLong = 0 }()
+	check = true
+	defer func() { check = false }()
+	report := newRunReport(tdf)
+	if _, err = processFiles(context.Background(), []string{short, unmatched, long}, tdf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	files := report.longFiles()
+	if len(files) != 2 || files[0].path != long || files[0].lines != 4 || files[1].path != unmatched || files[1].lines != 5 {
+		t.Errorf("Only the files longer than 3 lines should be reported but found %v", files)
+	}
+	if dat, _ := ioutil.ReadFile(long); string(dat) != "a\nb\nc\nd" {
+		t.Errorf("The long files should not be modified in check mode but found %q", dat)
+	}
+
+	res, err := report.writeReport("json", tdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		LongFiles []struct {
+			Path  string
+			Lines int
+		}
+	}
+	if err = json.Unmarshal(res, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.LongFiles) != 2 || !strings.HasSuffix(parsed.LongFiles[0].Path, "long.go") || parsed.LongFiles[0].Lines != 4 {
+		t.Errorf("The JSON report should list the long files but found:\n%s", res)
+	}
+}
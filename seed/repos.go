@@ -0,0 +1,150 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// repoResult is the outcome of the transformations on one repository of a -repos run.
+type repoResult struct {
+	root         string
+	count, total int
+	elapsed      time.Duration
+	err          error
+}
+
+// readRepos returns the absolute paths of the repositories listed in the file, one
+// per line. Blank lines and lines starting with "#" are ignored, and relative paths
+// are resolved from the directory of the file.
+func readRepos(path string) ([]string, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		root, err := filepath.Abs(line)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no repository listed in %s", path)
+	}
+	return roots, nil
+}
+
+// fixRepos applies the transformations to each repository in turn. The failure of
+// a repository, e.g. outside of a git working tree, is reported in its result and
+// does not prevent the next ones from being fixed. After an interruption, the
+// remaining repositories are left untouched.
+func fixRepos(ctx context.Context, roots []string, t T, tdfPath string, report *runReport) []repoResult {
+	var results []repoResult
+	for _, root := range roots {
+		res := fixRepo(ctx, root, t, tdfPath, report)
+		results = append(results, res)
+		if _, ok := res.err.(interruptedError); ok {
+			break
+		}
+	}
+	return results
+}
+
+// fixRepo applies the transformations to the files of the repository.
+func fixRepo(ctx context.Context, root string, t T, tdfPath string, report *runReport) repoResult {
+	start := time.Now()
+	res := repoResult{root: root}
+	if info, err := os.Stat(root); err != nil {
+		res.err = err
+		return res
+	} else if !info.IsDir() {
+		res.err = fmt.Errorf("%s is not a directory", root)
+		return res
+	}
+	if !noRequireGit {
+		if res.err = checkGitWorkTree(root); res.err != nil {
+			return res
+		}
+	}
+
+	// The preconditions looking at the repository, like RepoHasFile, use dirPath
+	dirPath = root
+	files, err := walkDir(root, t.Exclude, tdfSkipPath(tdfPath))
+	if err == nil {
+		files, err = keepGitRange(root, files)
+	}
+	if err != nil {
+		res.err = err
+		return res
+	}
+	res.total = len(files)
+	res.count, res.err = processFiles(ctx, files, t, report)
+	res.elapsed = time.Since(start)
+	return res
+}
+
+// writeRepoResults prints the number of fixed files of each repository, followed by
+// its errors if any, and the total over all the repositories.
+func writeRepoResults(w io.Writer, results []repoResult, action string) {
+	var count, total, failed int
+	var elapsed time.Duration
+	fmt.Fprintln(w)
+	for _, res := range results {
+		fmt.Fprintf(w, "%s %s %v/%v files in %s\n", shortPath(res.root), action, res.count, res.total, res.elapsed)
+		if res.err != nil {
+			failed++
+			for _, line := range strings.Split(res.err.Error(), "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+		count += res.count
+		total += res.total
+		elapsed += res.elapsed
+	}
+	fmt.Fprintf(w, "\nTotal: %s %v/%v files in %v repositories, %v failed, in %s\n", action, count, total, len(results), failed, elapsed)
+}
+
+// reposError returns the error ending a -repos run: the interruption, if any, or
+// the number of failed repositories, their errors being printed with their results.
+func reposError(results []repoResult) error {
+	failed := 0
+	for _, res := range results {
+		if _, ok := res.err.(interruptedError); ok {
+			return res.err
+		}
+		if res.err != nil {
+			failed++
+		}
+	}
+	if failed == 1 {
+		return fmt.Errorf("1 repository failed")
+	} else if failed > 0 {
+		return fmt.Errorf("%v repositories failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRepos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	list := filepath.Join(dir, "repos.txt")
+	ioutil.WriteFile(list, []byte("# services\nservice-a\n\n  /abs/service-b  \n"), 0644)
+
+	roots, err := readRepos(list)
+	expected := []string{filepath.Join(dir, "service-a"), "/abs/service-b"}
+	if err != nil || !reflect.DeepEqual(roots, expected) {
+		t.Errorf("readRepos: %v was expected but found %v (%v)", expected, roots, err)
+	}
+
+	ioutil.WriteFile(list, []byte("# nothing\n"), 0644)
+	if _, err = readRepos(list); err == nil {
+		t.Error("readRepos should fail when no repository is listed")
+	}
+}
+
+func TestFixRepos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repoA, repoB := filepath.Join(dir, "service-a"), filepath.Join(dir, "service-b")
+	os.Mkdir(repoA, 0755)
+	os.Mkdir(repoB, 0755)
+	ioutil.WriteFile(filepath.Join(repoA, "a.txt"), []byte("old\n"), 0644)
+	ioutil.WriteFile(filepath.Join(repoA, "b.txt"), []byte("old\n"), 0644)
+	ioutil.WriteFile(filepath.Join(repoB, "c.txt"), []byte("old\n"), 0644)
+	ioutil.WriteFile(filepath.Join(repoB, "d.txt"), []byte("kept\n"), 0644)
+	missing := filepath.Join(dir, "missing")
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+	}}
+
+	defer func(dir string, noGit bool) { dirPath, noRequireGit = dir, noGit }(dirPath, noRequireGit)
+	noRequireGit = true
+	results := fixRepos(context.Background(), []string{repoA, missing, repoB}, tdf, "", nil)
+
+	if len(results) != 3 {
+		t.Fatalf("fixRepos should return the result of each repository but found %v", results)
+	}
+	if res := results[0]; res.count != 2 || res.total != 2 || res.err != nil {
+		t.Errorf("service-a: 2/2 files should be fixed but found %v/%v (%v)", res.count, res.total, res.err)
+	}
+	if res := results[1]; res.err == nil {
+		t.Error("The missing repository should be reported as failed")
+	}
+	if res := results[2]; res.count != 1 || res.total != 2 || res.err != nil {
+		t.Errorf("service-b: 1/2 files should be fixed but found %v/%v (%v)", res.count, res.total, res.err)
+	}
+	if dat, _ := ioutil.ReadFile(filepath.Join(repoB, "c.txt")); string(dat) != "new\n" {
+		t.Errorf("The repository after the failed one should be fixed but found %q", dat)
+	}
+
+	var buf bytes.Buffer
+	writeRepoResults(&buf, results, "fixed")
+	for _, expected := range []string{
+		shortPath(repoA) + " fixed 2/2 files in ",
+		shortPath(missing) + " fixed 0/0 files in ",
+		shortPath(repoB) + " fixed 1/2 files in ",
+		"Total: fixed 3/4 files in 3 repositories, 1 failed, in ",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("The results should contain %q but found:\n%s", expected, buf.String())
+		}
+	}
+	if err := reposError(results); err == nil || err.Error() != "1 repository failed" {
+		t.Errorf("reposError should report the failed repository but found %v", err)
+	}
+}
+
+func TestFixReposUnwalkable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	broken, repo := filepath.Join(dir, "broken"), filepath.Join(dir, "service")
+	// The .gitignore of the broken repository cannot be read
+	os.MkdirAll(filepath.Join(broken, "sub", ".gitignore"), 0755)
+	ioutil.WriteFile(filepath.Join(broken, "a.txt"), []byte("old\n"), 0644)
+	os.Mkdir(repo, 0755)
+	ioutil.WriteFile(filepath.Join(repo, "b.txt"), []byte("old\n"), 0644)
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+	}}
+
+	defer func(dir string, noGit, ignore bool) {
+		dirPath, noRequireGit, gitignore = dir, noGit, ignore
+	}(dirPath, noRequireGit, gitignore)
+	noRequireGit, gitignore = true, true
+	results := fixRepos(context.Background(), []string{broken, repo}, tdf, "", nil)
+
+	if len(results) != 2 || results[0].err == nil {
+		t.Fatalf("The repository which cannot be walked should be reported as failed but found %v", results)
+	}
+	if dat, _ := ioutil.ReadFile(filepath.Join(broken, "a.txt")); string(dat) != "old\n" {
+		t.Errorf("The repository which cannot be walked should be left untouched but found %q", dat)
+	}
+	if res := results[1]; res.count != 1 || res.err != nil {
+		t.Errorf("The next repository should be fixed but found %v files (%v)", res.count, res.err)
+	}
+}
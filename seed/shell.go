@@ -0,0 +1,91 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// heredocRegex matches the here-document operators of a shell line, with their
+// optional dash and their delimiter, possibly quoted. Here-strings are not matched.
+var heredocRegex = regexp.MustCompile(`(?:^|[^<])<<(-?)[ \t]*(?:'([^']+)'|"([^"]+)"|\\?([A-Za-z_][A-Za-z0-9_]*))`)
+
+// heredoc is a here-document whose body is not terminated yet.
+type heredoc struct {
+	dash  bool
+	delim string
+}
+
+// FixHeredocIndent rewrites the indentation of the bodies and terminators of the
+// <<- here-documents of a shell script with tabs, the only indentation removed
+// by the shell, using the given tab width (4 by default) for the spaces. The
+// spaces left after the last tab of a body line are kept, those of a terminator
+// are removed for the shell to recognize it. The << here-documents are left
+// untouched.
+//
+// proc:
+//  -
+//    name: FixHeredocIndent
+//    params: "4"
+func (p *Procedures) FixHeredocIndent(dat []byte, width ...string) ([]byte, error) {
+	n := 4
+	if len(width) > 0 {
+		var err error
+		if n, err = strconv.Atoi(width[0]); err != nil || n < 1 {
+			return dat, fmt.Errorf("invalid tab width: %s", width[0])
+		}
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	var pending []heredoc
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		if len(pending) == 0 {
+			if !strings.HasPrefix(strings.TrimSpace(content), "#") {
+				for _, m := range heredocRegex.FindAllStringSubmatch(content, -1) {
+					pending = append(pending, heredoc{m[1] == "-", m[2] + m[3] + m[4]})
+				}
+			}
+			continue
+		}
+
+		doc := pending[0]
+		if !doc.dash {
+			if content == doc.delim {
+				pending = pending[1:]
+			}
+			continue
+		}
+		body := strings.TrimLeft(content, " \t")
+		tabs, spaces := tabIndent(content[:len(content)-len(body)], n)
+		if body == doc.delim {
+			spaces = ""
+			pending = pending[1:]
+		}
+		lines[i] = tabs + spaces + body + line[len(content):]
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// tabIndent returns the tabs of the same width as the indentation, followed by
+// the spaces left.
+func tabIndent(indent string, width int) (string, string) {
+	col := 0
+	for _, c := range indent {
+		if c == '\t' {
+			col = (col/width + 1) * width
+		} else {
+			col++
+		}
+	}
+	return strings.Repeat("\t", col/width), strings.Repeat(" ", col%width)
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import "testing"
+
+func TestFixHeredocIndent(t *testing.T) {
+	var p *Procedures
+	script := "if true; then\n" +
+		"    cat <<-EOF\n" +
+		"\t    first\n" +
+		"        second\n" +
+		"\t      aligned\n" +
+		"    EOF\n" +
+		"    cat <<EOF\n" +
+		"    kept\n" +
+		"EOF\n" +
+		"    read x <<< \"$y\"\n" +
+		"    # cat <<-NOT\n" +
+		"    cat <<- 'END'\n" +
+		"  \tthird\r\n" +
+		"\t  END\n" +
+		"fi\n"
+	expected := "if true; then\n" +
+		"    cat <<-EOF\n" +
+		"\t\tfirst\n" +
+		"\t\tsecond\n" +
+		"\t\t  aligned\n" +
+		"\tEOF\n" +
+		"    cat <<EOF\n" +
+		"    kept\n" +
+		"EOF\n" +
+		"    read x <<< \"$y\"\n" +
+		"    # cat <<-NOT\n" +
+		"    cat <<- 'END'\n" +
+		"\tthird\r\n" +
+		"\tEND\n" +
+		"fi\n"
+	res, err := p.FixHeredocIndent([]byte(script))
+	if err != nil || string(res) != expected {
+		t.Errorf("FixHeredocIndent should indent the <<- bodies with tabs: expected\n%q\nbut found\n%q (%v)", expected, res, err)
+	}
+
+	if res, err = p.FixHeredocIndent([]byte("cat <<-EOF\n        a\nEOF\n"), "8"); err != nil || string(res) != "cat <<-EOF\n\ta\nEOF\n" {
+		t.Errorf("FixHeredocIndent should use the given tab width but found %q (%v)", res, err)
+	}
+	if _, err = p.FixHeredocIndent([]byte(script), "0"); err == nil {
+		t.Error("FixHeredocIndent should reject an invalid tab width")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func tdfDiff(pathA, pathB string) error {
+	a, err := loadTdf(pathA)
+	if err != nil {
+		return err
+	}
+	b, err := loadTdf(pathB)
+	if err != nil {
+		return err
+	}
+	diffs := diffTdf(a, b)
+	if len(diffs) == 0 {
+		fmt.Printf("%s and %s describe the same transformations\n", pathA, pathB)
+		return nil
+	}
+	fmt.Println(strings.Join(diffs, "\n"))
+	return nil
+}
+
+// diffTdf compares the content of two transformation files, ignoring their format.
+// Transformations are matched by name, or by position when they have no name, and
+// their procedures are compared in order. Lines start with "+" for additions, "-"
+// for removals and "~" for changes.
+func diffTdf(a, b T) []string {
+	var diffs []string
+	if version(a) != version(b) {
+		diffs = append(diffs, fmt.Sprintf("~ version: %v -> %v", version(a), version(b)))
+	}
+	if a.Exclude != b.Exclude {
+		diffs = append(diffs, fmt.Sprintf("~ exclude: %q -> %q", a.Exclude, b.Exclude))
+	}
+
+	matched := make(map[int]bool)
+	for i, ta := range a.Transformations {
+		j := matchTransformation(ta, i, b.Transformations, matched)
+		if j == -1 {
+			diffs = append(diffs, "- transformation "+transformationLabel(ta, i))
+			continue
+		}
+		matched[j] = true
+		if changes := diffTransformation(ta, b.Transformations[j]); len(changes) > 0 {
+			diffs = append(diffs, "~ transformation "+transformationLabel(ta, i)+":")
+			diffs = append(diffs, changes...)
+		}
+	}
+	for j, tb := range b.Transformations {
+		if !matched[j] {
+			diffs = append(diffs, "+ transformation "+transformationLabel(tb, j))
+		}
+	}
+	return diffs
+}
+
+func version(t T) int {
+	if t.Version == 0 {
+		return 1
+	}
+	return t.Version
+}
+
+func transformationLabel(tr Transformation, i int) string {
+	if tr.Name != "" {
+		return strconv.Quote(tr.Name)
+	}
+	return fmt.Sprintf("#%v", i+1)
+}
+
+// matchTransformation returns the index of the transformation of others corresponding
+// to tr, or -1 if there is none.
+func matchTransformation(tr Transformation, i int, others []Transformation, matched map[int]bool) int {
+	for j, other := range others {
+		if !matched[j] && tr.Name != "" && other.Name == tr.Name {
+			return j
+		}
+	}
+	if tr.Name == "" && i < len(others) && others[i].Name == "" && !matched[i] {
+		return i
+	}
+	return -1
+}
+
+func diffTransformation(a, b Transformation) []string {
+	var diffs []string
+	if includeA, includeB := sortedPatterns(a), sortedPatterns(b); !reflect.DeepEqual(includeA, includeB) {
+		diffs = append(diffs, fmt.Sprintf("    ~ include: %q -> %q", includeA, includeB))
+	}
+	if !reflect.DeepEqual(a.Pre, b.Pre) && (len(a.Pre) > 0 || len(b.Pre) > 0) {
+		diffs = append(diffs, fmt.Sprintf("    ~ pre: %q -> %q", a.Pre, b.Pre))
+	}
+	for _, d := range diffProcs(a.Cond, b.Cond) {
+		diffs = append(diffs, strings.Replace(d, " proc ", " cond ", 1))
+	}
+	return append(diffs, diffProcs(a.Proc, b.Proc)...)
+}
+
+func sortedPatterns(tr Transformation) []string {
+	patterns := append([]string{}, includePatterns(tr)...)
+	sort.Strings(patterns)
+	return patterns
+}
+
+// diffProcs compares two lists of procedures using their longest common subsequence.
+// A removal directly followed by the addition of a procedure with the same name is
+// reported as a change.
+func diffProcs(a, b []Procedure) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if reflect.DeepEqual(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diffs []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && reflect.DeepEqual(a[i], b[j]):
+			i++
+			j++
+		case i < len(a) && j < len(b) && a[i].Name == b[j].Name && lcs[i+1][j+1] == lcs[i][j]:
+			diffs = append(diffs, fmt.Sprintf("    ~ proc %v: %s -> %s", i+1, describeProc(a[i]), describeProc(b[j])))
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			diffs = append(diffs, fmt.Sprintf("    - proc %v: %s", i+1, describeProc(a[i])))
+			i++
+		default:
+			diffs = append(diffs, fmt.Sprintf("    + proc %v: %s", j+1, describeProc(b[j])))
+			j++
+		}
+	}
+	return diffs
+}
+
+// describeProc formats a procedure like a function call, e.g. Replace("old", "new").
+func describeProc(proc Procedure) string {
+	var params []string
+	for _, param := range proc.Params {
+		params = append(params, strconv.Quote(param))
+	}
+	desc := proc.Name + "(" + strings.Join(params, ", ") + ")"
+	if proc.OnError != "" {
+		desc += " onerror " + proc.OnError
+	}
+	if len(proc.Proc) > 0 {
+		var nested []string
+		for _, p := range proc.Proc {
+			nested = append(nested, describeProc(p))
+		}
+		desc += " { " + strings.Join(nested, "; ") + " }"
+	}
+	return desc
+}
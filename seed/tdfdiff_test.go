@@ -0,0 +1,76 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var tdfTomlWithInsert = `exclude= "*.out"
+
+[[transformations]]
+  filter = "*.yml|*.go"
+  pre = [ "AlwaysTrue" ]
+
+  [[transformations.proc]]
+   name = "Replace"
+   params = [ "old", "new" ]
+
+  [[transformations.proc]]
+   name = "Insert"
+   params = [ "bar" ]
+
+[[transformations]]
+  filter = "*.java"
+  pre = [ "AlwaysTrue" ]
+
+  [[transformations.proc]]
+    name = "DoNothing"
+`
+
+func TestDiffTdf(t *testing.T) {
+	a := mustParseTdf(t, []byte(tdfYml), "yml")
+	b := mustParseTdf(t, []byte(tdfTomlWithInsert), "toml")
+
+	expected := []string{
+		"~ transformation #1:",
+		`    + proc 2: Insert("bar")`,
+	}
+	if diffs := diffTdf(a, b); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("diffTdf: %q was expected but found %q", expected, diffs)
+	}
+
+	if diffs := diffTdf(a, mustParseTdf(t, []byte(tdfToml), "toml")); len(diffs) != 0 {
+		t.Errorf("diffTdf: the same transformations in another format should not differ but found %q", diffs)
+	}
+
+	named := diffTdf(T{Transformations: []Transformation{{Name: "old"}, {Name: "kept"}}},
+		T{Transformations: []Transformation{{Name: "kept"}, {Name: "new"}}})
+	expected = []string{`- transformation "old"`, `+ transformation "new"`}
+	if !reflect.DeepEqual(named, expected) {
+		t.Errorf("diffTdf: named transformations should be matched by name, expected %q but found %q", expected, named)
+	}
+}
+
+func TestDiffProcs(t *testing.T) {
+	replace := Procedure{Name: "Replace", Params: []string{"a", "b"}}
+	insert := Procedure{Name: "Insert", Params: []string{"x"}}
+	lower := Procedure{Name: "ToLower"}
+
+	diffs := diffProcs([]Procedure{replace, insert, lower}, []Procedure{
+		replace, Procedure{Name: "Insert", Params: []string{"y"}}})
+	expected := []string{
+		`    ~ proc 2: Insert("x") -> Insert("y")`,
+		`    - proc 3: ToLower()`,
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("diffProcs: %q was expected but found %q", expected, diffs)
+	}
+}
@@ -10,64 +10,220 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 )
 
 // Conditions regroup all the precondition methods
 type Conditions struct{}
 
-// Procedures regroup all the procedure methods
-type Procedures struct{}
+// Procedures regroup all the procedure methods. It holds the
+// path of the file being transformed.
+type Procedures struct {
+	fileName string
+}
+
+// includePatterns returns the patterns of the files selected by the transformation.
+func includePatterns(tr Transformation) []string {
+	if tr.Filter == "" {
+		return tr.Include
+	}
+	return append(strings.Split(tr.Filter, "|"), tr.Include...)
+}
 
-func checkFileName(fileName string, tr Transformation) bool {
+// checkFileName tells whether the file is selected by the include patterns of the
+// transformation. Patterns starting with "!" exclude the files they match, whatever
+// the position of the pattern, e.g. "*.go|!*_test.go" selects the Go files but the
+// tests. With only negated patterns, all the other files are selected. It fails on
+// an invalid pattern.
+func checkFileName(fileName string, tr Transformation) (bool, error) {
 	matched := false
+	positive := false
 	// Include files
-	for _, patt := range strings.Split(tr.Filter, "|") {
+	for _, patt := range includePatterns(tr) {
+		if strings.HasPrefix(patt, "!") {
+			continue
+		}
+		positive = true
 		res, err := filepath.Match(patt, filepath.Base(fileName))
 		matched = res || matched
 		if err != nil {
-			log.Fatalf("Failed to parse pattern: %s\n%v", tr.Filter, err)
+			return false, fmt.Errorf("Failed to parse pattern: %s\n%v", patt, err)
+		}
+	}
+
+	// Then exclude the files matching a negated pattern
+	for _, patt := range includePatterns(tr) {
+		if !strings.HasPrefix(patt, "!") {
+			continue
+		}
+		if !positive {
+			matched = true
+			positive = true
+		}
+		res, err := filepath.Match(patt[1:], filepath.Base(fileName))
+		if err != nil {
+			return false, fmt.Errorf("Failed to parse pattern: %s\n%v", patt, err)
+		}
+		if res {
+			return false, nil
 		}
 	}
-	return matched
+	return matched, nil
 }
 
-func checkCondition(fileName string, data []byte, t Transformation) bool {
-	ok := true
-	var c Conditions
+// checkCondition tells whether the file satisfies the preconditions of the
+// transformation, the Pre ones then the Cond ones which take params. A
+// precondition name starting with "!" is negated. It fails on an unknown or
+// invalid precondition.
+func checkCondition(fileName string, data []byte, t Transformation) (bool, error) {
+	conds := make([]Procedure, 0, len(t.Pre)+len(t.Cond))
 	for _, pre := range t.Pre {
-		m := reflect.ValueOf(&c).MethodByName(pre)
-		if m.IsValid() {
-			ok = m.Call([]reflect.Value{reflect.ValueOf(fileName), reflect.ValueOf(data)})[0].Bool()
-		} else {
-			log.Fatalf(`Cannot find the precondition method "%s"`, pre)
-		}
-		if !ok {
-			break
+		conds = append(conds, Procedure{Name: pre})
+	}
+	conds = append(conds, t.Cond...)
+
+	var c Conditions
+	for _, cond := range conds {
+		if ok, err := c.check(fileName, data, cond); !ok || err != nil {
+			return false, err
 		}
 	}
-	return ok
+	return true, nil
+}
+
+// check calls the precondition method. Preconditions return either a bool or a
+// bool and an error, their params following the file name and content.
+func (c *Conditions) check(fileName string, data []byte, cond Procedure) (bool, error) {
+	name := strings.TrimPrefix(cond.Name, "!")
+	method, ok := lookupCondition(name)
+	if !ok {
+		return false, fmt.Errorf(`Cannot find the precondition method "%s"`, name)
+	}
+	m := reflect.ValueOf(c).Method(method.Index)
+
+	vals := []reflect.Value{reflect.ValueOf(fileName), reflect.ValueOf(data)}
+	for _, param := range cond.Params {
+		vals = append(vals, reflect.ValueOf(param))
+	}
+	res := m.Call(vals)
+	if len(res) > 1 && !res[1].IsNil() {
+		return false, fmt.Errorf(`Invalid precondition "%s": %v`, name, res[1].Interface())
+	}
+	return res[0].Bool() != (name != cond.Name), nil
+}
+
+// Policies applied when a procedure returns an error
+const (
+	onErrorFail = "fail"
+	onErrorWarn = "warn"
+	onErrorSkip = "skip"
+)
+
+var procListType = reflect.TypeOf([]Procedure{})
+
+// applyProcs calls the procedures of the transformation in order.
+func applyProcs(fileName string, data []byte, t Transformation) ([]byte, error) {
+	p := Procedures{fileName: fileName}
+	return p.apply(data, t.Proc)
 }
 
-func applyProcs(data []byte, t Transformation) []byte {
-	var p Procedures
-	for _, proc := range t.Proc {
+// apply calls the given procedures in order. Procedures return either the new
+// data or the new data and an error. The error is handled according to the
+// procedure OnError policy. Constructs, i.e. procedures taking a []Procedure
+// as second argument, receive the nested procedures.
+func (p *Procedures) apply(data []byte, procs []Procedure) ([]byte, error) {
+	for _, proc := range procs {
+		method, ok := lookupProcedure(proc.Name)
+		if !ok {
+			return data, fmt.Errorf("Cannot find method to proc name: %s", proc.Name)
+		}
+		m := reflect.ValueOf(p).Method(method.Index)
+
 		vals := []reflect.Value{reflect.ValueOf(data)}
+		if m.Type().NumIn() > 1 && m.Type().In(1) == procListType {
+			vals = append(vals, reflect.ValueOf(proc.Proc))
+		}
 		for _, param := range proc.Params {
+			param, err := resolveParam(param)
+			if err != nil {
+				return data, fmt.Errorf("%s failed: %v", proc.Name, err)
+			}
 			vals = append(vals, reflect.ValueOf(param))
 		}
-		m := reflect.ValueOf(&p).MethodByName(proc.Name)
-		if !m.IsValid() {
-			log.Fatalf("Cannot find method to proc name: %s\n", proc.Name)
+
+		policy := proc.OnError
+		if policy == "" {
+			policy = onErrorFail
+		}
+		if policy != onErrorFail && policy != onErrorWarn && policy != onErrorSkip {
+			return data, fmt.Errorf("Unknown onerror policy \"%s\" for proc %s. Expected fail, warn or skip.", proc.OnError, proc.Name)
+		}
+
+		res := m.Call(vals)
+		if len(res) > 1 && !res[1].IsNil() {
+			err := res[1].Interface().(error)
+			switch policy {
+			case onErrorFail:
+				return data, fmt.Errorf("%s failed: %v", proc.Name, err)
+			case onErrorWarn:
+				log.Printf("%s failed, skipping it: %v\n", proc.Name, err)
+			}
+			continue
+		}
+		data = res[0].Bytes()
+	}
+	return data, nil
+}
+
+// envParamPrefix starts the params read from the environment when the procedure runs.
+const envParamPrefix = "$env:"
+
+// resolveParam returns the value of the environment variable for the "$env:NAME"
+// params. A variable which is not set is an error in -strict mode, or an empty
+// value otherwise. A "$$env:" prefix escapes a literal "$env:".
+func resolveParam(param string) (string, error) {
+	if strings.HasPrefix(param, "$"+envParamPrefix) {
+		return param[1:], nil
+	}
+	if !strings.HasPrefix(param, envParamPrefix) {
+		return param, nil
+	}
+	name := param[len(envParamPrefix):]
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		if strict {
+			return "", fmt.Errorf("the environment variable %s is not set", name)
+		}
+		if vverbose {
+			fmt.Fprintf(logOutput(), "The environment variable %s is not set, using an empty value\n", name)
 		}
-		data = m.Call(vals)[0].Bytes()
 	}
-	return data
+	return value, nil
+}
+
+// unparsable returns the error of a procedure which cannot parse the file, in
+// -strict mode. Otherwise the file is skipped.
+func unparsable(proc string, err error) error {
+	if strict {
+		return err
+	}
+	if vverbose {
+		fmt.Fprintf(logOutput(), "Skip %s: %v\n", proc, err)
+	}
+	return nil
 }
 
 // -----------------
@@ -77,6 +233,200 @@ func (c *Conditions) AlwaysTrue(fileName string, data []byte) bool {
 	return true
 }
 
+// ContainsString is a precondition which tells whether the file contains the
+// string, e.g. to only rewrite the files using an API and skip the others.
+//
+// cond:
+//  -
+//    name: ContainsString
+//    params: "github.com/pkg/errors"
+func (c *Conditions) ContainsString(fileName string, data []byte, s string) bool {
+	return bytes.Contains(data, []byte(s))
+}
+
+// NotContains is a precondition which tells whether the file does not contain
+// the string, like "!ContainsString", e.g. to skip the files already migrated.
+//
+// cond:
+//  -
+//    name: NotContains
+//    params: "Code generated"
+func (c *Conditions) NotContains(fileName string, data []byte, s string) bool {
+	return !bytes.Contains(data, []byte(s))
+}
+
+// ContainsRegex is a precondition which tells whether the content of the file
+// matches the regexp, e.g. to only rewrite the files declaring a given API version.
+// The regexp is compiled once for all the files, and checked when the transformation
+// file is loaded.
+//
+// cond:
+//  -
+//    name: ContainsRegex
+//    params: "(?m)^apiVersion: *apps/v1beta\\d$"
+func (c *Conditions) ContainsRegex(fileName string, data []byte, pattern string) (bool, error) {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.Match(data), nil
+}
+
+// ValueIn is a precondition which captures a value of the file with the
+// regexp, the first group if any, and tells whether it is one of the given
+// values. It is false if the regexp does not match. Use "!ValueIn" to check
+// that the value is not in the list.
+//
+// cond:
+//  -
+//    name: ValueIn
+//    params:
+//      - "(?m)^env: *(\\w+)"
+//      - dev
+//      - staging
+func (c *Conditions) ValueIn(fileName string, data []byte, pattern string, values ...string) (bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	m := re.FindSubmatch(data)
+	if m == nil {
+		return false, nil
+	}
+	value := m[0]
+	if len(m) > 1 {
+		value = m[1]
+	}
+	for _, v := range values {
+		if string(value) == v {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HasFinalNewline is a precondition which tells whether the file ends with an
+// end of line. Empty files have no line to end and are considered as having it.
+// Combined with -check, it flags the files missing a final newline:
+//
+// cond:
+//  -
+//    name: "!HasFinalNewline"
+// proc:
+//  -
+//    name: Insert
+//    params: "\n"
+func (c *Conditions) HasFinalNewline(fileName string, data []byte) bool {
+	return len(data) == 0 || data[len(data)-1] == '\n'
+}
+
+// IsEmpty is a precondition which tells whether the file has no content at all,
+// e.g. to stamp the placeholder files:
+//
+// pre:
+//  - IsEmpty
+// proc:
+//  -
+//    name: Insert
+//    params: "// TODO: implement\n"
+func (c *Conditions) IsEmpty(fileName string, data []byte) bool {
+	return len(data) == 0
+}
+
+// IsBlank is a precondition which tells whether the file is empty or only holds
+// whitespace, like blank lines left in a stub file.
+//
+// pre:
+//  - IsBlank
+func (c *Conditions) IsBlank(fileName string, data []byte) bool {
+	return len(bytes.TrimSpace(data)) == 0
+}
+
+// LongerThan is a precondition which tells whether the file has more than the
+// given number of lines, e.g. to select the files to refactor.
+//
+// cond:
+//  -
+//    name: LongerThan
+//    params: "500"
+func (c *Conditions) LongerThan(fileName string, data []byte, lines string) (bool, error) {
+	n, err := strconv.Atoi(lines)
+	if err != nil || n < 0 {
+		return false, fmt.Errorf("invalid number of lines: %s", lines)
+	}
+	return lineCount(data) > n, nil
+}
+
+// lineCount returns the number of lines of the data, the last one having no
+// end of line or not.
+func lineCount(data []byte) int {
+	n := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// repoFiles caches the result of the RepoHasFile preconditions by pattern, as the
+// target tree is walked only once for all the files.
+var repoFiles = struct {
+	sync.Mutex
+	found map[string]bool
+}{found: make(map[string]bool)}
+
+// RepoHasFile is a precondition which tells whether the target directory contains
+// a file matching the glob, anywhere in its tree. The pattern is matched against
+// the file names, or against the paths relative to the target directory when it
+// contains a "/". The tree is walked once and the result is cached, e.g. to apply
+// Go transformations only in a module:
+//
+// cond:
+//  -
+//    name: RepoHasFile
+//    params: "go.mod"
+func (c *Conditions) RepoHasFile(fileName string, data []byte, pattern string) (bool, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return false, err
+	}
+	repoFiles.Lock()
+	defer repoFiles.Unlock()
+	key := dirPath + "\x00" + pattern
+	if found, ok := repoFiles.found[key]; ok {
+		return found, nil
+	}
+
+	found, errFound := false, fmt.Errorf("found")
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := info.Name()
+		if strings.Contains(pattern, "/") {
+			rel, err := filepath.Rel(dirPath, path)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(rel)
+		}
+		if match, _ := filepath.Match(pattern, name); match {
+			found = true
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return false, err
+	}
+	repoFiles.found[key] = found
+	return found, nil
+}
+
 // -----------------
 
 // Insert the string s at the end of the given data.
@@ -110,13 +460,819 @@ func (p *Procedures) Replace(dat []byte, pairs ...string) []byte {
 	for i := 0; i < len(pairs); i += 2 {
 		new = []byte(strings.Replace(string(new), pairs[i], pairs[i+1], -1))
 		if vverbose && bytes.Compare(new, dat) != 0 {
-			fmt.Printf("\t%s -> %s\n", pairs[i], pairs[i+1])
+			fmt.Fprintf(logOutput(), "\t%s -> %s\n", pairs[i], pairs[i+1])
 		}
 	}
 
 	return new
 }
 
+// ReplaceIndex replaces only the nth occurrence of the old string by the new
+// one, counting the non-overlapping occurrences from 1. A file with fewer
+// occurrences is left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: ReplaceIndex
+//    params: ["version:", "appVersion:", "2"]
+func (p *Procedures) ReplaceIndex(dat []byte, old, new, index string) ([]byte, error) {
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 1 {
+		return dat, fmt.Errorf("invalid occurrence index: %s", index)
+	}
+	if old == "" {
+		return dat, fmt.Errorf("the string to replace is empty")
+	}
+
+	start := 0
+	for i := 1; ; i++ {
+		pos := bytes.Index(dat[start:], []byte(old))
+		if pos < 0 {
+			if strict {
+				return dat, fmt.Errorf("no occurrence %v of %q, found %v", n, old, i-1)
+			}
+			if vverbose {
+				fmt.Fprintf(logOutput(), "No occurrence %v of %q, found %v\n", n, old, i-1)
+			}
+			return dat, nil
+		}
+		start += pos
+		if i == n {
+			break
+		}
+		start += len(old)
+	}
+
+	res := make([]byte, 0, len(dat)-len(old)+len(new))
+	res = append(res, dat[:start]...)
+	res = append(res, new...)
+	return append(res, dat[start+len(old):]...), nil
+}
+
+// compiledRegexps caches the regexps of RegexReplace and ContainsRegex by
+// pattern, so that each pattern is compiled once per run instead of once per
+// file.
+var compiledRegexps = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compileRegexp returns the compiled pattern, from the cache if it was already used.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	compiledRegexps.Lock()
+	defer compiledRegexps.Unlock()
+	if re, ok := compiledRegexps.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledRegexps.m[pattern] = re
+	return re, nil
+}
+
+// RegexReplace replaces the matches of the regexp with the replacement, which
+// can refer to the capture groups with $1 or ${name}. Use ${1} when the group
+// is followed by a letter or a digit. The pattern is checked when the
+// transformation file is loaded.
+//
+// proc:
+//  -
+//    name: RegexReplace
+//    params:
+//      - "github\\.com/old/(\\w+)"
+//      - "github.com/new/$1"
+func (p *Procedures) RegexReplace(dat []byte, pattern, replacement string) ([]byte, error) {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return dat, err
+	}
+	return re.ReplaceAll(dat, []byte(replacement)), nil
+}
+
+// checkPatterns compiles the regexps of the RegexReplace procedures of the
+// transformation file, nested ones included, and of the ContainsRegex
+// preconditions, to report an invalid pattern before any file is transformed.
+// The patterns read from the environment are only known when the procedures run.
+func checkPatterns(t T) error {
+	var check func(tr Transformation, i int, procs []Procedure) error
+	check = func(tr Transformation, i int, procs []Procedure) error {
+		for _, proc := range procs {
+			if proc.Name == "RegexReplace" {
+				if len(proc.Params) != 2 {
+					return fmt.Errorf("RegexReplace of %s expects a pattern and a replacement but found %v params",
+						ruleID(tr, i), len(proc.Params))
+				}
+				if !strings.HasPrefix(proc.Params[0], envParamPrefix) {
+					if _, err := compileRegexp(proc.Params[0]); err != nil {
+						return fmt.Errorf("Invalid RegexReplace pattern in %s: %v", ruleID(tr, i), err)
+					}
+				}
+			}
+			if err := check(tr, i, proc.Proc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, tr := range t.Transformations {
+		for _, cond := range tr.Cond {
+			if strings.TrimPrefix(cond.Name, "!") != "ContainsRegex" {
+				continue
+			}
+			if len(cond.Params) != 1 {
+				return fmt.Errorf("ContainsRegex of %s expects a pattern but found %v params",
+					ruleID(tr, i), len(cond.Params))
+			}
+			if _, err := compileRegexp(cond.Params[0]); err != nil {
+				return fmt.Errorf("Invalid ContainsRegex pattern in %s: %v", ruleID(tr, i), err)
+			}
+		}
+		if err := check(tr, i, tr.Proc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteMappings caches the mappings of ReplaceFromURL by URL, or the error
+// of their fetch, so that each URL is fetched once per run.
+var remoteMappings = struct {
+	sync.Mutex
+	pairs map[string][]string
+	errs  map[string]error
+}{pairs: make(map[string][]string), errs: make(map[string]error)}
+
+// ReplaceFromURL replaces the strings with the mapping returned by the URL, a
+// JSON object mapping the old strings to the new ones, e.g. a rename table
+// managed centrally. The URL is fetched once for all the files. The longest
+// strings are replaced first. When the mapping cannot be fetched, the files
+// are left untouched, or reported with -strict.
+//
+// proc:
+//  -
+//    name: ReplaceFromURL
+//    params: "https://example.com/renames.json"
+func (p *Procedures) ReplaceFromURL(dat []byte, url string) ([]byte, error) {
+	pairs, err := remoteMapping(url)
+	if err != nil {
+		return dat, unparsable("ReplaceFromURL", err)
+	}
+	return []byte(strings.NewReplacer(pairs...).Replace(string(dat))), nil
+}
+
+// remoteMapping returns the old and new strings of the mapping of the URL, the
+// longest old strings first.
+func remoteMapping(url string) ([]string, error) {
+	remoteMappings.Lock()
+	defer remoteMappings.Unlock()
+	if pairs, ok := remoteMappings.pairs[url]; ok {
+		return pairs, remoteMappings.errs[url]
+	}
+
+	var mapping map[string]string
+	dat, _, err := fetchURL(url)
+	if err == nil {
+		if err = json.Unmarshal(dat, &mapping); err != nil {
+			err = fmt.Errorf("invalid mapping from %s: %v", url, err)
+		}
+	}
+	var olds []string
+	for old := range mapping {
+		if old != "" {
+			olds = append(olds, old)
+		}
+	}
+	sort.Sort(byLengthDesc(olds))
+	pairs := []string{}
+	for _, old := range olds {
+		pairs = append(pairs, old, mapping[old])
+	}
+	remoteMappings.pairs[url], remoteMappings.errs[url] = pairs, err
+	return pairs, err
+}
+
+// byLengthDesc sorts the strings from the longest one, then alphabetically.
+type byLengthDesc []string
+
+func (b byLengthDesc) Len() int      { return len(b) }
+func (b byLengthDesc) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byLengthDesc) Less(i, j int) bool {
+	if len(b[i]) != len(b[j]) {
+		return len(b[i]) > len(b[j])
+	}
+	return b[i] < b[j]
+}
+
+// ToLower converts the data to lower case. It is mostly useful inside
+// constructs like WithinCapture.
+//
+// proc:
+//  -
+//    name: ToLower
+func (p *Procedures) ToLower(dat []byte) []byte {
+	return bytes.ToLower(dat)
+}
+
+// WithinCapture applies the nested procedures only to the text captured by the
+// given group of each match of the regexp, then puts the result back in place.
+//
+// proc:
+//  -
+//    name: WithinCapture
+//    params:
+//      - "func (\\w+)\\("
+//      # Index of the capture group
+//      - "1"
+//    proc:
+//      -
+//        name: ToLower
+func (p *Procedures) WithinCapture(dat []byte, procs []Procedure, pattern, group string) ([]byte, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return dat, err
+	}
+	n, err := strconv.Atoi(group)
+	if err != nil || n < 0 || n > re.NumSubexp() {
+		return dat, fmt.Errorf("invalid capture group %s for %s", group, pattern)
+	}
+
+	var captures []region
+	for _, m := range re.FindAllSubmatchIndex(dat, -1) {
+		if m[2*n] != -1 {
+			captures = append(captures, region{m[2*n], m[2*n+1]})
+		}
+	}
+	return p.applyToRegions(dat, captures, procs)
+}
+
+// LimitBlankLines collapses the runs of blank lines to at most max lines.
+// Combined with a region construct like InCodeFence, it can use a different
+// limit for each region.
+//
+// proc:
+//  -
+//    name: LimitBlankLines
+//    params: "1"
+func (p *Procedures) LimitBlankLines(dat []byte, max string) ([]byte, error) {
+	n, err := strconv.Atoi(max)
+	if err != nil || n < 0 {
+		return dat, fmt.Errorf("invalid maximum number of blank lines: %s", max)
+	}
+
+	var res []byte
+	blanks := 0
+	for _, line := range bytes.SplitAfter(dat, []byte("\n")) {
+		if len(line) > 0 && len(bytes.TrimSpace(line)) == 0 {
+			blanks++
+			if blanks > n {
+				continue
+			}
+		} else {
+			blanks = 0
+		}
+		res = append(res, line...)
+	}
+	return res, nil
+}
+
+// templateFuncs are the functions available in the templates of the procedures.
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// TemplateMatch replaces each match of the regexp by the output of a text/template
+// executed with the named groups of the match as fields. The template can use the
+// lower, upper and trim functions.
+//
+// proc:
+//  -
+//    name: TemplateMatch
+//    params:
+//      - "(?P<month>\\d{2})-(?P<day>\\d{2})-(?P<year>\\d{4})"
+//      - "{{.day}}/{{.month}}/{{.year}}"
+func (p *Procedures) TemplateMatch(dat []byte, pattern, text string) ([]byte, error) {
+	return replaceWithTemplate(dat, pattern, text, nil)
+}
+
+// replaceWithTemplate replaces each match of the regexp by the output of the template
+// executed with the given fields and the named groups of the match.
+func replaceWithTemplate(dat []byte, pattern, text string, fields map[string]string) ([]byte, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return dat, err
+	}
+	tmpl, err := template.New("match").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return dat, err
+	}
+
+	var res []byte
+	last := 0
+	for _, m := range re.FindAllSubmatchIndex(dat, -1) {
+		values := make(map[string]string)
+		for name, value := range fields {
+			values[name] = value
+		}
+		for i, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			values[name] = ""
+			if m[2*i] != -1 {
+				values[name] = string(dat[m[2*i]:m[2*i+1]])
+			}
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, values); err != nil {
+			return dat, err
+		}
+		res = append(res, dat[last:m[0]]...)
+		res = append(res, buf.Bytes()...)
+		last = m[1]
+	}
+	return append(res, dat[last:]...), nil
+}
+
+// PathTemplate replaces each match of the regexp like TemplateMatch, the template
+// also having the Dir, Base and Ext fields with the name of the directory of the
+// file, its name and its extension. For instance, in "store/db.go", Dir is "store",
+// Base "db.go" and Ext ".go".
+//
+// proc:
+//  -
+//    name: PathTemplate
+//    params:
+//      - "(?m)^package \\w+$"
+//      - "package {{.Dir}}"
+func (p *Procedures) PathTemplate(dat []byte, pattern, text string) ([]byte, error) {
+	if p.fileName == "" {
+		return dat, fmt.Errorf("the name of the file is unknown")
+	}
+	abs, err := filepath.Abs(p.fileName)
+	if err != nil {
+		return dat, err
+	}
+	fields := map[string]string{
+		"Dir":  filepath.Base(filepath.Dir(abs)),
+		"Base": filepath.Base(abs),
+		"Ext":  filepath.Ext(abs),
+	}
+	return replaceWithTemplate(dat, pattern, text, fields)
+}
+
+// sharedSequence is the counter of the Sequence procedures in -global-sequence mode.
+var sharedSequence int64
+
+// Sequence replaces each match of the regexp by the output of a text/template
+// executed with N, the next number of a sequence starting at the optional start
+// param, 1 by default. The sequence restarts for each file, unless -global-sequence
+// is set: the sequence is then shared by all the files, which are processed one
+// at a time in path order so the numbers are the same on each run.
+//
+// proc:
+//  -
+//    name: Sequence
+//    params:
+//      - "TODO\\(#\\?\\)"
+//      - "TODO(#{{.N}})"
+//      # Optional
+//      - "1"
+func (p *Procedures) Sequence(dat []byte, pattern, text string, start ...string) ([]byte, error) {
+	first := int64(1)
+	if len(start) > 0 {
+		var err error
+		if first, err = strconv.ParseInt(start[0], 10, 64); err != nil {
+			return dat, fmt.Errorf("invalid start of the sequence: %s", start[0])
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return dat, err
+	}
+	tmpl, err := template.New("sequence").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return dat, err
+	}
+
+	n := first - 1
+	var execErr error
+	res := re.ReplaceAllFunc(dat, func(match []byte) []byte {
+		if globalSequence {
+			n = first - 1 + atomic.AddInt64(&sharedSequence, 1)
+		} else {
+			n++
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, map[string]int64{"N": n}); err != nil && execErr == nil {
+			execErr = err
+		}
+		return buf.Bytes()
+	})
+	if execErr != nil {
+		return dat, execErr
+	}
+	return res, nil
+}
+
+var copyrightRegex = regexp.MustCompile(`(?i)copyright[^\n]*?\d{4}(?:\s*-\s*\d{4})?`)
+var yearRangeRegex = regexp.MustCompile(`(\d{4})(?:(\s*-\s*)(\d{4}))?`)
+
+// currentYear returns the year of SOURCE_DATE_EPOCH if it is set, for reproducible
+// builds, or the current year.
+func currentYear() int {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC().Year()
+	}
+	return time.Now().Year()
+}
+
+// UpdateCopyrightYear updates the end year of the copyright notices to the current
+// year, or the year of SOURCE_DATE_EPOCH when set. A single year becomes a range,
+// e.g. "2013" becomes "2013-2025", and the start year is never modified. The
+// optional regexp selects the notices, by default the "copyright" lines. The first
+// year or year range of each match is updated.
+//
+// proc:
+//  -
+//    name: UpdateCopyrightYear
+//    params:
+//      # Optional
+//      - "Copyright \\(c\\) \\d{4}(-\\d{4})?"
+func (p *Procedures) UpdateCopyrightYear(dat []byte, pattern ...string) ([]byte, error) {
+	re := copyrightRegex
+	if len(pattern) > 0 {
+		var err error
+		if re, err = regexp.Compile(pattern[0]); err != nil {
+			return dat, err
+		}
+	}
+	year := currentYear()
+
+	return re.ReplaceAllFunc(dat, func(notice []byte) []byte {
+		m := yearRangeRegex.FindSubmatchIndex(notice)
+		if m == nil {
+			return notice
+		}
+		last, _ := strconv.Atoi(string(notice[m[2]:m[3]]))
+		sep := "-"
+		if m[6] != -1 {
+			last, _ = strconv.Atoi(string(notice[m[6]:m[7]]))
+			sep = string(notice[m[4]:m[5]])
+		}
+		if last >= year {
+			return notice
+		}
+
+		res := append([]byte(nil), notice[:m[3]]...)
+		res = append(res, fmt.Sprintf("%s%v", sep, year)...)
+		return append(res, notice[m[1]:]...)
+	}), nil
+}
+
+// SyncSelfName replaces the whole word occurrences of the given name by the base name
+// of the file without extension. It keeps the references of a file to itself up to
+// date after a rename, e.g. with "oldname" a "// Package oldname" comment becomes
+// "// Package newname" in newname.go.
+//
+// proc:
+//  -
+//    name: SyncSelfName
+//    params: "oldname"
+func (p *Procedures) SyncSelfName(dat []byte, old string) ([]byte, error) {
+	if p.fileName == "" {
+		return dat, fmt.Errorf("the name of the file is unknown")
+	}
+	base := filepath.Base(p.fileName)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+
+	re := regexp.MustCompile(`\b` + regexp.QuoteMeta(old) + `\b`)
+	return re.ReplaceAllLiteral(dat, []byte(name)), nil
+}
+
+// DeleteLine removes the lines matching the regexp. In line mode, the
+// whole line is dropped, line terminator included.
+//
+// proc:
+//  -
+//    name: DeleteLine
+//    params: "^\\s*// DEBUG"
+func (p *Procedures) DeleteLine(dat []byte, pattern string) ([]byte, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return dat, err
+	}
+	if len(dat) == 0 && !re.Match(dat) {
+		return dat, nil
+	}
+
+	var res []byte
+	for _, line := range bytes.SplitAfter(dat, []byte("\n")) {
+		if len(line) > 0 && !re.Match(bytes.TrimRight(line, "\r\n")) {
+			res = append(res, line...)
+		}
+	}
+	return res, nil
+}
+
+// DeleteLines removes the lines matching the pattern, which is a regexp or, when
+// it does not compile, a plain substring matched literally, e.g. "fmt.Println(".
+// A valid regexp is never matched literally: "a+b" removes "aab" but not "a+b".
+// The line terminators are kept, CRLF included, and a file whose lines all match
+// becomes empty. In line mode, the whole line is dropped.
+//
+// proc:
+//  -
+//    name: DeleteLines
+//    params: "log.Printf(\"DEBUG"
+func (p *Procedures) DeleteLines(dat []byte, pattern string) ([]byte, error) {
+	return p.DeleteLine(dat, substringPattern(pattern))
+}
+
+// substringPattern returns the pattern if it is a valid regexp, or a regexp
+// matching it as a literal substring.
+func substringPattern(pattern string) string {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return regexp.QuoteMeta(pattern)
+	}
+	return pattern
+}
+
+// InsertAfter inserts the text as a new line after each line matching the
+// regexp, which can be a plain substring without special characters. The text
+// is not inserted again when the lines following the match are already equal
+// to it, so that the transformation can be applied several times. The inserted
+// lines get the line terminator of the matching line.
+//
+// proc:
+//  -
+//    name: InsertAfter
+//    params:
+//      - "^package "
+//      - "// Code generated by seed. DO NOT EDIT."
+func (p *Procedures) InsertAfter(dat []byte, match, text string) ([]byte, error) {
+	re, err := regexp.Compile(match)
+	if err != nil {
+		return dat, err
+	}
+	inserted := strings.Split(text, "\n")
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var res []string
+	for i, line := range lines {
+		res = append(res, line)
+		content := strings.TrimRight(line, "\r\n")
+		if !re.MatchString(content) || followedBy(lines[i+1:], inserted) {
+			continue
+		}
+		eol := line[len(content):]
+		if eol == "" {
+			// The last line of a file without final newline
+			res[len(res)-1] += "\n"
+			res = append(res, text)
+			continue
+		}
+		for _, l := range inserted {
+			res = append(res, l+eol)
+		}
+	}
+	return []byte(strings.Join(res, "")), nil
+}
+
+// followedBy tells whether the lines start with the expected ones, whatever
+// their line terminators.
+func followedBy(lines, expected []string) bool {
+	if len(lines) < len(expected) {
+		return false
+	}
+	for i, l := range expected {
+		if strings.TrimRight(lines[i], "\r\n") != l {
+			return false
+		}
+	}
+	return true
+}
+
+// PrefixLines adds the text at the start of the lines matching the optional
+// regexp, all the non blank lines by default. The text is inserted after the
+// indentation and the lines already starting with it are left untouched, e.g.
+// to comment out a block of config lines. With "remove" as third param, the
+// text is removed from the matching lines instead.
+//
+// proc:
+//  -
+//    name: PrefixLines
+//    params:
+//      - "# "
+//      - "^\\s*legacy\\."
+//  -
+//    name: PrefixLines
+//    params:
+//      - "# "
+//      - "legacy\\."
+//      - remove
+func (p *Procedures) PrefixLines(dat []byte, text string, opts ...string) ([]byte, error) {
+	return affixLines(dat, text, opts, true)
+}
+
+// SuffixLines adds the text at the end of the lines matching the optional regexp,
+// all the non blank lines by default, before the line terminator. The lines
+// already ending with it are left untouched. With "remove" as third param, the
+// text is removed from the matching lines instead.
+//
+// proc:
+//  -
+//    name: SuffixLines
+//    params:
+//      - " \\"
+//      - "^\\s*--"
+func (p *Procedures) SuffixLines(dat []byte, text string, opts ...string) ([]byte, error) {
+	return affixLines(dat, text, opts, false)
+}
+
+// affixLines adds or removes the prefix or the suffix of the lines for PrefixLines
+// and SuffixLines. opts are the optional regexp and "remove" flag of the procedures.
+func affixLines(dat []byte, text string, opts []string, prefix bool) ([]byte, error) {
+	if text == "" {
+		return dat, fmt.Errorf("the text to add must not be empty")
+	}
+	if len(opts) > 2 || (len(opts) == 2 && opts[1] != "remove") {
+		return dat, fmt.Errorf("expected an optional regexp and \"remove\" but found %q", opts)
+	}
+	var re *regexp.Regexp
+	if len(opts) > 0 && opts[0] != "" {
+		var err error
+		if re, err = regexp.Compile(opts[0]); err != nil {
+			return dat, err
+		}
+	}
+	remove := len(opts) == 2
+
+	var res []byte
+	for _, line := range bytes.SplitAfter(dat, []byte("\n")) {
+		content := bytes.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+		if len(bytes.TrimSpace(content)) == 0 || (re != nil && !re.Match(content)) {
+			res = append(res, line...)
+			continue
+		}
+		body := bytes.TrimLeft(content, " \t")
+		indent := content[:len(content)-len(body)]
+		has := bytes.HasSuffix(body, []byte(text))
+		if prefix {
+			has = bytes.HasPrefix(body, []byte(text))
+		}
+		switch {
+		case remove && has && prefix:
+			body = body[len(text):]
+		case remove && has:
+			body = body[:len(body)-len(text)]
+		case !remove && !has && prefix:
+			body = append([]byte(text), body...)
+		case !remove && !has:
+			body = append(append([]byte(nil), body...), text...)
+		}
+		res = append(res, indent...)
+		res = append(res, body...)
+		res = append(res, eol...)
+	}
+	return res, nil
+}
+
+// asciiPunctuation replaces the typographic quotes and dashes by their ASCII equivalent.
+var asciiPunctuation = strings.NewReplacer(
+	"\u201c", `"`, "\u201d", `"`, "\u2018", "'", "\u2019", "'",
+	"\u2014", "--", "\u2013", "-",
+)
+
+// AsciiPunctuation replaces the curly quotes by straight quotes, the em dashes
+// by "--" and the en dashes by "-", as usually found in text pasted from a word
+// processor. Use it inside OutsideCodeFence to leave the code blocks untouched.
+//
+// proc:
+//  -
+//    name: AsciiPunctuation
+func (p *Procedures) AsciiPunctuation(dat []byte) []byte {
+	return []byte(asciiPunctuation.Replace(string(dat)))
+}
+
+var todoRegex = regexp.MustCompile(`\b(TODO|FIXME)\b(\(([^)\n]*)\))?`)
+var todoIDRegex = regexp.MustCompile(`#(\d+)`)
+
+// AnnotateTodos tags each TODO and FIXME marker with a sequential id so it can be
+// tracked, e.g. "TODO" becomes "TODO(#12)". An optional owner is appended after the id.
+// Markers already followed by parentheses, like "TODO(#3)" or "TODO(bob)", are left
+// untouched and the numbering continues after the highest id found in the file.
+//
+// proc:
+//  -
+//    name: AnnotateTodos
+//    params:
+//      # Optional owner
+//      - "bob"
+func (p *Procedures) AnnotateTodos(dat []byte, owner ...string) []byte {
+	matches := todoRegex.FindAllSubmatchIndex(dat, -1)
+
+	// Continue the numbering of the already annotated markers
+	next := 1
+	for _, m := range matches {
+		if m[6] == -1 {
+			continue
+		}
+		if id := todoIDRegex.FindSubmatch(dat[m[6]:m[7]]); id != nil {
+			if n, err := strconv.Atoi(string(id[1])); err == nil && n >= next {
+				next = n + 1
+			}
+		}
+	}
+
+	var res []byte
+	last := 0
+	for _, m := range matches {
+		if m[4] != -1 {
+			continue
+		}
+		tag := fmt.Sprintf("(#%d)", next)
+		if len(owner) > 0 && owner[0] != "" {
+			tag = fmt.Sprintf("(#%d %s)", next, owner[0])
+		}
+		res = append(res, dat[last:m[1]]...)
+		res = append(res, tag...)
+		last = m[1]
+		next++
+
+		if vverbose {
+			fmt.Fprintf(logOutput(), "\t%s%s\n", dat[m[2]:m[3]], tag)
+		}
+	}
+	return append(res, dat[last:]...)
+}
+
+var numberRegex = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// FormatNumbers inserts a thousands separator in the numbers found inside the
+// matches of the regexp, e.g. "1000000" becomes "1,000,000". Signs and decimal
+// parts are preserved. The separator defaults to ",".
+//
+// proc:
+//  -
+//    name: FormatNumbers
+//    params:
+//      - "population: -?[0-9.]+"
+//      # Optional separator
+//      - " "
+func (p *Procedures) FormatNumbers(dat []byte, pattern string, sep ...string) ([]byte, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return dat, err
+	}
+	separator := ","
+	if len(sep) > 0 {
+		separator = sep[0]
+	}
+
+	return re.ReplaceAllFunc(dat, func(match []byte) []byte {
+		return numberRegex.ReplaceAllFunc(match, func(number []byte) []byte {
+			return groupDigits(number, separator)
+		})
+	}), nil
+}
+
+func groupDigits(number []byte, sep string) []byte {
+	integer, decimals := number, []byte(nil)
+	if i := bytes.IndexByte(number, '.'); i != -1 {
+		integer, decimals = number[:i], number[i:]
+	}
+
+	var res []byte
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			res = append(res, sep...)
+		}
+		res = append(res, digit)
+	}
+	return append(res, decimals...)
+}
+
+// ansiRegex matches the CSI sequences (colors, cursor moves), the OSC
+// sequences (window titles, hyperlinks) and the two-character escapes.
+var ansiRegex = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]|\x1b\\][^\x07\x1b]*(?:\x07|\x1b\\\\)|\x1b[@-Z\\\\-_]")
+
+// StripAnsi removes the ANSI escape sequences, like terminal colors, from the data.
+//
+// proc:
+//  -
+//    name: StripAnsi
+func (p *Procedures) StripAnsi(dat []byte) []byte {
+	return ansiRegex.ReplaceAll(dat, nil)
+}
+
 // ReplaceMavenDependency replaces a maven dependency by a new one.
 // The dependency to update are passed as pairs. For instance you want to update the following dependency:
 //
@@ -155,18 +1311,26 @@ func (p *Procedures) Replace(dat []byte, pairs ...string) []byte {
 //  - "xx:xx:*"
 //  - "yy:yy
 //
-func (p *Procedures) ReplaceMavenDependency(data []byte, pairs ...string) []byte {
+func (p *Procedures) ReplaceMavenDependency(data []byte, pairs ...string) ([]byte, error) {
+	if len(pairs)%2 != 0 {
+		return data, fmt.Errorf("ReplaceMavenDependency expects pairs of dependencies but found %v", len(pairs))
+	}
 	for i := 0; i < len(pairs); i += 2 {
-		data = []byte(matchDependency(string(data), pairs[i], pairs[i+1]))
+		res, err := matchDependency(string(data), pairs[i], pairs[i+1])
+		if err != nil {
+			return data, err
+		}
+		data = []byte(res)
 	}
-	return data
+	return data, nil
 }
 
-func matchDependency(pom, old, new string) string {
+func matchDependency(pom, old, new string) (string, error) {
 	currentDep := strings.Split(old, ":")
 	newDep := strings.Split(new, ":")
 
 	var res string
+	var err error
 	switch {
 	case len(currentDep) == 2 && len(newDep) == 2:
 		depRegex := regexp.MustCompile("(<groupId>)" + currentDep[0] + "(<\\/groupId>.*?\\n.*?" +
@@ -175,24 +1339,24 @@ func matchDependency(pom, old, new string) string {
 		res = depRegex.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}")
 
 	case len(currentDep) == 3 && len(newDep) == 3:
-		res = matchDependencyWithVersion(pom, old, new)
+		res, err = matchDependencyWithVersion(pom, old, new)
 
 	case len(currentDep) == 3 && currentDep[2] == "*" && len(newDep) == 2:
-		res = matchDependencyAndRemoveVersion(pom, old, new)
+		res, err = matchDependencyAndRemoveVersion(pom, old, new)
 
 	default:
-		log.Fatalf(`The expected formats for dependencies are: "xx:xx", "xx:xx:xx" or "xx:xx:*". `+
+		err = fmt.Errorf(`The expected formats for dependencies are: "xx:xx", "xx:xx:xx" or "xx:xx:*". `+
 			"But found:\n- %s\n - %s", old, new)
 	}
-	return res
+	return res, err
 }
 
-func matchDependencyWithVersion(pom, old, new string) string {
+func matchDependencyWithVersion(pom, old, new string) (string, error) {
 	currentDep := strings.Split(old, ":")
 	newDep := strings.Split(new, ":")
 
 	if len(currentDep) != 3 && len(newDep) != 3 {
-		log.Fatalf(`ReplaceMavenDependency expects`+
+		return pom, fmt.Errorf(`ReplaceMavenDependency expects`+
 			` the following format "groupId:artifactId:vesion".\n But "%s" and "%s" where found.\n`, old, new)
 	}
 
@@ -219,23 +1383,23 @@ func matchDependencyWithVersion(pom, old, new string) string {
 		if props != "" {
 			propsToReplace := regexp.MustCompile("(<" + regexp.QuoteMeta(props) + ">).*?(</" + regexp.QuoteMeta(props) + ">)")
 			pom = propsToReplace.ReplaceAllString(pom, "${1}"+newDep[2]+"${2}")
-			return depRegex.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}"+"${4}"+"${5}")
+			return depRegex.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}"+"${4}"+"${5}"), nil
 		}
 
-		return depRegex.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}"+newDep[2]+"${5}")
+		return depRegex.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}"+newDep[2]+"${5}"), nil
 		
 	} else {
 		// The dependency was not found. Do nothing
-		return pom
+		return pom, nil
 	}
 }
 
-func matchDependencyAndRemoveVersion(pom, old, new string) string {
+func matchDependencyAndRemoveVersion(pom, old, new string) (string, error) {
 	currentDep := strings.Split(old, ":")
 	newDep := strings.Split(new, ":")
 
 	if len(currentDep) != 2 && len(newDep) != 2 {
-		log.Fatalf("ReplaceMavenDependency expects the following format \"groupId:artifactId\".\n"+
+		return pom, fmt.Errorf("ReplaceMavenDependency expects the following format \"groupId:artifactId\".\n"+
 			" But \"%s\" and \"%s\" where found.\n", old, new)
 	}
 
@@ -245,7 +1409,7 @@ func matchDependencyAndRemoveVersion(pom, old, new string) string {
 
 	depRegexWithVersion := regexp.MustCompile(regex)
 	if depRegexWithVersion.FindString(pom) != "" {
-		return depRegexWithVersion.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}")
+		return depRegexWithVersion.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}"), nil
 	}
 
 	regex = "(<groupId>)" + currentDep[0] + "(<\\/groupId>.*?\\n.*?" +
@@ -253,5 +1417,5 @@ func matchDependencyAndRemoveVersion(pom, old, new string) string {
 
 	depRegex := regexp.MustCompile(regex)
 
-	return depRegex.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}")
+	return depRegex.ReplaceAllString(pom, "${1}"+newDep[0]+"${2}"+newDep[1]+"${3}"), nil
 }
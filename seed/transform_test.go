@@ -9,23 +9,258 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
+func mustCheckCondition(t *testing.T, fileName string, data []byte, tr Transformation) bool {
+	ok, err := checkCondition(fileName, data, tr)
+	if err != nil {
+		t.Fatalf("checkCondition failed: %v", err)
+	}
+	return ok
+}
+
+func mustCheckFileName(t *testing.T, fileName string, tr Transformation) bool {
+	ok, err := checkFileName(fileName, tr)
+	if err != nil {
+		t.Fatalf("checkFileName failed: %v", err)
+	}
+	return ok
+}
+
+func mustMatchDependency(t *testing.T, pom, old, new string) string {
+	res, err := matchDependency(pom, old, new)
+	if err != nil {
+		t.Fatalf("matchDependency failed: %v", err)
+	}
+	return res
+}
+
 func TestPrecondition(t *testing.T) {
 	tt := Transformation{Pre: []string{"AlwaysTrue"}}
 	tf := Transformation{Pre: []string{"AlwaysFalse"}}
 
-	if !checkCondition("", []byte{}, tt) {
+	if !mustCheckCondition(t, "", []byte{}, tt) {
 		t.Error("Precondition should be always true")
 	}
-	if checkCondition("", []byte{}, tf) {
+	if mustCheckCondition(t, "", []byte{}, tf) {
 		t.Error("Precondition should be always false")
 	}
 
 }
 
+func TestValueIn(t *testing.T) {
+	var c *Conditions
+	pattern := `(?m)^env: *(\w+)`
+	conf := []byte("name: app\nenv: staging\n")
+
+	if ok, err := c.ValueIn("", conf, pattern, "dev", "staging"); !ok || err != nil {
+		t.Errorf("ValueIn: staging should be in the list (%v)", err)
+	}
+	if ok, _ := c.ValueIn("", conf, pattern, "prod"); ok {
+		t.Error("ValueIn: staging should not be in the list")
+	}
+	if ok, _ := c.ValueIn("", []byte("name: app\n"), pattern, "dev"); ok {
+		t.Error("ValueIn should be false when nothing is captured")
+	}
+	if _, err := c.ValueIn("", conf, "(", "dev"); err == nil {
+		t.Error("ValueIn should reject an invalid regexp")
+	}
+
+	in := Transformation{Cond: []Procedure{Procedure{Name: "ValueIn", Params: []string{pattern, "dev", "staging"}}}}
+	notIn := Transformation{Cond: []Procedure{Procedure{Name: "!ValueIn", Params: []string{pattern, "dev", "staging"}}}}
+	if !mustCheckCondition(t, "", conf, in) || mustCheckCondition(t, "", conf, notIn) {
+		t.Error("checkCondition: only the in-list condition should be satisfied by staging")
+	}
+	prod := []byte("env: prod\n")
+	if mustCheckCondition(t, "", prod, in) || !mustCheckCondition(t, "", prod, notIn) {
+		t.Error("checkCondition: only the negated condition should be satisfied by prod")
+	}
+}
+
+func TestHasFinalNewline(t *testing.T) {
+	var c *Conditions
+	if !c.HasFinalNewline("", []byte("a\nb\n")) {
+		t.Error("HasFinalNewline should be true for a file ending with a newline")
+	}
+	if c.HasFinalNewline("", []byte("a\nb")) {
+		t.Error("HasFinalNewline should be false for a file without final newline")
+	}
+	if !c.HasFinalNewline("", []byte{}) {
+		t.Error("HasFinalNewline should be true for an empty file")
+	}
+
+	missing := Transformation{
+		Cond: []Procedure{Procedure{Name: "!HasFinalNewline"}},
+		Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"\n"}}},
+	}
+	if !mustCheckCondition(t, "", []byte("a"), missing) || mustCheckCondition(t, "", []byte("a\n"), missing) {
+		t.Error("checkCondition: only the file without final newline should be selected")
+	}
+}
+
+func TestIsEmptyIsBlank(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-blank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	empty := filepath.Join(dir, "empty.go")
+	blank := filepath.Join(dir, "blank.go")
+	full := filepath.Join(dir, "full.go")
+	ioutil.WriteFile(empty, nil, 0644)
+	ioutil.WriteFile(blank, []byte("\n  \t\r\n\n"), 0644)
+	ioutil.WriteFile(full, []byte("\npackage main\n"), 0644)
+
+	stamp := []Procedure{Procedure{Name: "Insert", Params: []string{"// TODO: implement\n"}}}
+	for pre, expected := range map[string]map[string]string{
+		"IsEmpty":  {empty: "// TODO: implement\n", blank: "\n  \t\r\n\n", full: "\npackage main\n"},
+		"IsBlank":  {empty: "// TODO: implement\n", blank: "\n  \t\r\n\n// TODO: implement\n", full: "\npackage main\n"},
+		"!IsBlank": {empty: "", blank: "\n  \t\r\n\n", full: "\npackage main\n// TODO: implement\n"},
+	} {
+		tdf := T{Transformations: []Transformation{Transformation{Filter: "*.go", Pre: []string{pre}, Proc: stamp}}}
+		for f, content := range expected {
+			if _, res, _, err := processFile(f, tdf); err != nil || string(res) != content {
+				t.Errorf("%s: %s should give %q but found %q, %v", pre, filepath.Base(f), content, res, err)
+			}
+		}
+	}
+}
+
+func TestRepoHasFile(t *testing.T) {
+	withModule, err := ioutil.TempDir("", "seed-module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(withModule)
+	os.MkdirAll(filepath.Join(withModule, "tools", "gen"), 0755)
+	ioutil.WriteFile(filepath.Join(withModule, "tools", "gen", "go.mod"), []byte("module gen\n"), 0644)
+	withoutModule, err := ioutil.TempDir("", "seed-nomodule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(withoutModule)
+	ioutil.WriteFile(filepath.Join(withoutModule, "main.go"), []byte("package main\n"), 0644)
+
+	defer func(dir string) { dirPath = dir }(dirPath)
+	var c *Conditions
+	dirPath = withModule
+	if ok, err := c.RepoHasFile("", nil, "go.mod"); !ok || err != nil {
+		t.Errorf("RepoHasFile should find a nested go.mod (%v)", err)
+	}
+	if ok, _ := c.RepoHasFile("", nil, "tools/*/go.mod"); !ok {
+		t.Error("RepoHasFile should match a relative path")
+	}
+	if ok, _ := c.RepoHasFile("", nil, "go.sum"); ok {
+		t.Error("RepoHasFile should not find a missing file")
+	}
+	// The result is cached for the tree
+	os.Remove(filepath.Join(withModule, "tools", "gen", "go.mod"))
+	if ok, _ := c.RepoHasFile("", nil, "go.mod"); !ok {
+		t.Error("RepoHasFile should cache the result of the walk")
+	}
+
+	dirPath = withoutModule
+	tr := Transformation{Cond: []Procedure{Procedure{Name: "RepoHasFile", Params: []string{"go.mod"}}}}
+	if mustCheckCondition(t, "main.go", nil, tr) {
+		t.Error("checkCondition: a repository without go.mod should not satisfy RepoHasFile")
+	}
+	if _, err := c.RepoHasFile("", nil, "["); err == nil {
+		t.Error("RepoHasFile should reject an invalid pattern")
+	}
+}
+
+func TestContainsString(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-contains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uses := filepath.Join(dir, "uses.go")
+	other := filepath.Join(dir, "other.go")
+	ioutil.WriteFile(uses, []byte("import \"github.com/pkg/errors\"\nold\n"), 0644)
+	ioutil.WriteFile(other, []byte("old\n"), 0644)
+
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.go", Cond: []Procedure{Procedure{Name: "ContainsString", Params: []string{"pkg/errors"}}},
+			Proc: replace},
+	}}
+	for f, expected := range map[string]string{uses: "import \"github.com/pkg/errors\"\nnew\n", other: "old\n"} {
+		_, res, _, err := processFile(f, tdf)
+		if err != nil || string(res) != expected {
+			t.Errorf("ContainsString: %s should give %q but found %q, %v", f, expected, res, err)
+		}
+	}
+
+	tdf.Transformations[0].Cond[0].Name = "NotContains"
+	for f, expected := range map[string]string{uses: "import \"github.com/pkg/errors\"\nold\n", other: "new\n"} {
+		_, res, _, err := processFile(f, tdf)
+		if err != nil || string(res) != expected {
+			t.Errorf("NotContains: %s should give %q but found %q, %v", f, expected, res, err)
+		}
+	}
+}
+
+func TestContainsRegex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-contains-regex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "apps", "legacy"), 0755)
+	web := filepath.Join(dir, "apps", "web.yml")
+	db := filepath.Join(dir, "apps", "legacy", "db.yml")
+	api := filepath.Join(dir, "apps", "api.yml")
+	doc := filepath.Join(dir, "apps", "legacy", "doc.yml")
+	ioutil.WriteFile(web, []byte("apiVersion: apps/v1beta2\nkind: Deployment\n"), 0644)
+	ioutil.WriteFile(db, []byte("kind: Deployment\napiVersion: apps/v1beta1\n"), 0644)
+	ioutil.WriteFile(api, []byte("apiVersion: apps/v1\nkind: Deployment\n"), 0644)
+	ioutil.WriteFile(doc, []byte("# apiVersion: apps/v1beta1 Deployment\n"), 0644)
+
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.yml", Cond: []Procedure{{Name: "ContainsRegex", Params: []string{`(?m)^apiVersion: *apps/v1beta\d$`}}},
+			Proc: []Procedure{{Name: "Replace", Params: []string{"Deployment", "Deployment # migrate"}}}},
+	}}
+	if err := checkPatterns(tdf); err != nil {
+		t.Fatal(err)
+	}
+	count, err := processFiles(context.Background(), mustWalkDir(t, dir, "", ""), tdf, nil)
+	if count != 2 || err != nil {
+		t.Errorf("ContainsRegex: 2 files should be fixed but found %v (%v)", count, err)
+	}
+	expected := map[string]string{
+		web: "apiVersion: apps/v1beta2\nkind: Deployment # migrate\n",
+		db:  "kind: Deployment # migrate\napiVersion: apps/v1beta1\n",
+		api: "apiVersion: apps/v1\nkind: Deployment\n",
+		doc: "# apiVersion: apps/v1beta1 Deployment\n",
+	}
+	for f, content := range expected {
+		if res, _ := ioutil.ReadFile(f); string(res) != content {
+			t.Errorf("ContainsRegex: %s should give %q but found %q", f, content, res)
+		}
+	}
+
+	tdf.Transformations[0].Cond[0].Name = "!ContainsRegex"
+	_, res, _, err := processFile(api, tdf)
+	if err != nil || string(res) != "apiVersion: apps/v1\nkind: Deployment # migrate\n" {
+		t.Errorf("!ContainsRegex should fix the files not matching the regexp but found %q, %v", res, err)
+	}
+
+	tdf.Transformations[0].Cond[0].Params[0] = "apps/(v1"
+	if err := checkPatterns(tdf); err == nil || !strings.Contains(err.Error(), "Invalid ContainsRegex pattern") {
+		t.Errorf("checkPatterns should report the invalid ContainsRegex pattern but found %v", err)
+	}
+}
+
 func (c *Conditions) AlwaysFalse(fileName string, data []byte) bool {
 	return false
 }
@@ -33,40 +268,151 @@ func (c *Conditions) AlwaysFalse(fileName string, data []byte) bool {
 func TestFile(t *testing.T) {
 	tg := Transformation{Filter: "*.go"}
 	tgy := Transformation{Filter: "*.go|*.yml"}
-	matched := checkFileName("test\\src\\bla\\bla\\cmd.go", tg) &&
-		!checkFileName("./test/bloat.java.", tg)
+	matched := mustCheckFileName(t, "test\\src\\bla\\bla\\cmd.go", tg) &&
+		!mustCheckFileName(t, "./test/bloat.java.", tg)
 	if !matched {
 		t.Errorf("The file ./test/cmd.go should match the pattern '*.go' but not 'bloat.java'")
 	}
 
-	matched = checkFileName("./test/cmd.go", tgy) &&
-		checkFileName("./test/conf.yml", tgy) &&
-		!checkFileName("./test/bloat.java.", tgy)
+	matched = mustCheckFileName(t, "./test/cmd.go", tgy) &&
+		mustCheckFileName(t, "./test/conf.yml", tgy) &&
+		!mustCheckFileName(t, "./test/bloat.java.", tgy)
 	if !matched {
 		t.Errorf("The file 'cmd.go' and 'conf.yml' should match the pattern '*.go|*.yml' but not 'bloat.java'")
 	}
 }
 
+func TestFileInNestedDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nested := filepath.Join(dir, "src", "main", "resources", "config")
+	os.MkdirAll(nested, 0755)
+	files := map[string]bool{
+		filepath.Join(dir, "main.go"):           true,
+		filepath.Join(nested, "app.yml"):        true,
+		filepath.Join(nested, "deep", "x.go"):   true,
+		filepath.Join(nested, "app.properties"): false,
+		filepath.Join(dir, "src", "yml"):        false,
+	}
+	os.MkdirAll(filepath.Join(nested, "deep"), 0755)
+	for f := range files {
+		ioutil.WriteFile(f, []byte("old\n"), 0644)
+	}
+
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	for _, tr := range []Transformation{
+		Transformation{Filter: "*.go|*.yml", Proc: replace},
+		Transformation{Include: []string{"*.go", "*.yml"}, Proc: replace},
+		Transformation{Filter: "*.go", Include: []string{"*.yml"}, Proc: replace},
+	} {
+		tdf := T{Transformations: []Transformation{tr}}
+		for f, selected := range files {
+			if mustCheckFileName(t, f, tr) != selected {
+				t.Errorf("%q: %s should be selected: %v", includePatterns(tr), f, selected)
+			}
+			expected := "old\n"
+			if selected {
+				expected = "new\n"
+			}
+			if _, res, _, err := processFile(f, tdf); selected && (err != nil || string(res) != expected) {
+				t.Errorf("%q: %s should give %q but found %q, %v", includePatterns(tr), f, expected, res, err)
+			}
+		}
+	}
+}
+
+func TestFileWithNegatedPatterns(t *testing.T) {
+	for _, tr := range []Transformation{
+		Transformation{Filter: "*.go|!*_test.go"},
+		Transformation{Filter: "!*_test.go|*.go"},
+		Transformation{Include: []string{"*.go", "!*_test.go"}},
+	} {
+		if !mustCheckFileName(t, "src/cmd.go", tr) {
+			t.Errorf("%q should match cmd.go", includePatterns(tr))
+		}
+		if mustCheckFileName(t, "src/cmd_test.go", tr) || mustCheckFileName(t, "src/cmd.java", tr) {
+			t.Errorf("%q should match neither cmd_test.go nor cmd.java", includePatterns(tr))
+		}
+	}
+
+	onlyNegated := Transformation{Filter: "!*.out"}
+	if !mustCheckFileName(t, "src/cmd.go", onlyNegated) || mustCheckFileName(t, "build/seed.out", onlyNegated) {
+		t.Error("'!*.out' should match all the files but the '.out' ones")
+	}
+	if _, err := checkFileName("src/cmd.go", Transformation{Filter: "!["}); err == nil {
+		t.Error("checkFileName should report an invalid pattern")
+	}
+}
+
 func TestProcedures(t *testing.T) {
 	tn := Transformation{Proc: []Procedure{Procedure{Name: "DoNothing"}}}
 	ti := Transformation{Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"bar"}}}}
 
-	res := applyProcs([]byte("foo"), tn)
+	res, _ := applyProcs("", []byte("foo"), tn)
 	if string(res) != "foo" {
 		t.Errorf("Procedure should do nothing, %s was expected but found %s", "foo", res)
 	}
 
-	res = applyProcs([]byte("foo"), ti)
+	res, _ = applyProcs("", []byte("foo"), ti)
 	if string(res) != "foobar" {
 		t.Errorf("Procedure should insert bar, %s was expected but found %s", "foobar", res)
 	}
 
+	// The transformations built without parseTdf are checked when applied
+	missing := Transformation{Proc: []Procedure{Procedure{Name: "Missing"}}}
+	if _, err := applyProcs("", []byte("foo"), missing); err == nil {
+		t.Error("applyProcs should fail on an unknown procedure")
+	}
+	policy := Transformation{Proc: []Procedure{Procedure{Name: "DoNothing", OnError: "retry"}}}
+	if _, err := applyProcs("", []byte("foo"), policy); err == nil {
+		t.Error("applyProcs should fail on an unknown onerror policy")
+	}
+	if _, err := checkCondition("", []byte("foo"), Transformation{Pre: []string{"Missing"}}); err == nil {
+		t.Error("checkCondition should fail on an unknown precondition")
+	}
+
 }
 
 func (p *Procedures) DoNothing(dat []byte) []byte {
 	return dat
 }
 
+func (p *Procedures) AlwaysFail(dat []byte) ([]byte, error) {
+	return []byte("garbage"), fmt.Errorf("injected failure")
+}
+
+func TestOnErrorPolicies(t *testing.T) {
+	procs := func(policy string) Transformation {
+		return Transformation{Proc: []Procedure{
+			Procedure{Name: "AlwaysFail", OnError: policy},
+			Procedure{Name: "Insert", Params: []string{"bar"}},
+		}}
+	}
+
+	for _, policy := range []string{"", "fail"} {
+		res, err := applyProcs("", []byte("foo"), procs(policy))
+		if err == nil {
+			t.Errorf("OnError %q: the failing procedure should abort the transformation", policy)
+		}
+		if string(res) != "foo" {
+			t.Errorf("OnError %q: the data should be left untouched but found %s", policy, res)
+		}
+	}
+
+	for _, policy := range []string{"warn", "skip"} {
+		res, err := applyProcs("", []byte("foo"), procs(policy))
+		if err != nil {
+			t.Errorf("OnError %q: the error should not be returned but found %v", policy, err)
+		}
+		if string(res) != "foobar" {
+			t.Errorf("OnError %q: %s was expected but found %s", policy, "foobar", res)
+		}
+	}
+}
+
 func TestReplace(t *testing.T) {
 	var p *Procedures
 	news := string(p.Replace([]byte("foo"), "foo", "bar", "bar", "toto"))
@@ -75,6 +421,96 @@ func TestReplace(t *testing.T) {
 	}
 }
 
+func TestReplaceIndex(t *testing.T) {
+	var p *Procedures
+	dat := []byte("version: 1\nname: a\nversion: 2\nversion: 3\n")
+	res, err := p.ReplaceIndex(dat, "version:", "appVersion:", "2")
+	if expected := "version: 1\nname: a\nappVersion: 2\nversion: 3\n"; err != nil || string(res) != expected {
+		t.Errorf("ReplaceIndex should replace the second occurrence: %q was expected but found %q, %v", expected, res, err)
+	}
+
+	if res, err = p.ReplaceIndex([]byte("aaaa"), "aa", "b", "2"); err != nil || string(res) != "aab" {
+		t.Errorf("ReplaceIndex should count the non-overlapping occurrences but found %q, %v", res, err)
+	}
+	if res, err = p.ReplaceIndex(dat, "version:", "appVersion:", "4"); err != nil || string(res) != string(dat) {
+		t.Errorf("ReplaceIndex should leave the file untouched for a missing occurrence but found %q, %v", res, err)
+	}
+	for _, index := range []string{"0", "-1", "second"} {
+		if _, err = p.ReplaceIndex(dat, "version:", "appVersion:", index); err == nil {
+			t.Errorf("ReplaceIndex should reject the index %s", index)
+		}
+	}
+
+	strict = true
+	defer func() { strict = false }()
+	if _, err = p.ReplaceIndex(dat, "version:", "appVersion:", "4"); err == nil {
+		t.Error("ReplaceIndex should report a missing occurrence with -strict")
+	}
+}
+
+func TestEnvParams(t *testing.T) {
+	tr := Transformation{Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"BUILD", "$env:SEED_TEST_BUILD_ID"}}}}
+	os.Setenv("SEED_TEST_BUILD_ID", "42")
+	defer os.Unsetenv("SEED_TEST_BUILD_ID")
+	if res, err := applyProcs("", []byte("version 1.0-BUILD"), tr); err != nil || string(res) != "version 1.0-42" {
+		t.Errorf("The param should be read from the environment but found %q, %v", res, err)
+	}
+	// The environment is read each time the procedure runs
+	os.Setenv("SEED_TEST_BUILD_ID", "43")
+	if res, _ := applyProcs("", []byte("BUILD"), tr); string(res) != "43" {
+		t.Errorf("The param should be read again from the environment but found %q", res)
+	}
+
+	escaped := Transformation{Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"BUILD", "$$env:SEED_TEST_BUILD_ID"}}}}
+	if res, _ := applyProcs("", []byte("BUILD"), escaped); string(res) != "$env:SEED_TEST_BUILD_ID" {
+		t.Errorf("An escaped param should be kept literally but found %q", res)
+	}
+
+	os.Unsetenv("SEED_TEST_BUILD_ID")
+	if res, err := applyProcs("", []byte("BUILD"), tr); err != nil || string(res) != "" {
+		t.Errorf("A missing variable should give an empty value but found %q, %v", res, err)
+	}
+	defer func(s bool) { strict = s }(strict)
+	strict = true
+	if _, err := applyProcs("", []byte("BUILD"), tr); err == nil {
+		t.Error("A missing variable should be an error with -strict")
+	}
+}
+
+func TestReplaceFromURL(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/renames.json" {
+			http.Error(w, "failure", http.StatusInternalServerError)
+			return
+		}
+		fetches++
+		fmt.Fprint(w, `{"com.inetpsa": "org.seedstack", "com.inetpsa.fnd": "org.seedstack.seed", "Foo": "Bar"}`)
+	}))
+	defer server.Close()
+
+	var p *Procedures
+	for i := 0; i < 2; i++ {
+		res, err := p.ReplaceFromURL([]byte("import com.inetpsa.fnd.Foo;\nimport com.inetpsa.Baz;\n"), server.URL+"/renames.json")
+		if expected := "import org.seedstack.seed.Bar;\nimport org.seedstack.Baz;\n"; err != nil || string(res) != expected {
+			t.Errorf("ReplaceFromURL: %q was expected but found %q, %v", expected, res, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("The mapping should be fetched once but was fetched %v times", fetches)
+	}
+
+	src := []byte("import com.inetpsa.Foo;\n")
+	if res, err := p.ReplaceFromURL(src, server.URL+"/missing.json"); err != nil || string(res) != string(src) {
+		t.Errorf("ReplaceFromURL should leave the file untouched when the fetch fails but found %q, %v", res, err)
+	}
+	defer func(s bool) { strict = s }(strict)
+	strict = true
+	if _, err := p.ReplaceFromURL(src, server.URL+"/missing.json"); err == nil {
+		t.Error("ReplaceFromURL should report the failed fetch with -strict")
+	}
+}
+
 func TestInsertAndRemove(t *testing.T) {
 	var p *Procedures
 	ori := []byte("foo")
@@ -90,12 +526,391 @@ func TestInsertAndRemove(t *testing.T) {
 	}
 }
 
+func TestWithinCapture(t *testing.T) {
+	var p *Procedures
+	lower := []Procedure{Procedure{Name: "ToLower"}}
+	src := "func DoThis() {}\nfunc DoThat() { DoThis() }\n"
+	expected := "func dothis() {}\nfunc dothat() { DoThis() }\n"
+
+	res, err := p.WithinCapture([]byte(src), lower, `func (\w+)\(`, "1")
+	if err != nil || string(res) != expected {
+		t.Errorf("WithinCapture: %q was expected but found %q, %v", expected, res, err)
+	}
+
+	insert := []Procedure{Procedure{Name: "Insert", Params: []string{"_"}}}
+	res, err = p.WithinCapture([]byte("a=1, b=2"), insert, `(\w)=`, "1")
+	if err != nil || string(res) != "a_=1, b_=2" {
+		t.Errorf("WithinCapture should only modify the capture but found %q, %v", res, err)
+	}
+
+	if _, err = p.WithinCapture([]byte(src), lower, `func (\w+)`, "2"); err == nil {
+		t.Error("WithinCapture should fail for an inexistent capture group")
+	}
+}
+
+func TestWithinCaptureFromTdf(t *testing.T) {
+	tr := Transformation{Proc: []Procedure{Procedure{
+		Name:   "WithinCapture",
+		Params: []string{`name: (\w+)`, "1"},
+		Proc:   []Procedure{Procedure{Name: "ToLower"}},
+	}}}
+
+	res, err := applyProcs("", []byte("name: MyApp\nTitle: MyApp\n"), tr)
+	if err != nil || string(res) != "name: myapp\nTitle: MyApp\n" {
+		t.Errorf("WithinCapture should receive the nested procedures but found %q, %v", res, err)
+	}
+}
+
+func TestLimitBlankLines(t *testing.T) {
+	var p *Procedures
+	res, err := p.LimitBlankLines([]byte("a\n\n\n\nb\n \t\n\nc\n\n"), "1")
+	if err != nil || string(res) != "a\n\nb\n \t\nc\n\n" {
+		t.Errorf("LimitBlankLines should keep one blank line but found %q, %v", res, err)
+	}
+
+	if _, err = p.LimitBlankLines([]byte("a"), "-1"); err == nil {
+		t.Error("LimitBlankLines should reject a negative maximum")
+	}
+}
+
+func TestDeleteLine(t *testing.T) {
+	var p *Procedures
+	res, err := p.DeleteLine([]byte("a\r\n// DEBUG x\r\nb\r\n"), "DEBUG")
+	if err != nil || string(res) != "a\r\nb\r\n" {
+		t.Errorf("DeleteLine should keep the CRLF line endings but found %q, %v", res, err)
+	}
+	if res, err = p.DeleteLine([]byte("a\nb\n"), "DEBUG"); err != nil || string(res) != "a\nb\n" {
+		t.Errorf("DeleteLine should keep the file when no line matches but found %q, %v", res, err)
+	}
+
+	dir, err := ioutil.TempDir("", "seed-delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := filepath.Join(dir, "debug.txt")
+	ioutil.WriteFile(f, []byte("DEBUG a\r\nDEBUG b"), 0644)
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "DeleteLine", Params: []string{"DEBUG"}}}},
+	}}
+	if updated, _, err := fixFile(f, tdf, nil); !updated || err != nil {
+		t.Errorf("fixFile should update the file where every line matches but found %v, %v", updated, err)
+	}
+	if dat, err := ioutil.ReadFile(f); err != nil || len(dat) != 0 {
+		t.Errorf("The file where every line matches should be written empty but found %q, %v", dat, err)
+	}
+}
+
+func TestDeleteLines(t *testing.T) {
+	var p *Procedures
+	src := "a\r\nfmt.Println(x)\r\nb\r\n"
+	res, err := p.DeleteLines([]byte(src), "fmt.Println(")
+	if err != nil || string(res) != "a\r\nb\r\n" {
+		t.Errorf("DeleteLines should match the substring literally and keep CRLF but found %q, %v", res, err)
+	}
+	if res, err = p.DeleteLines([]byte(src), `^\w$`); err != nil || string(res) != "fmt.Println(x)\r\n" {
+		t.Errorf("DeleteLines should match the regexps but found %q, %v", res, err)
+	}
+	if res, err = p.DeleteLines([]byte("aab\na+b\n"), "a+b"); err != nil || string(res) != "a+b\n" {
+		t.Errorf("DeleteLines should not match a valid regexp literally but found %q, %v", res, err)
+	}
+	if res, err = p.DeleteLines([]byte(src), "DEBUG"); err != nil || string(res) != src {
+		t.Errorf("DeleteLines should keep the file when no line matches but found %q, %v", res, err)
+	}
+	if res, err = p.DeleteLines([]byte("DEBUG a\nDEBUG b"), "DEBUG"); err != nil || len(res) != 0 {
+		t.Errorf("DeleteLines should empty the file where every line matches but found %q, %v", res, err)
+	}
+
+	line := Transformation{Mode: lineMode, Proc: []Procedure{Procedure{Name: "DeleteLines", Params: []string{"fmt.Println("}}}}
+	if res, err = applyLines("", []byte(src), line); err != nil || string(res) != "a\r\nb\r\n" {
+		t.Errorf("DeleteLines should be accepted in line mode but found %q, %v", res, err)
+	}
+}
+
+func TestInsertAfter(t *testing.T) {
+	var p *Procedures
+	res, err := p.InsertAfter([]byte("package main\n\nimport \"fmt\"\n"), "^package ", "// generated")
+	if err != nil || string(res) != "package main\n// generated\n\nimport \"fmt\"\n" {
+		t.Errorf("InsertAfter should insert the line after the match but found %q, %v", res, err)
+	}
+
+	conf := "[a]\r\nkey=1\r\n[b]\r\nkey=2\r\n[c]"
+	expected := "[a]\r\n# section\r\nkey=1\r\n[b]\r\n# section\r\nkey=2\r\n[c]\n# section"
+	if res, err = p.InsertAfter([]byte(conf), `^\[\w\]$`, "# section"); err != nil || string(res) != expected {
+		t.Errorf("InsertAfter should insert the line after each match but found %q, %v", res, err)
+	}
+	if res, err = p.InsertAfter(res, `^\[\w\]$`, "# section"); err != nil || string(res) != expected {
+		t.Errorf("InsertAfter should not insert the line again but found %q, %v", res, err)
+	}
+
+	twice := "@Test\nvoid a() {}\n"
+	res, _ = p.InsertAfter([]byte(twice), "@Test", "@Timeout(5)\n@Tag(\"fast\")")
+	if res, err = p.InsertAfter(res, "@Test", "@Timeout(5)\n@Tag(\"fast\")"); err != nil ||
+		string(res) != "@Test\n@Timeout(5)\n@Tag(\"fast\")\nvoid a() {}\n" {
+		t.Errorf("InsertAfter should insert the multi-line text once but found %q, %v", res, err)
+	}
+
+	if _, err = p.InsertAfter([]byte(conf), "[", "x"); err == nil {
+		t.Error("InsertAfter should fail on an invalid regexp")
+	}
+}
+
+func TestPrefixLines(t *testing.T) {
+	var p *Procedures
+	conf := "server:\n  port: 80\n  legacy.host: old\n\n  legacy.port: 81\r\n"
+	commented := "server:\n  port: 80\n  # legacy.host: old\n\n  # legacy.port: 81\r\n"
+	res, err := p.PrefixLines([]byte(conf), "# ", `legacy\.`)
+	if err != nil || string(res) != commented {
+		t.Errorf("PrefixLines should add the prefix after the indentation but found %q, %v", res, err)
+	}
+	if res, err = p.PrefixLines([]byte(commented), "# ", `legacy\.`); err != nil || string(res) != commented {
+		t.Errorf("PrefixLines should not add the prefix twice but found %q, %v", res, err)
+	}
+	if res, err = p.PrefixLines([]byte(commented), "# ", `legacy\.`, "remove"); err != nil || string(res) != conf {
+		t.Errorf("PrefixLines should remove the prefix but found %q, %v", res, err)
+	}
+	if res, err = p.PrefixLines([]byte("a\n\n  b"), "> "); err != nil || string(res) != "> a\n\n  > b" {
+		t.Errorf("PrefixLines should prefix all the non blank lines by default but found %q, %v", res, err)
+	}
+
+	if _, err = p.PrefixLines([]byte(conf), "# ", "", "delete"); err == nil {
+		t.Error("PrefixLines should reject an unknown flag")
+	}
+}
+
+func TestSuffixLines(t *testing.T) {
+	var p *Procedures
+	res, err := p.SuffixLines([]byte("run \\\n  --a\n  --b \\\n"), " \\", "^ *--")
+	if err != nil || string(res) != "run \\\n  --a \\\n  --b \\\n" {
+		t.Errorf("SuffixLines should add the missing suffixes but found %q, %v", res, err)
+	}
+	if res, err = p.SuffixLines([]byte("a;\nb;\r\nc\n"), ";", "", "remove"); err != nil || string(res) != "a\nb\r\nc\n" {
+		t.Errorf("SuffixLines should remove the suffix before the line terminators but found %q, %v", res, err)
+	}
+}
+
+func TestRegexReplace(t *testing.T) {
+	var p *Procedures
+	res, err := p.RegexReplace([]byte("import \"github.com/old/seed\"\nimport \"github.com/old/tools\"\n"),
+		`github\.com/old/(\w+)`, "github.com/new/${1}-v2")
+	if err != nil || string(res) != "import \"github.com/new/seed-v2\"\nimport \"github.com/new/tools-v2\"\n" {
+		t.Errorf("RegexReplace should expand the capture groups but found %q, %v", res, err)
+	}
+	if res, err = p.RegexReplace([]byte("2015-12-25"), `(?P<y>\d+)-(?P<m>\d+)-(?P<d>\d+)`, "$d/$m/$y"); err != nil || string(res) != "25/12/2015" {
+		t.Errorf("RegexReplace should expand the named groups but found %q, %v", res, err)
+	}
+	if _, err = p.RegexReplace([]byte("a"), "(", ""); err == nil {
+		t.Error("RegexReplace should fail on an invalid pattern")
+	}
+}
+
+func TestCheckPatterns(t *testing.T) {
+	valid := T{Transformations: []Transformation{Transformation{Proc: []Procedure{
+		Procedure{Name: "RegexReplace", Params: []string{`(\w+)`, "$1"}},
+		Procedure{Name: "RegexReplace", Params: []string{"$env:PATTERN", "x"}},
+	}}}}
+	if err := checkPatterns(valid); err != nil {
+		t.Errorf("checkPatterns should accept the valid patterns but found %v", err)
+	}
+
+	nested := T{Transformations: []Transformation{
+		Transformation{Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"(", ")"}}}},
+		Transformation{Name: "docs", Proc: []Procedure{Procedure{Name: "OutsideCodeFence", Proc: []Procedure{
+			Procedure{Name: "RegexReplace", Params: []string{"(", "x"}},
+		}}}},
+	}}
+	if err := checkPatterns(nested); err == nil || !strings.Contains(err.Error(), "docs") {
+		t.Errorf("checkPatterns should report the invalid nested pattern of docs but found %v", err)
+	}
+
+	missing := T{Transformations: []Transformation{Transformation{Proc: []Procedure{
+		Procedure{Name: "RegexReplace", Params: []string{"a"}},
+	}}}}
+	if err := checkPatterns(missing); err == nil {
+		t.Error("checkPatterns should report a missing replacement")
+	}
+}
+
+func TestTemplateMatch(t *testing.T) {
+	var p *Procedures
+	pattern := `(?P<month>\d{2})-(?P<day>\d{2})(-(?P<year>\d{4}))?`
+
+	res, err := p.TemplateMatch([]byte("from 12-25 to 01-02-2015."), pattern, "{{.day}}/{{.month}}")
+	if err != nil || string(res) != "from 25/12 to 02/01." {
+		t.Errorf("TemplateMatch: %q was expected but found %q, %v", "from 25/12 to 02/01.", res, err)
+	}
+
+	res, err = p.TemplateMatch([]byte("12-25 01-02-2015"), pattern, `{{.day}}/{{.month}}{{if .year}}/{{.year}}{{end}}`)
+	if err != nil || string(res) != "25/12 02/01/2015" {
+		t.Errorf("TemplateMatch should support conditionals but found %q, %v", res, err)
+	}
+
+	if _, err = p.TemplateMatch([]byte("12-25"), pattern, "{{.unknown}}"); err == nil {
+		t.Error("TemplateMatch should fail on an unknown field")
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	p := &Procedures{fileName: filepath.Join("src", "store", "db.go")}
+	res, err := p.PathTemplate([]byte("// db\npackage main\n"), `(?m)^package \w+$`, "package {{.Dir}}")
+	if err != nil || string(res) != "// db\npackage store\n" {
+		t.Errorf("PathTemplate: %q was expected but found %q, %v", "// db\npackage store\n", res, err)
+	}
+
+	res, err = p.PathTemplate([]byte("name: x"), `name: (?P<name>\w+)`, "name: {{.name}}{{.Ext}} in {{.Base}}")
+	if err != nil || string(res) != "name: x.go in db.go" {
+		t.Errorf("PathTemplate should also give the named groups but found %q, %v", res, err)
+	}
+
+	tr := Transformation{Proc: []Procedure{Procedure{Name: "PathTemplate", Params: []string{`(?m)^package \w+$`, "package {{.Dir}}"}}}}
+	res, err = applyProcs(filepath.Join("api", "v1", "doc.go"), []byte("package main\n"), tr)
+	if err != nil || string(res) != "package v1\n" {
+		t.Errorf("applyProcs should pass the path to PathTemplate but found %q, %v", res, err)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	var p *Procedures
+	res, err := p.Sequence([]byte("id: ? id: ? id: ?"), `\?`, "{{.N}}")
+	if err != nil || string(res) != "id: 1 id: 2 id: 3" {
+		t.Errorf("Sequence: %q was expected but found %q, %v", "id: 1 id: 2 id: 3", res, err)
+	}
+
+	res, err = p.Sequence([]byte("a? b?"), `\?`, "#{{.N}}", "10")
+	if err != nil || string(res) != "a#10 b#11" {
+		t.Errorf("Sequence should start at the given number but found %q, %v", res, err)
+	}
+
+	if _, err = p.Sequence([]byte("?"), `\?`, "{{.N}}", "one"); err == nil {
+		t.Error("Sequence should reject an invalid start")
+	}
+}
+
+func TestUpdateCopyrightYear(t *testing.T) {
+	var p *Procedures
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+	// 2025-06-01
+	os.Setenv("SOURCE_DATE_EPOCH", "1748736000")
+
+	for src, expected := range map[string]string{
+		"// Copyright (c) 2013 by The SeedStack authors.":      "// Copyright (c) 2013-2025 by The SeedStack authors.",
+		"// Copyright (c) 2013-2020 by The SeedStack authors.": "// Copyright (c) 2013-2025 by The SeedStack authors.",
+		"# copyright 2013 - 2020 ACME":                         "# copyright 2013 - 2025 ACME",
+		"// Copyright (c) 2013-2025 by The SeedStack authors.": "// Copyright (c) 2013-2025 by The SeedStack authors.",
+		"// Copyright (c) 2025 by The SeedStack authors.":      "// Copyright (c) 2025 by The SeedStack authors.",
+		"// Released in 2013, no notice here":                  "// Released in 2013, no notice here",
+	} {
+		res, err := p.UpdateCopyrightYear([]byte(src))
+		if err != nil || string(res) != expected {
+			t.Errorf("UpdateCopyrightYear: %q was expected but found %q, %v", expected, res, err)
+		}
+	}
+
+	res, err := p.UpdateCopyrightYear([]byte("(C) 2013 ACME, Copyright 2014"), `\(C\) \d{4}`)
+	if err != nil || string(res) != "(C) 2013-2025 ACME, Copyright 2014" {
+		t.Errorf("UpdateCopyrightYear should only update the notices matching the pattern but found %q, %v", res, err)
+	}
+}
+
+func TestSyncSelfName(t *testing.T) {
+	p := &Procedures{fileName: "src/pkg/render.go"}
+	src := "// Package draw renders things.\n// See draw.go and drawing.go.\npackage draw\n"
+	expected := "// Package render renders things.\n// See render.go and drawing.go.\npackage render\n"
+
+	res, err := p.SyncSelfName([]byte(src), "draw")
+	if err != nil || string(res) != expected {
+		t.Errorf("SyncSelfName: %q was expected but found %q, %v", expected, res, err)
+	}
+
+	tr := Transformation{Proc: []Procedure{Procedure{Name: "SyncSelfName", Params: []string{"draw"}}}}
+	res, err = applyProcs("src/pkg/render.go", []byte(src), tr)
+	if err != nil || string(res) != expected {
+		t.Errorf("SyncSelfName should receive the path of the file but found %q, %v", res, err)
+	}
+}
+
+func TestAnnotateTodos(t *testing.T) {
+	var p *Procedures
+	src := "// TODO: fix me\n// FIXME later\n// TODOS are not markers\n"
+	expected := "// TODO(#1): fix me\n// FIXME(#2) later\n// TODOS are not markers\n"
+
+	res := string(p.AnnotateTodos([]byte(src)))
+	if res != expected {
+		t.Errorf("AnnotateTodos: %q was expected but found %q", expected, res)
+	}
+
+	res = string(p.AnnotateTodos([]byte("// TODO refactor\n"), "bob"))
+	if res != "// TODO(#1 bob) refactor\n" {
+		t.Errorf("AnnotateTodos should append the owner but found %q", res)
+	}
+}
+
+func TestAnnotateTodosIsIdempotent(t *testing.T) {
+	var p *Procedures
+	src := "// TODO(#7): done\n// TODO(bob): mine\n// TODO: new\n"
+	expected := "// TODO(#7): done\n// TODO(bob): mine\n// TODO(#8): new\n"
+
+	res := p.AnnotateTodos([]byte(src))
+	if string(res) != expected {
+		t.Errorf("AnnotateTodos: %q was expected but found %q", expected, res)
+	}
+	if again := p.AnnotateTodos(res); string(again) != expected {
+		t.Errorf("AnnotateTodos should not annotate twice but found %q", again)
+	}
+}
+
+func TestFormatNumbers(t *testing.T) {
+	var p *Procedures
+	src := "total: 1000000\ndelta: -12345\nratio: 1234567.891\nid: 123456\nsmall: 999\n"
+	expected := "total: 1,000,000\ndelta: -12,345\nratio: 1,234,567.891\nid: 123456\nsmall: 999\n"
+
+	res, err := p.FormatNumbers([]byte(src), `(total|delta|ratio|small): -?[0-9.]+`)
+	if err != nil || string(res) != expected {
+		t.Errorf("FormatNumbers: %q was expected but found %q, %v", expected, res, err)
+	}
+
+	res, err = p.FormatNumbers([]byte("total: 1000000"), `total: \d+`, " ")
+	if err != nil || string(res) != "total: 1 000 000" {
+		t.Errorf("FormatNumbers should use the given separator but found %q, %v", res, err)
+	}
+}
+
+func TestStripAnsi(t *testing.T) {
+	var p *Procedures
+	src := "\x1b[1;32mINFO\x1b[0m server \x1b]0;title\x07started on \x1b[4mport\x1b[m 8080\x1b[K\n"
+	expected := "INFO server started on port 8080\n"
+
+	if res := string(p.StripAnsi([]byte(src))); res != expected {
+		t.Errorf("StripAnsi: %q was expected but found %q", expected, res)
+	}
+}
+
+func TestAsciiPunctuation(t *testing.T) {
+	var p *Procedures
+	src := "“Seed” isn’t a ‘tool’ — it’s a stack, see pages 3–5.\n"
+	expected := "\"Seed\" isn't a 'tool' -- it's a stack, see pages 3-5.\n"
+
+	if res := string(p.AsciiPunctuation([]byte(src))); res != expected {
+		t.Errorf("AsciiPunctuation: %q was expected but found %q", expected, res)
+	}
+}
+
 func TestReplaceMavenDependency(t *testing.T) {
 	var p *Procedures
-	news := string(p.ReplaceMavenDependency([]byte(pom), "com.inetpsa.fnd:seed-bom", "org.seedstack:bom", "org.seedstack:bom", "org.seedstack:seedstack-bom"))
+	res, err := p.ReplaceMavenDependency([]byte(pom), "com.inetpsa.fnd:seed-bom", "org.seedstack:bom", "org.seedstack:bom", "org.seedstack:seedstack-bom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	news := string(res)
 	if news != expectedPom {
 		t.Errorf("Procedure should replace 'com.inetpsa.fnd:seed-bom' with 'org.seedstack:seedstack-bom' but found:\n %s", news)
 	}
+	if _, err = p.ReplaceMavenDependency([]byte(pom), "org.seedstack:bom:15.4", "org.seedstack"); err == nil {
+		t.Error("ReplaceMavenDependency should report an invalid dependency")
+	}
+	if _, err = p.ReplaceMavenDependency([]byte(pom), "org.seedstack:bom"); err == nil {
+		t.Error("ReplaceMavenDependency should report a dependency without replacement")
+	}
 }
 
 var pom = `
@@ -134,7 +949,7 @@ func TestMatchDependency(t *testing.T) {
 	old := "com.inetpsa.fnd:seed-bom"
 	new := "org.seedstack:seedstack-bom"
 
-	result := matchDependency(pom, old, new)
+	result := mustMatchDependency(t, pom, old, new)
 	if result != expectedPom {
 		fmt.Println("found:\n" + result)
 		t.Error("Fail to replace maven dependency")
@@ -145,7 +960,7 @@ func TestMatchInexistentDependency(t *testing.T) {
 	old := "com.inetpsa.fnd:seedbom:zzz"
 	new := "org.seedstack:seedstack-bom:yyy"
 
-	result := matchDependency(pom, old, new)
+	result := mustMatchDependency(t, pom, old, new)
 	if result != pom {
 		t.Error("Don't update pom when the dep doesn't match:\n - orig\n%s- updated\n%s", pom, result)
 	}
@@ -171,7 +986,7 @@ func TestMatchDependencyWithVersion(t *testing.T) {
 	old := "com.inetpsa.fnd:seed-bom:14.11"
 	new := "org.seedstack:seedstack-bom:15.4-M2-SNAPSHOT"
 
-	result := matchDependency(pom, old, new)
+	result := mustMatchDependency(t, pom, old, new)
 	if result != expectedPomWithVersion {
 		fmt.Println("found:\n" + result)
 		t.Error("Fail to replace maven dependency with version")
@@ -180,7 +995,11 @@ func TestMatchDependencyWithVersion(t *testing.T) {
 
 func TestReplaceMavenDependencyWithVersion(t *testing.T) {
 	var p *Procedures
-	news := string(p.ReplaceMavenDependency([]byte(pom), "com.inetpsa.fnd:seed-bom:14.11", "org.seedstack:bom:15.4", "org.seedstack:bom:15.4", "org.seedstack:seedstack-bom:15.4-M2-SNAPSHOT"))
+	res, err := p.ReplaceMavenDependency([]byte(pom), "com.inetpsa.fnd:seed-bom:14.11", "org.seedstack:bom:15.4", "org.seedstack:bom:15.4", "org.seedstack:seedstack-bom:15.4-M2-SNAPSHOT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	news := string(res)
 	if news != expectedPomWithVersion {
 		t.Errorf("Procedure should replace 'com.inetpsa.fnd:seed-bom:14.11' with 'org.seedstack:seedstack-bom:15.4-M2-SNAPSHOT' but found:\n %s", news)
 	}
@@ -230,7 +1049,7 @@ func TestMatchDependencyWithVersionAndProps(t *testing.T) {
 	old := "com.inetpsa.fnd:seed-bom:14.11"
 	new := "org.seedstack:seedstack-bom:15.4-M2-SNAPSHOT"
 
-	result := matchDependency(pomWithProperty, old, new)
+	result := mustMatchDependency(t, pomWithProperty, old, new)
 	if result != expectedPomWithProperty {
 		fmt.Println("found:\n" + result)
 		t.Error("Fail to replace maven dependency with version")
@@ -256,7 +1075,7 @@ func TestMatchDependencyWithRemovedVersion(t *testing.T) {
 	old := "com.inetpsa.fnd:seed-bom:*"
 	new := "org.seedstack:seedstack-bom"
 
-	result := matchDependency(pom, old, new)
+	result := mustMatchDependency(t, pom, old, new)
 	if result != expectedPomWithRemovedVersion {
 		fmt.Println("found:\n" + result)
 		t.Error("Fail to replace maven dependency and removing its version")
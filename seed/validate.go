@@ -0,0 +1,198 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// The methods of Procedures and Conditions are the registry of the procedures
+// and preconditions a transformation file can use.
+var (
+	procedureType = reflect.TypeOf(&Procedures{})
+	conditionType = reflect.TypeOf(&Conditions{})
+)
+
+// lookupProcedure returns the method of the procedure with the given name.
+func lookupProcedure(name string) (reflect.Method, bool) {
+	return procedureType.MethodByName(name)
+}
+
+// lookupCondition returns the method of the precondition with the given name,
+// ignoring the "!" of the negated preconditions.
+func lookupCondition(name string) (reflect.Method, bool) {
+	return conditionType.MethodByName(strings.TrimPrefix(name, "!"))
+}
+
+// tdfProblem is an error found in a transformation file by validate.
+type tdfProblem struct {
+	index int    // index of the transformation
+	rule  string // name of the transformation, if any
+	name  string // procedure or precondition, empty for the transformation itself
+	msg   string
+}
+
+func (p tdfProblem) String() string {
+	where := fmt.Sprintf("transformation %v", p.index+1)
+	if p.rule != "" {
+		where += fmt.Sprintf(" (%s)", p.rule)
+	}
+	if p.name != "" {
+		where += ", " + p.name
+	}
+	return where + ": " + p.msg
+}
+
+// tdfProblems rejects a transformation file with problems when it is parsed.
+type tdfProblems []tdfProblem
+
+func (p tdfProblems) Error() string {
+	msgs := make([]string, len(p))
+	for i, problem := range p {
+		msgs[i] = problem.String()
+	}
+	return "Invalid transformation file:\n" + strings.Join(msgs, "\n")
+}
+
+// validate checks the transformation file given by -t and prints its problems.
+// It fails with the exit code 1 when a problem is found.
+func validate() error {
+	dat, _, format, err := readTransPath()
+	if err != nil {
+		return err
+	}
+	t, err := prepareTdf(dat, format)
+	if err != nil {
+		return err
+	}
+	problems := validateTdf(t)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%v problems found in %s\n", len(problems), transPath)
+		return exitError{code: 1}
+	}
+	fmt.Printf("%s is valid\n", transPath)
+	return nil
+}
+
+// validateTdf returns the structural problems of the transformations: the
+// unknown procedures and preconditions, the wrong numbers of params, the
+// invalid patterns and the procedures which cannot be used in line mode.
+func validateTdf(t T) []tdfProblem {
+	var problems []tdfProblem
+	for i, tr := range t.Transformations {
+		report := func(name, msg string) {
+			problems = append(problems, tdfProblem{index: i, rule: tr.Name, name: name, msg: msg})
+		}
+
+		for _, patt := range includePatterns(tr) {
+			if _, err := filepath.Match(strings.TrimPrefix(patt, "!"), ""); err != nil {
+				report("", fmt.Sprintf("invalid file pattern %q: %v", patt, err))
+			}
+		}
+		if tr.Mode != "" && tr.Mode != lineMode {
+			report("", fmt.Sprintf("unknown mode %q, expected %s", tr.Mode, lineMode))
+		} else if tr.Mode == lineMode {
+			if err := checkLineMode(tr); err != nil {
+				report("", err.Error())
+			}
+		}
+
+		conds := make([]Procedure, 0, len(tr.Pre)+len(tr.Cond))
+		for _, pre := range tr.Pre {
+			conds = append(conds, Procedure{Name: pre})
+		}
+		for _, cond := range append(conds, tr.Cond...) {
+			for _, msg := range validateCondition(cond) {
+				report(cond.Name, msg)
+			}
+		}
+
+		var check func(procs []Procedure)
+		check = func(procs []Procedure) {
+			for _, proc := range procs {
+				for _, msg := range validateProcedure(proc) {
+					report(proc.Name, msg)
+				}
+				check(proc.Proc)
+			}
+		}
+		check(tr.Proc)
+	}
+	return problems
+}
+
+// validateCondition returns the problems of a precondition.
+func validateCondition(cond Procedure) []string {
+	m, ok := lookupCondition(cond.Name)
+	if !ok {
+		return []string{"unknown precondition"}
+	}
+	var msgs []string
+	if msg := checkParamCount(m.Type, 3, len(cond.Params)); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	if m.Name == "ContainsRegex" && len(cond.Params) > 0 {
+		if _, err := compileRegexp(cond.Params[0]); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid pattern: %v", err))
+		}
+	}
+	return msgs
+}
+
+// validateProcedure returns the problems of a procedure, without its nested ones.
+func validateProcedure(proc Procedure) []string {
+	m, ok := lookupProcedure(proc.Name)
+	if !ok {
+		return []string{"unknown procedure"}
+	}
+	var msgs []string
+	construct := m.Type.NumIn() > 2 && m.Type.In(2) == procListType
+	args := 2
+	if construct {
+		args++
+	} else if len(proc.Proc) > 0 {
+		msgs = append(msgs, "takes no nested procedures")
+	}
+	if msg := checkParamCount(m.Type, args, len(proc.Params)); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	if proc.OnError != "" && proc.OnError != onErrorFail && proc.OnError != onErrorWarn && proc.OnError != onErrorSkip {
+		msgs = append(msgs, fmt.Sprintf("unknown onerror policy %q, expected fail, warn or skip", proc.OnError))
+	}
+	if patternProcs[proc.Name] && len(proc.Params) > 0 && !strings.HasPrefix(proc.Params[0], envParamPrefix) {
+		if _, err := compileRegexp(proc.Params[0]); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid pattern: %v", err))
+		}
+	}
+	return msgs
+}
+
+// checkParamCount tells whether the method, whose first args arguments are the
+// receiver and the values passed by seed, accepts n params. It returns an empty
+// string when it does, the problem otherwise.
+func checkParamCount(method reflect.Type, args, n int) string {
+	count := method.NumIn() - args
+	if method.IsVariadic() {
+		if n < count-1 {
+			return fmt.Sprintf("expects at least %v params but found %v", count-1, n)
+		}
+		return ""
+	}
+	if n != count {
+		return fmt.Sprintf("expects %v params but found %v", count, n)
+	}
+	return ""
+}
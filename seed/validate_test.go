@@ -0,0 +1,76 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var invalidTdf = `transformations:
+  - name: rename
+    filter: "*.go|[a"
+    pre: [ HasFinalNewline, "!IsMissing" ]
+    cond:
+      - name: ContainsRegex
+        params: [ "(" ]
+    proc:
+      - name: RegexReplace
+        params: [ "a(" , "b" ]
+      - name: ToLower
+        params: [ "x" ]
+      - name: WithinCapture
+        params: [ "v(.*)", "1" ]
+        proc:
+          - name: Frobnicate
+          - name: InsertAfter
+            params: [ "x" ]
+  - mode: line
+    proc:
+      - name: LimitBlankLines
+        params: [ "1" ]
+        onerror: ignore
+      - name: ToLower
+        proc:
+          - name: StripAnsi
+`
+
+func TestValidateTdf(t *testing.T) {
+	tdf, err := prepareTdf([]byte(invalidTdf), "yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problems []string
+	for _, p := range validateTdf(tdf) {
+		problems = append(problems, p.String())
+	}
+	expected := []string{
+		`transformation 1 (rename): invalid file pattern "[a": syntax error in pattern`,
+		`transformation 1 (rename), !IsMissing: unknown precondition`,
+		`transformation 1 (rename), ContainsRegex: invalid pattern: error parsing regexp: missing closing ): ` + "`(`",
+		`transformation 1 (rename), RegexReplace: invalid pattern: error parsing regexp: missing closing ): ` + "`a(`",
+		`transformation 1 (rename), ToLower: expects 0 params but found 1`,
+		`transformation 1 (rename), Frobnicate: unknown procedure`,
+		`transformation 1 (rename), InsertAfter: expects 2 params but found 1`,
+		`transformation 2: LimitBlankLines needs the whole file and cannot be used in line mode`,
+		`transformation 2, LimitBlankLines: unknown onerror policy "ignore", expected fail, warn or skip`,
+		`transformation 2, ToLower: takes no nested procedures`,
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("validateTdf: expected\n%q\nbut found\n%q", expected, problems)
+	}
+
+	tdf, err = prepareTdf([]byte(tdfYml), "yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := validateTdf(tdf); len(problems) != 0 {
+		t.Errorf("validateTdf should find no problem in a valid file but found %v", problems)
+	}
+}
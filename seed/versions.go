@@ -0,0 +1,94 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// versionOperators are the operators written by NormalizeVersions for each mode.
+var versionOperators = map[string]string{
+	"pin":        "==",
+	"compatible": "~=",
+	"caret":      "^",
+	"minimum":    ">=",
+}
+
+// requirementRegex matches a dependency line with version constraints, e.g.
+// "requests[security] >= 2.8, < 3 ; python_version > '3.6'  # http", capturing
+// the indentation, the name, the constraints and what follows them: the
+// environment markers, a comment or a line continuation.
+var requirementRegex = regexp.MustCompile(`^(\s*)([A-Za-z0-9][A-Za-z0-9._\-]*(?:\[[^\]]*\])?)\s*` +
+	`((?:===|==|~=|!=|>=|<=|>|<|\^|~)[^;#\\]*?)(\s*(?:\\\s*|[;#].*)?)$`)
+
+// versionConstraintRegex splits a constraint into its operator and its version.
+var versionConstraintRegex = regexp.MustCompile(`^(===|==|~=|!=|>=|<=|>|<|\^|~)\s*(\S+)$`)
+
+// NormalizeVersions rewrites the version constraints of the dependencies of a
+// requirements.txt style file to a single constraint on their lower version:
+// "==1.2" with the "pin" mode, "~=1.2" with "compatible", "^1.2" with "caret" or
+// ">=1.2" with "minimum". The lower version is the one of the first constraint
+// with ==, ~=, >=, ^ or ~, e.g. "django>=3.2,<4" is pinned to "django==3.2". An
+// optional pattern selects the dependencies by name. The comments, the markers,
+// the other lines and the dependencies without a lower version or with a
+// wildcard are left intact.
+//
+// proc:
+//  -
+//    name: NormalizeVersions
+//    params: ["pin", "^django"]
+func (p *Procedures) NormalizeVersions(dat []byte, mode string, pattern ...string) ([]byte, error) {
+	operator, ok := versionOperators[mode]
+	if !ok {
+		return dat, fmt.Errorf("unknown mode %q, expected pin, compatible, caret or minimum", mode)
+	}
+	var names *regexp.Regexp
+	if len(pattern) > 0 {
+		var err error
+		if names, err = compileRegexp(pattern[0]); err != nil {
+			return dat, fmt.Errorf("invalid dependency pattern: %v", err)
+		}
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		m := requirementRegex.FindStringSubmatch(content)
+		if m == nil || (names != nil && !names.MatchString(m[2])) {
+			continue
+		}
+		version := lowerVersion(m[3])
+		if version == "" {
+			continue
+		}
+		lines[i] = m[1] + m[2] + operator + version + m[4] + line[len(content):]
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// lowerVersion returns the version of the first constraint giving a lower
+// bound, or an empty string. A prefix match like "==1.2.*" gives no version.
+func lowerVersion(constraints string) string {
+	for _, constraint := range strings.Split(constraints, ",") {
+		m := versionConstraintRegex.FindStringSubmatch(strings.TrimSpace(constraint))
+		if m == nil {
+			return ""
+		}
+		switch m[1] {
+		case "==", "===", "~=", ">=", "^", "~":
+			if strings.Contains(m[2], "*") {
+				return ""
+			}
+			return m[2]
+		}
+	}
+	return ""
+}
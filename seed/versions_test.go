@@ -0,0 +1,58 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+)
+
+var requirements = `# Web
+-r base.txt
+Django >= 3.2, < 4  # LTS
+requests[security]~=2.8 ; python_version > "3.6"
+flask
+numpy==1.21.*
+pytest<8
+urllib3>=1.26 \
+    --hash=sha256:abc
+`
+
+func TestNormalizeVersions(t *testing.T) {
+	var p *Procedures
+	res, err := p.NormalizeVersions([]byte(requirements), "pin")
+	expected := `# Web
+-r base.txt
+Django==3.2  # LTS
+requests[security]==2.8 ; python_version > "3.6"
+flask
+numpy==1.21.*
+pytest<8
+urllib3==1.26 \
+    --hash=sha256:abc
+`
+	if err != nil || string(res) != expected {
+		t.Errorf("NormalizeVersions should pin the loose constraints: expected\n%s\nbut found %v\n%s", expected, err, res)
+	}
+
+	res, err = p.NormalizeVersions([]byte("Django>=3.2,<4\r\nrequests==2.8\r\n"), "compatible", "(?i)^django$")
+	if expected := "Django~=3.2\r\nrequests==2.8\r\n"; err != nil || string(res) != expected {
+		t.Errorf("NormalizeVersions should only rewrite the selected dependencies: %q was expected but found %q, %v",
+			expected, res, err)
+	}
+	if res, err = p.NormalizeVersions([]byte("lodash==4.17.21"), "caret"); err != nil || string(res) != "lodash^4.17.21" {
+		t.Errorf("NormalizeVersions should loosen to a caret constraint but found %q, %v", res, err)
+	}
+
+	if _, err = p.NormalizeVersions([]byte(requirements), "exact"); err == nil {
+		t.Error("NormalizeVersions should reject an unknown mode")
+	}
+	if _, err = p.NormalizeVersions([]byte(requirements), "pin", "("); err == nil {
+		t.Error("NormalizeVersions should reject an invalid dependency pattern")
+	}
+}
@@ -10,56 +10,131 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-func walkDir(root string, excludes string, tdfPath string) []string {
+// walkDir returns the files of the tree, except the excluded directories and files
+// and the transformation file, if any. The excludes are glob patterns separated by
+// "|", e.g. "node_modules|.git|vendor|*.min.js", matched against the base name of
+// the directories and files, or against their path relative to the root for the
+// patterns with a "/", e.g. "docs/generated". With -gitignore, the paths ignored
+// by the .gitignore files and the .git directories are skipped too, and with
+// -backup the backup files, so that they are never fixed and backed up again. It
+// fails on the first directory which cannot be walked.
+func walkDir(root string, excludes string, tdfPath string) ([]string, error) {
 	var files []string
+	if tdfPath != "" {
+		absPath, err := filepath.Abs(tdfPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve the transformation file path %s: %v", tdfPath, err)
+		}
+		tdfPath = absPath
+	}
+	var ignore *ignoreRules
+	if gitignore {
+		var err error
+		if ignore, err = newIgnoreRules(root); err != nil {
+			return nil, fmt.Errorf("Failed to read the .gitignore files of %s: %v", root, err)
+		}
+	}
 	if vverbose {
-		fmt.Println("Excluded packages:")
+		fmt.Fprintln(logOutput(), "Excluded packages:")
 	}
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Fatalf("Failed to walk in %s due to: %s", path, err)
-		}
-		if info.IsDir() {
-			// Global exclusion of directories
-			for _, patt := range strings.Split(excludes, "|") {
-				match, err := filepath.Match(patt, filepath.Base(path))
-				if err != nil {
-					log.Fatalf("Failed to parse pattern: %s\n%v", excludes, err)
-				}
-				if match {
-					if vverbose {
-						fmt.Printf("\t%s\n", info.Name())
-					}
-					return filepath.SkipDir
-				}
+			return fmt.Errorf("Failed to walk in %s due to: %s", path, err)
+		}
+		excluded, err := isExcluded(root, path, excludes)
+		if err != nil {
+			return err
+		}
+		if excluded || ignore != nil &&
+			(ignore.ignored(path, info.IsDir()) || info.IsDir() && info.Name() == ".git") {
+			if vverbose {
+				fmt.Fprintf(logOutput(), "\t%s\n", info.Name())
 			}
-		} else {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && ignore != nil {
+			// The rules of the directory apply to its content
+			if err := ignore.load(path); err != nil {
+				return fmt.Errorf("Failed to read the .gitignore file of %s: %v", path, err)
+			}
+		}
+		if !info.IsDir() {
 			// Construct the list of files to scan
 			// but skip the transformation file if present
-			if info.Name() != filepath.Base(tdfPath) {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("Failed to resolve the path %s: %v", path, err)
+			}
+			if _, _, isBackup := backupOf(path, backupSuffix); backup && isBackup {
+				return nil
+			}
+			if absPath != tdfPath {
 				files = append(files, path)
 			}
 		}
-
-		return err
+		return nil
 	})
 
 	if vverbose {
-		fmt.Println("---")
+		fmt.Fprintln(logOutput(), "---")
 	}
 
 	if err != nil {
-		log.Fatalf("Problem walking to the file or directory:\n %v\n", err)
+		return nil, fmt.Errorf("Problem walking to the file or directory:\n %v", err)
 	}
-	return files
+	return files, nil
+}
+
+// filesToFix lists the files to transform. A single file, e.g. given by an
+// editor on save, is transformed alone without walking its directory.
+func filesToFix(path string, excludes string, tdfPath string) ([]string, error) {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return []string{path}, nil
+	}
+	return walkDir(path, excludes, tdfPath)
+}
+
+// isExcluded tells whether the path of the tree matches one of the exclude patterns.
+func isExcluded(root, path, excludes string) (bool, error) {
+	for _, patt := range strings.Split(excludes, "|") {
+		if patt == "" {
+			continue
+		}
+		name := filepath.Base(path)
+		if strings.Contains(patt, "/") {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				continue
+			}
+			name = filepath.ToSlash(rel)
+		}
+		match, err := filepath.Match(patt, name)
+		if err != nil {
+			return false, fmt.Errorf("Failed to parse pattern: %s\n%v", excludes, err)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func shortPath(path string) string {
@@ -76,75 +151,396 @@ func shortPath(path string) string {
 	return relPath
 }
 
-func processFiles(files []string, transformations T) int {
-	count := 0
-	done := make(chan string, len(files))
+// fileErrors collects the errors of the files processed concurrently.
+type fileErrors struct {
+	mu     sync.Mutex
+	errors []fileError
+}
+
+type fileError struct {
+	path string
+	err  error
+}
 
-	for _, f := range files {
+func (e *fileErrors) add(path string, err error) {
+	e.mu.Lock()
+	e.errors = append(e.errors, fileError{path, err})
+	e.mu.Unlock()
+}
 
-		go func(filePath string) {
-			if verbose {
-				fmt.Printf("Check file %s\n", shortPath(filePath))
-			}
+// err returns the collected errors sorted by file path, or nil if there is none.
+func (e *fileErrors) err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.errors) == 0 {
+		return nil
+	}
+	errors := append([]fileError(nil), e.errors...)
+	sort.Sort(byPath(errors))
+	return failedFiles(errors)
+}
 
-			origDat, data := processFile(filePath, transformations)
-			if bytes.Compare(origDat, data) != 0 {
+type byPath []fileError
 
-				err := ioutil.WriteFile(filePath, data, 0644)
-				if err != nil {
-					fmt.Printf("Error writting file %s\n", filePath)
-				}
+func (b byPath) Len() int           { return len(b) }
+func (b byPath) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byPath) Less(i, j int) bool { return b[i].path < b[j].path }
 
-				count++
+// failedFiles reports the errors of each file followed by the number of failed files.
+type failedFiles []fileError
 
-				if verbose {
-					fmt.Printf("Updated file %s\n", shortPath(filePath))
-				}
+func (f failedFiles) Error() string {
+	var buf bytes.Buffer
+	for _, e := range f {
+		fmt.Fprintf(&buf, "%s: %v\n", shortPath(e.path), e.err)
+	}
+	if len(f) == 1 {
+		buf.WriteString("1 file failed")
+	} else {
+		fmt.Fprintf(&buf, "%v files failed", len(f))
+	}
+	return buf.String()
+}
 
-			} else if vverbose {
-				fmt.Printf("No update for %s\n", filePath)
-			}
+// processFiles applies the transformations to the files concurrently, -j files at a
+// time, and returns the number of updated files. The errors of all the files are
+// returned together. What the transformations did to each file is collected when
+// report is not nil. With -events jsonl, an event is printed as soon as each file is
+// processed. When the context is canceled, the files in progress are finished
+// but no other file is processed, and an interruptedError is returned.
+func processFiles(ctx context.Context, files []string, transformations T, report *runReport) (int, error) {
+	count, processed := 0, 0
+	errs := &fileErrors{}
+	done := make(chan fileStatus, len(files))
+	atomic.StoreInt64(&sharedSequence, 0)
+	var ew *eventWriter
+	if events != "" {
+		ew = newEventWriter(os.Stdout)
+		defer ew.close()
+	}
 
-			done <- "ok"
-		}(f)
+	process := func(filePath string) {
+		select {
+		case <-ctx.Done():
+			done <- fileStatus{}
+			return
+		default:
+		}
+		if verbose {
+			fmt.Fprintf(logOutput(), "Check file %s\n", shortPath(filePath))
+		}
+
+		start := time.Now()
+		updated, firings, err := fixFile(filePath, transformations, report)
+		if ew != nil {
+			ew.send(newFileEvent(filePath, transformations, updated, firings, time.Since(start), err))
+		}
+		if err != nil {
+			errs.add(filePath, err)
+		} else if updated && verbose {
+			fmt.Fprintf(logOutput(), "Updated file %s\n", shortPath(filePath))
+		} else if !updated && vverbose {
+			fmt.Fprintf(logOutput(), "No update for %s\n", filePath)
+		}
+
+		done <- fileStatus{processed: true, updated: updated}
+	}
+
+	// The files are processed one at a time in path order for the shared sequence
+	if sortFiles || globalSequence {
+		sorted := append([]string(nil), files...)
+		sort.Strings(sorted)
+		for _, f := range sorted {
+			process(f)
+		}
+	} else {
+		// A bounded number of workers keeps the number of open files low
+		paths := make(chan string)
+		for i := 0; i < workers(len(files)); i++ {
+			go func() {
+				for f := range paths {
+					process(f)
+				}
+			}()
+		}
+		for _, f := range files {
+			paths <- f
+		}
+		close(paths)
 	}
 
 	for _ = range files {
-		<-done
+		status := <-done
+		if status.processed {
+			processed++
+		}
+		if status.updated {
+			count++
+		}
 	}
 	if vverbose {
-		fmt.Printf("---\n\nChecked %v files\n\n", len(files))
+		fmt.Fprintf(logOutput(), "---\n\nChecked %v files\n\n", processed)
+	}
+	if processed < len(files) {
+		return count, interruptedError{processed, len(files), errs.err()}
 	}
-	return count
+	return count, errs.err()
 }
 
-func processFile(filePath string, t T) ([]byte, []byte) {
+// workers returns the number of files processed at the same time, from the -j
+// flag, at least one and at most the number of files.
+func workers(files int) int {
+	n := jobs
+	if n < 1 {
+		n = 1
+	}
+	if n > files {
+		n = files
+	}
+	return n
+}
+
+// fileStatus tells whether a file was processed, or skipped after an
+// interruption, and whether it was updated.
+type fileStatus struct {
+	processed, updated bool
+}
+
+// interruptedError reports a run stopped before all the files were processed,
+// along with the errors of the processed files, if any.
+type interruptedError struct {
+	processed, total int
+	err              error
+}
+
+func (e interruptedError) Error() string {
+	msg := fmt.Sprintf("interrupted after %v/%v files, the other files were not modified", e.processed, e.total)
+	if e.err != nil {
+		msg = e.err.Error() + "\n" + msg
+	}
+	return msg
+}
+
+// interruptContext returns a context canceled on SIGINT or SIGTERM, so that the
+// files in progress are finished before exiting. The returned function stops
+// listening to the signals.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "\nReceived %v, finishing the files in progress...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// fixFile transforms the file and writes it if its content changed, unless in check
+// or dry-run mode. In dry-run mode, the file and its number of added and removed
+// lines are printed instead. With -backup, the original content is saved first. The
+// files only transformed in line mode are streamed, the others, and all of them with
+// -backup, are processed in memory. The written files keep their permissions and
+// are replaced atomically, through their symlinks, by a new file: the hard links
+// to them are broken and their owner and group are not kept.
+// It returns what the transformations did to the file, unless it was streamed.
+func fixFile(filePath string, t T, report *runReport) (bool, []firing, error) {
+	if !check && !dryRun && !backup && report == nil && events == "" && canStream(filePath, t) {
+		updated, err := streamFile(filePath, t)
+		return updated, nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return false, nil, err
+	}
+	origDat, data, firings, err := processFile(filePath, t)
+	if err != nil {
+		return false, firings, err
+	}
+	if report != nil {
+		report.add(filePath, t, firings)
+		if warnLong > 0 {
+			if err = report.checkLength(filePath, origDat); err != nil {
+				return false, firings, err
+			}
+		}
+	}
+	if bytes.Compare(origDat, data) == 0 {
+		return false, firings, nil
+	}
+	if report != nil && showDiff {
+		report.addDiff(filePath, unifiedDiff(origDat, data, shortPath(filePath), diffContext))
+	}
+	if dryRun {
+		added, removed := diffStat(origDat, data)
+		fmt.Fprintf(logOutput(), "Would fix %s (+%v -%v)\n", shortPath(filePath), added, removed)
+	}
+	if check || dryRun {
+		return true, firings, nil
+	}
+	if backup {
+		if _, err = writeBackup(filePath, origDat, backupSuffix); err != nil {
+			return false, firings, fmt.Errorf("Error writting the backup: %v", err)
+		}
+	}
+	_, err = writeFileAtomic(filePath, info.Mode().Perm(), func(w io.Writer) (bool, error) {
+		_, err := w.Write(data)
+		return true, err
+	})
+	if err != nil {
+		return false, firings, fmt.Errorf("Error writting file: %v", err)
+	}
+	return true, firings, nil
+}
+
+// writeFileAtomic calls write with a temporary file of the directory of the file,
+// which then replaces the file if write returns true, so that a run killed while
+// writing never leaves a truncated file. A symlink is resolved first, so that its
+// target is replaced and the link kept. The file gets the given permissions. The
+// temporary file is removed when it is not kept or on error. It tells whether the
+// file was replaced.
+func writeFileAtomic(filePath string, perm os.FileMode, write func(w io.Writer) (bool, error)) (bool, error) {
+	if target, err := filepath.EvalSymlinks(filePath); err == nil {
+		filePath = target
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".")
+	if err != nil {
+		return false, err
+	}
+	keep, err := write(out)
+	if err == nil && keep {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && keep {
+		if err = os.Chmod(out.Name(), perm); err == nil {
+			err = os.Rename(out.Name(), filePath)
+		}
+	}
+	if err != nil || !keep {
+		os.Remove(out.Name())
+	}
+	return keep && err == nil, err
+}
+
+// processFile applies the transformations to the file and returns its original and
+// transformed content, with what each transformation did. In fixpoint mode, the
+// transformations are applied again and again until the content does not change
+// anymore, or fail after maxIterations.
+func processFile(filePath string, t T) ([]byte, []byte, []firing, error) {
 	var origDat []byte
-	var data []byte
 	for _, transf := range t.Transformations {
-		if checkFileName(filePath, transf) {
-			// Initialize the origine data the first time
-			if len(origDat) == 0 {
-				dat, err := ioutil.ReadFile(filePath)
-				if err != nil {
-					fmt.Errorf("Error reading file %s\n", filePath)
-				}
-				data = dat
-				origDat = dat
+		selected, err := checkFileName(filePath, transf)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if selected {
+			dat, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				// The file is never written back without its content
+				return nil, nil, nil, fmt.Errorf("Error reading file: %v", err)
 			}
-
-			// If preconditions matche then apply the transformations
-			if checkCondition(filePath, data, transf) {
+			if !includeBinary && isBinary(dat) {
 				if vverbose {
-					fmt.Printf("Apply tranformation to %s\n", filePath)
+					fmt.Fprintf(logOutput(), "Skip binary file %s\n", filePath)
 				}
-				data = applyProcs(data, transf)
+				return nil, nil, nil, nil
+			}
+			origDat = dat
+			break
+		}
+	}
+	if origDat == nil {
+		return nil, nil, nil, nil
+	}
+
+	data, firings, err := applyTransformations(filePath, origDat, t)
+	for i := 1; fixpoint && err == nil && bytes.Compare(origDat, data) != 0; i++ {
+		if i >= maxIterations {
+			return origDat, origDat, firings, fmt.Errorf("No fixed point reached after %v iterations, "+
+				"a transformation may never terminate", maxIterations)
+		}
+		previous := data
+		var pass []firing
+		data, pass, err = applyTransformations(filePath, data, t)
+		firings = append(firings, pass...)
+		if bytes.Compare(previous, data) == 0 {
+			break
+		}
+	}
+	if err != nil {
+		return origDat, origDat, firings, err
+	}
+	return origDat, data, firings, nil
+}
+
+// binarySniffLen is the number of bytes looked at to detect a binary file, as git does.
+const binarySniffLen = 8000
+
+// isBinary tells whether the content is binary, i.e. has a NUL byte in its first
+// binarySniffLen bytes, like git. Binary files, e.g. images or archives, are left
+// untouched unless -include-binary is given.
+func isBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+// applyTransformations applies each transformation matching the file to its content
+// and tells what they did.
+func applyTransformations(filePath string, data []byte, t T) ([]byte, []firing, error) {
+	var firings []firing
+	for i, transf := range t.Transformations {
+		selected, err := checkFileName(filePath, transf)
+		if err != nil {
+			return data, firings, err
+		}
+		if !selected {
+			continue
+		}
+
+		// If preconditions matche then apply the transformations
+		matched, err := checkCondition(filePath, data, transf)
+		if err != nil {
+			return data, firings, err
+		}
+		if matched {
+			if vverbose {
+				fmt.Fprintf(logOutput(), "Apply tranformation to %s\n", filePath)
+			}
+			before := data
+			if transf.Mode == lineMode {
+				data, err = applyLines(filePath, data, transf)
 			} else {
-				if vverbose {
-					fmt.Printf("%s doesn't match the preconditions\n", filePath)
-				}
+				data, err = applyProcs(filePath, data, transf)
+			}
+			if err != nil {
+				return data, firings, err
+			}
+
+			f := firing{index: i}
+			if bytes.Compare(before, data) != 0 {
+				f.changed = true
+				f.startLine, f.endLine = changedLines(before, data)
+			}
+			firings = append(firings, f)
+		} else {
+			if vverbose {
+				fmt.Fprintf(logOutput(), "%s doesn't match the preconditions\n", filePath)
 			}
 		}
 	}
-	return origDat, data
+	return data, firings, nil
 }
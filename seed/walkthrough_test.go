@@ -9,16 +9,44 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 const expectedCount = 6
 
 var expectedFile = filepath.FromSlash("../test/dir1/file21")
 
+func mustWalkDir(t *testing.T, root, excludes, tdfPath string) []string {
+	files, err := walkDir(root, excludes, tdfPath)
+	if err != nil {
+		t.Fatalf("walkDir failed: %v", err)
+	}
+	return files
+}
+
+func mustFilesToFix(t *testing.T, path, excludes, tdfPath string) []string {
+	files, err := filesToFix(path, excludes, tdfPath)
+	if err != nil {
+		t.Fatalf("filesToFix failed: %v", err)
+	}
+	return files
+}
+
 func TestWalkDir(t *testing.T) {
-	files := walkDir("../test", "", "../test/tdf.yml")
+	files := mustWalkDir(t, "../test", "", "../test/tdf.yml")
 	if len(files) != expectedCount {
 		t.Errorf("WalkDir expect %v files but found %v", expectedCount, len(files))
 	}
@@ -27,12 +55,107 @@ func TestWalkDir(t *testing.T) {
 		t.Errorf("WalkDir expect %v but found %v", expectedFile, files[0])
 	}
 
-	files = walkDir("../test", "test", "../test/tdf.yml")
+	files = mustWalkDir(t, "../test", "test", "../test/tdf.yml")
 	if len(files) != 0 {
 		t.Errorf("WalkDir expect %v files but found %v", 0, len(files))
 	}
 }
 
+func TestWalkDirErrors(t *testing.T) {
+	if _, err := walkDir("../test", "[", ""); err == nil {
+		t.Error("walkDir should report an invalid exclude pattern")
+	}
+
+	dir, err := ioutil.TempDir("", "seed-walk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A .gitignore which cannot be read
+	os.MkdirAll(filepath.Join(dir, "sub", ".gitignore"), 0755)
+	defer func() { gitignore = false }()
+	gitignore = true
+	if _, err = walkDir(dir, "", ""); err == nil || !strings.Contains(err.Error(), "sub") {
+		t.Errorf("walkDir should report the directory which cannot be walked but found %v", err)
+	}
+	if _, err = filesToFix(dir, "", ""); err == nil {
+		t.Error("filesToFix should report the directory which cannot be walked")
+	}
+}
+
+func TestFilesToFix(t *testing.T) {
+	files := mustFilesToFix(t, expectedFile, "", "../test/tdf.yml")
+	if len(files) != 1 || files[0] != expectedFile {
+		t.Errorf("filesToFix should only return %v but found %v", expectedFile, files)
+	}
+
+	// The directory exclusions do not apply to a file given explicitly
+	files = mustFilesToFix(t, expectedFile, "dir1", "../test/tdf.yml")
+	if len(files) != 1 {
+		t.Errorf("filesToFix should not walk the directory of a file but found %v", files)
+	}
+
+	files = mustFilesToFix(t, "../test", "", "../test/tdf.yml")
+	if len(files) != expectedCount {
+		t.Errorf("filesToFix expect %v files in the directory but found %v", expectedCount, len(files))
+	}
+}
+
+func TestWalkDirExclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-exclude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"node_modules/lib", ".git", "vendor", "docs/generated", "src/docs"} {
+		os.MkdirAll(filepath.Join(dir, d), 0755)
+	}
+	for _, f := range []string{"main.js", "app.min.js", "node_modules/lib/index.js", ".git/config",
+		"vendor/dep.go", "docs/generated/api.md", "docs/guide.md", "src/docs/generated.md"} {
+		ioutil.WriteFile(filepath.Join(dir, f), []byte("content"), 0644)
+	}
+
+	files := mustWalkDir(t, dir, "node_modules|.git||vendor|*.min.js|docs/generated", "")
+	var found []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f)
+		found = append(found, filepath.ToSlash(rel))
+	}
+	expected := []string{"docs/guide.md", "main.js", "src/docs/generated.md"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("walkDir should skip the excluded directories and files: %v was expected but found %v", expected, found)
+	}
+}
+
+func TestWalkDirSkipsTdf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-tdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	tdf := filepath.Join(dir, "tdf.yml")
+	for _, f := range []string{tdf, filepath.Join(dir, "sub", "tdf.yml"), filepath.Join(dir, "main.go")} {
+		ioutil.WriteFile(f, []byte("content"), 0644)
+	}
+
+	defer func(skip, include bool) { skipTdf, includeTdf = skip, include }(skipTdf, includeTdf)
+	skipTdf, includeTdf = true, false
+	files := mustWalkDir(t, dir, "", tdfSkipPath(tdf))
+	if len(files) != 2 || files[0] != filepath.Join(dir, "main.go") || files[1] != filepath.Join(dir, "sub", "tdf.yml") {
+		t.Errorf("walkDir should only exclude the transformation file by default but found %v", files)
+	}
+
+	includeTdf = true
+	if files = mustWalkDir(t, dir, "", tdfSkipPath(tdf)); len(files) != 3 {
+		t.Errorf("walkDir should include the transformation file with -include-tdf but found %v", files)
+	}
+	skipTdf, includeTdf = false, false
+	if files = mustWalkDir(t, dir, "", tdfSkipPath(tdf)); len(files) != 3 {
+		t.Errorf("walkDir should include the transformation file with -skip-tdf=false but found %v", files)
+	}
+}
+
 func TestShortPath(t *testing.T) {
 	originalPath := filepath.Join("..", "test", "dir1", "file21")
 	sp := shortPath(expectedFile)
@@ -49,13 +172,13 @@ func TestProcessFiles(t *testing.T) {
 	filesToCheck := []string{"../test/file1", "../test/file1", "../test/file2"}
 	expectedCount := 2
 
-	modifiedFiles := processFiles(filesToCheck, T{Transformations: []Transformation{tt, tf}})
+	modifiedFiles, _ := processFiles(context.Background(), filesToCheck, T{Transformations: []Transformation{tt, tf}}, nil)
 
 	if modifiedFiles != expectedCount {
 		t.Errorf("processFiles: %v files should be processed but found %v", expectedCount, modifiedFiles)
 	}
 
-	modifiedFiles = processFiles(filesToCheck, T{Transformations: []Transformation{}})
+	modifiedFiles, _ = processFiles(context.Background(), filesToCheck, T{Transformations: []Transformation{}}, nil)
 
 	if modifiedFiles != 0 {
 		t.Errorf("processFiles: no files should be processed but found %v", expectedCount, modifiedFiles)
@@ -65,7 +188,436 @@ func TestProcessFiles(t *testing.T) {
 	r := []Procedure{Procedure{Name: "RemoveAtEnd", Params: []string{"foo"}}}
 	cleanup := Transformation{Filter: "*file1", Proc: r}
 	filesToClean := []string{"../test/file1", "../test/file1"}
-	processFiles(filesToClean, T{Transformations: []Transformation{cleanup}})
+	processFiles(context.Background(), filesToClean, T{Transformations: []Transformation{cleanup}}, nil)
+}
+
+func TestProcessFilesEqualLengthRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory := filepath.Join(dir, "memory.txt")
+	streamed := filepath.Join(dir, "streamed.log")
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	for _, f := range []string{memory, streamed} {
+		ioutil.WriteFile(f, []byte("enabled: true\n"), 0644)
+	}
+	ioutil.WriteFile(unchanged, []byte("enabled: none\n"), 0644)
+
+	// The words have the same length, only the content tells the files changed
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"true", "fals"}}}
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.txt", Proc: replace},
+		Transformation{Filter: "*.log", Mode: lineMode, Proc: replace},
+	}}
+	count, err := processFiles(context.Background(), []string{memory, streamed, unchanged}, tdf, nil)
+	if count != 2 || err != nil {
+		t.Errorf("processFiles: 2 files should be fixed but found %v (%v)", count, err)
+	}
+	for _, f := range []string{memory, streamed} {
+		if dat, _ := ioutil.ReadFile(f); string(dat) != "enabled: fals\n" {
+			t.Errorf("%s should be rewritten but found %q", f, dat)
+		}
+	}
+}
+
+func TestProcessFilesUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-unreadable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A link to a directory is listed as a file but cannot be read, even by root
+	first, second := filepath.Join(dir, "a.txt"), filepath.Join(dir, "c.txt")
+	unreadable := filepath.Join(dir, "b.txt")
+	for _, f := range []string{first, second} {
+		ioutil.WriteFile(f, []byte("old\n"), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err := os.Symlink(filepath.Join(dir, "sub"), unreadable); err != nil {
+		t.Skip(err)
+	}
+
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	tdf := T{Transformations: []Transformation{Transformation{Filter: "*.txt", Proc: replace}}}
+	count, err := processFiles(context.Background(), []string{first, unreadable, second}, tdf, nil)
+	if count != 2 {
+		t.Errorf("processFiles should fix the readable files but fixed %v", count)
+	}
+	failed, ok := err.(failedFiles)
+	if !ok || len(failed) != 1 || failed[0].path != unreadable || !strings.HasSuffix(err.Error(), "1 file failed") {
+		t.Errorf("processFiles should report the unreadable file but found %v", err)
+	}
+	for _, f := range []string{first, second} {
+		if dat, _ := ioutil.ReadFile(f); string(dat) != "new\n" {
+			t.Errorf("%s should be fixed but found %q", f, dat)
+		}
+	}
+	if info, err := os.Stat(unreadable); err != nil || !info.IsDir() {
+		t.Errorf("The unreadable file should not be written but found %v", err)
+	}
+
+	tdfPath := filepath.Join(dir, "tdf.yml")
+	ioutil.WriteFile(tdfPath, []byte("transformations:\n  - filter: \"*.txt\"\n    proc:\n      - name: ToLower\n"), 0644)
+	defer func(path string, noGit bool) { transPath, noRequireGit = path, noGit }(transPath, noRequireGit)
+	transPath, noRequireGit = tdfPath, true
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	err = Run(Options{Command: "fix", Args: []string{dir}})
+	os.Stdout.Close()
+	os.Stdout = stdout
+	if exit, ok := err.(exitError); !ok || exit.code != 1 {
+		t.Errorf("fix should fail with the exit code 1 but found %#v", err)
+	}
+}
+
+func TestProcessFilesConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%v.txt", i))
+		ioutil.WriteFile(path, []byte("old\n"), 0644)
+		files = append(files, path)
+	}
+	tdf := T{Transformations: []Transformation{Transformation{Filter: "*.txt", Proc: []Procedure{
+		Procedure{Name: "Replace", Params: []string{"old", "new"}},
+	}}}}
+
+	// Meant to be run with -race: all the files change at the same time and
+	// share the report
+	report := newRunReport(tdf)
+	count, err := processFiles(context.Background(), files, tdf, report)
+	if count != len(files) || err != nil {
+		t.Errorf("processFiles: %v files should be fixed but found %v (%v)", len(files), count, err)
+	}
+	if report.matched[0] != len(files) || report.changed[0] != len(files) || len(report.sortedChanges()) != len(files) {
+		t.Errorf("The report should count each file once but found %v matched and %v changed", report.matched[0], report.changed[0])
+	}
+	for _, f := range files {
+		if dat, _ := ioutil.ReadFile(f); string(dat) != "new\n" {
+			t.Errorf("%s should be fixed but found %q", f, dat)
+		}
+	}
+}
+
+// running and maxRunning track the files processed at the same time by TrackConcurrency.
+var running, maxRunning int64
+
+func (p *Procedures) TrackConcurrency(dat []byte) []byte {
+	n := atomic.AddInt64(&running, 1)
+	for {
+		max := atomic.LoadInt64(&maxRunning)
+		if n <= max || atomic.CompareAndSwapInt64(&maxRunning, max, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	atomic.AddInt64(&running, -1)
+	return dat
+}
+
+func TestProcessFilesWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for i := 0; i < 12; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%v.txt", i))
+		ioutil.WriteFile(path, []byte("old\n"), 0644)
+		files = append(files, path)
+	}
+	tdf := T{Transformations: []Transformation{Transformation{Filter: "*.txt", Proc: []Procedure{
+		Procedure{Name: "TrackConcurrency"},
+		Procedure{Name: "Replace", Params: []string{"old", "new"}},
+	}}}}
+
+	defer func(j int) { jobs = j }(jobs)
+	jobs = 3
+	count, err := processFiles(context.Background(), files, tdf, nil)
+	if count != len(files) || err != nil {
+		t.Errorf("processFiles: %v files should be fixed but found %v (%v)", len(files), count, err)
+	}
+	if max := atomic.LoadInt64(&maxRunning); max > 3 || max < 1 {
+		t.Errorf("At most 3 files should be processed at the same time but found %v", max)
+	}
+
+	for _, c := range []struct{ jobs, files, expected int }{{4, 10, 4}, {4, 2, 2}, {0, 10, 1}} {
+		jobs = c.jobs
+		if n := workers(c.files); n != c.expected {
+			t.Errorf("workers: %v workers were expected for -j %v and %v files but found %v", c.expected, c.jobs, c.files, n)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory := filepath.Join(dir, "memory.txt")
+	streamed := filepath.Join(dir, "streamed.log")
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	ioutil.WriteFile(memory, []byte("old\nkept\nold\n"), 0644)
+	ioutil.WriteFile(streamed, []byte("old\n"), 0644)
+	ioutil.WriteFile(unchanged, []byte("kept\n"), 0644)
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.txt", Proc: replace},
+		Transformation{Filter: "*.log", Mode: lineMode, Proc: replace},
+	}}
+
+	dryRun, sortFiles = true, true
+	defer func() { dryRun, sortFiles = false, false }()
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	count, err := processFiles(context.Background(), []string{memory, streamed, unchanged}, tdf, nil)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+
+	if count != 2 || err != nil {
+		t.Errorf("processFiles: 2 files would be fixed but found %v (%v)", count, err)
+	}
+	expected := fmt.Sprintf("Would fix %s (+2 -2)\nWould fix %s (+1 -1)\n", shortPath(memory), shortPath(streamed))
+	if string(out) != expected {
+		t.Errorf("The dry run should print %q but found %q", expected, out)
+	}
+	for f, content := range map[string]string{memory: "old\nkept\nold\n", streamed: "old\n"} {
+		if dat, _ := ioutil.ReadFile(f); string(dat) != content {
+			t.Errorf("%s should not be written in a dry run but found %q", f, dat)
+		}
+	}
+}
+
+func TestDryRunDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "f.txt")
+	ioutil.WriteFile(f, []byte("old\nkept\n"), 0644)
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+	}}
+
+	dryRun, showDiff = true, true
+	defer func() { dryRun, showDiff = false, false }()
+	stdout := os.Stdout
+	_, w, _ := os.Pipe()
+	os.Stdout = w
+	report := newRunReport(tdf)
+	count, err := processFiles(context.Background(), []string{f}, tdf, report)
+	os.Stdout = stdout
+	w.Close()
+
+	if count != 1 || err != nil {
+		t.Errorf("processFiles: 1 file would be fixed but found %v (%v)", count, err)
+	}
+	var buf bytes.Buffer
+	report.writeDiffs(&buf)
+	expected := unifiedDiff([]byte("old\nkept\n"), []byte("new\nkept\n"), shortPath(f), diffContext)
+	if buf.String() != expected || !strings.Contains(expected, "-old\n+new\n") {
+		t.Errorf("The dry run should collect the diff\n%s\nbut found\n%s", expected, buf.String())
+	}
+	if dat, _ := ioutil.ReadFile(f); string(dat) != "old\nkept\n" {
+		t.Errorf("The file should not be written in a dry run but found %q", dat)
+	}
+}
+
+func TestProcessFilesKeepsMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	modes := map[string]os.FileMode{"run.sh": 0755, "secret.txt": 0600}
+	var files []string
+	for name, mode := range modes {
+		f := filepath.Join(dir, name)
+		ioutil.WriteFile(f, []byte("old\n"), mode)
+		os.Chmod(f, mode)
+		files = append(files, f)
+	}
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}},
+	}}
+
+	if count, err := processFiles(context.Background(), files, tdf, nil); count != 2 || err != nil {
+		t.Fatalf("processFiles: 2 files should be fixed but found %v (%v)", count, err)
+	}
+	for name, mode := range modes {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil || info.Mode().Perm() != mode {
+			t.Errorf("%s should keep the mode %v but found %v (%v)", name, mode, info.Mode().Perm(), err)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.go")
+	ioutil.WriteFile(path, []byte("package main\n"), 0600)
+
+	// The write is interrupted after half of the new content
+	interrupted := errors.New("killed")
+	replaced, err := writeFileAtomic(path, 0600, func(w io.Writer) (bool, error) {
+		w.Write([]byte("pack"))
+		return true, interrupted
+	})
+	if replaced || err != interrupted {
+		t.Errorf("writeFileAtomic should return the error of the write but found %v, %v", replaced, err)
+	}
+	if dat, _ := ioutil.ReadFile(path); string(dat) != "package main\n" {
+		t.Errorf("The interrupted write should leave the file intact but found %q", dat)
+	}
+
+	replaced, err = writeFileAtomic(path, 0640, func(w io.Writer) (bool, error) {
+		_, err := w.Write([]byte("package seed\n"))
+		return true, err
+	})
+	info, _ := os.Stat(path)
+	if dat, _ := ioutil.ReadFile(path); !replaced || err != nil || string(dat) != "package seed\n" || info.Mode().Perm() != 0640 {
+		t.Errorf("writeFileAtomic should replace the file with the mode 0640 but found %v, %v, %q, %v",
+			replaced, err, dat, info.Mode().Perm())
+	}
+
+	if replaced, err = writeFileAtomic(path, 0640, func(w io.Writer) (bool, error) { return false, nil }); replaced || err != nil {
+		t.Errorf("writeFileAtomic should not replace the file when it is not kept but found %v, %v", replaced, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("The temporary files should be removed but found %v files", len(files))
+	}
+
+	link := filepath.Join(dir, "link.go")
+	if err = os.Symlink("main.go", link); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err = writeFileAtomic(link, 0640, func(w io.Writer) (bool, error) {
+		_, err := w.Write([]byte("package link\n"))
+		return true, err
+	})
+	if dat, _ := ioutil.ReadFile(path); !replaced || err != nil || string(dat) != "package link\n" {
+		t.Errorf("writeFileAtomic should replace the target of the symlink but found %v, %v, %q", replaced, err, dat)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("writeFileAtomic should keep the symlink but found %v, %v", info, err)
+	}
+}
+
+func TestProcessFilesSkipsBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := append([]byte("\x89PNG\r\n\x1a\n\x00\x00old\n"), bytes.Repeat([]byte("old\n"), 3000)...)
+	image := filepath.Join(dir, "image.png")
+	streamed := filepath.Join(dir, "archive.log")
+	text := filepath.Join(dir, "notes.txt")
+	ioutil.WriteFile(image, binary, 0644)
+	ioutil.WriteFile(streamed, binary, 0644)
+	ioutil.WriteFile(text, []byte("old\n"), 0644)
+	files := []string{image, streamed, text}
+	replace := []Procedure{Procedure{Name: "Replace", Params: []string{"old", "new"}}}
+	tdf := T{Transformations: []Transformation{
+		Transformation{Filter: "*.png|*.txt", Proc: replace},
+		Transformation{Filter: "*.log", Mode: lineMode, Proc: replace},
+	}}
+
+	if count, err := processFiles(context.Background(), files, tdf, nil); count != 1 || err != nil {
+		t.Fatalf("processFiles: only the text file should be fixed but found %v (%v)", count, err)
+	}
+	for _, f := range []string{image, streamed} {
+		if dat, _ := ioutil.ReadFile(f); !bytes.Equal(dat, binary) {
+			t.Errorf("The binary file %s should be left byte-for-byte identical", f)
+		}
+	}
+	if dat, _ := ioutil.ReadFile(text); string(dat) != "new\n" {
+		t.Errorf("The text file should be fixed but found %q", dat)
+	}
+
+	includeBinary = true
+	defer func() { includeBinary = false }()
+	if count, err := processFiles(context.Background(), files, tdf, nil); count != 2 || err != nil {
+		t.Fatalf("processFiles: the binary files should be fixed with -include-binary but found %v (%v)", count, err)
+	}
+	fixed := bytes.Replace(binary, []byte("old"), []byte("new"), -1)
+	for _, f := range []string{image, streamed} {
+		if dat, _ := ioutil.ReadFile(f); !bytes.Equal(dat, fixed) {
+			t.Errorf("The binary file %s should be fixed with -include-binary", f)
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	late := append(bytes.Repeat([]byte("a"), binarySniffLen), 0)
+	for content, expected := range map[string]bool{"": false, "text\n": false, "a\x00b": true, string(late): false} {
+		if isBinary([]byte(content)) != expected {
+			t.Errorf("isBinary(%.20q) should be %v", content, expected)
+		}
+	}
+}
+
+func TestProcessFilesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("file%v.txt", i)
+		if i%3 == 0 {
+			name = fmt.Sprintf("file%v.fail", i)
+		}
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("foo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	fail := Transformation{Filter: "*.fail", Proc: []Procedure{Procedure{Name: "AlwaysFail"}}}
+	insert := Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"bar"}}}}
+	tdf := T{Transformations: []Transformation{fail, insert}}
+
+	var expected string
+	for _, i := range []int{0, 3, 6, 9} {
+		expected += fmt.Sprintf("%s: AlwaysFail failed: injected failure\n", shortPath(filepath.Join(dir, fmt.Sprintf("file%v.fail", i))))
+	}
+	expected += "4 files failed"
+
+	for run := 0; run < 5; run++ {
+		count, err := processFiles(context.Background(), files, tdf, nil)
+		if count != 6 {
+			t.Errorf("processFiles: the 6 valid files should be processed but found %v", count)
+		}
+		if err == nil || err.Error() != expected {
+			t.Fatalf("processFiles: the errors should be sorted by path, expected:\n%s\nbut found:\n%v", expected, err)
+		}
+	}
 }
 
 func TestProcessFile(t *testing.T) {
@@ -73,13 +625,150 @@ func TestProcessFile(t *testing.T) {
 	tt := Transformation{Filter: "*file1", Proc: p}
 	tf := Transformation{Filter: "*.go", Proc: p}
 
-	orig, dat := processFile("../test/file1", T{Transformations: []Transformation{tt}})
+	orig, dat, _, _ := processFile("../test/file1", T{Transformations: []Transformation{tt}})
 	if string(orig) == string(dat) {
 		t.Error("file1 should be processed.")
 	}
 
-	orig, dat = processFile("../test/file1", T{Transformations: []Transformation{tf}})
+	orig, dat, _, _ = processFile("../test/file1", T{Transformations: []Transformation{tf}})
 	if string(orig) != string(dat) {
 		t.Error("file1 should not be processed.")
 	}
+
+	fail := Transformation{Filter: "*file1", Proc: []Procedure{Procedure{Name: "AlwaysFail"}}}
+	orig, dat, _, err := processFile("../test/file1", T{Transformations: []Transformation{tt, fail}})
+	if err == nil || string(orig) != string(dat) {
+		t.Error("file1 should be left untouched when a procedure fails.")
+	}
+}
+
+func TestProcessFileFixpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-fixpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+	if err = ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { fixpoint = false }()
+
+	// The second transformation enables the first one
+	yToZ := Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"y", "z"}}}}
+	xToY := Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Replace", Params: []string{"x", "y"}}}}
+	tdf := T{Transformations: []Transformation{yToZ, xToY}}
+
+	if _, dat, _, _ := processFile(path, tdf); string(dat) != "y" {
+		t.Errorf("A single pass should only replace x, but found %s", dat)
+	}
+
+	fixpoint = true
+	maxIterations = 10
+	if _, dat, _, err := processFile(path, tdf); err != nil || string(dat) != "z" {
+		t.Errorf("The fixpoint mode should apply the transformations until z, but found %s, %v", dat, err)
+	}
+
+	insert := Transformation{Filter: "*.txt", Proc: []Procedure{Procedure{Name: "Insert", Params: []string{"a"}}}}
+	orig, dat, _, err := processFile(path, T{Transformations: []Transformation{insert}})
+	if err == nil || string(orig) != string(dat) {
+		t.Errorf("A never ending transformation should fail after the max iterations, but found %s, %v", dat, err)
+	}
+}
+
+func TestGlobalSequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-sequence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := map[string]string{"c.txt": "id: ?\n", "a.txt": "id: ?\nid: ?\n", "b.txt": "none\n", "d.txt": "id: ?\n"}
+	var files []string
+	for name := range contents {
+		files = append(files, filepath.Join(dir, name))
+	}
+
+	tdf := T{Transformations: []Transformation{Transformation{Filter: "*.txt", Proc: []Procedure{
+		Procedure{Name: "Sequence", Params: []string{`\?`, "{{.N}}"}},
+	}}}}
+	globalSequence = true
+	defer func() { globalSequence = false }()
+
+	// Run twice to check the numbers do not depend on the run
+	for run := 0; run < 2; run++ {
+		for name, content := range contents {
+			ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		}
+		if _, err = processFiles(context.Background(), files, tdf, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{"a.txt": "id: 1\nid: 2\n", "b.txt": "none\n", "c.txt": "id: 3\n", "d.txt": "id: 4\n"}
+		for name, exp := range expected {
+			if dat, _ := ioutil.ReadFile(filepath.Join(dir, name)); string(dat) != exp {
+				t.Errorf("Run %v, %s: %q was expected but found %q", run, name, exp, dat)
+			}
+		}
+	}
+}
+
+// cancelRun cancels the run of TestInterruptedRun from a procedure.
+var cancelRun context.CancelFunc
+
+func (p *Procedures) CancelRun(dat []byte) []byte {
+	cancelRun()
+	return dat
+}
+
+func TestInterruptedRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-interrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		files = append(files, filepath.Join(dir, name))
+		ioutil.WriteFile(files[len(files)-1], []byte("old\n"), 0644)
+	}
+	tdf := T{Transformations: []Transformation{Transformation{Filter: "*.txt", Proc: []Procedure{
+		Procedure{Name: "Replace", Params: []string{"old", "new"}},
+		Procedure{Name: "CancelRun"},
+	}}}}
+	sortFiles = true
+	defer func() { sortFiles = false }()
+
+	// The first file cancels the run, as a signal received while processing it
+	var ctx context.Context
+	ctx, cancelRun = context.WithCancel(context.Background())
+	count, err := processFiles(ctx, files, tdf, nil)
+	if count != 1 {
+		t.Errorf("Only the file in progress should be fixed but found %v", count)
+	}
+	interrupted, ok := err.(interruptedError)
+	if !ok || interrupted.processed != 1 || interrupted.total != 3 || interrupted.err != nil {
+		t.Fatalf("An interruption after 1/3 files was expected but found %#v", err)
+	}
+	if !strings.Contains(err.Error(), "interrupted after 1/3 files") {
+		t.Errorf("The interruption should tell how many files were processed but found %q", err)
+	}
+	expected := []string{"new\n", "old\n", "old\n"}
+	for i, f := range files {
+		if dat, _ := ioutil.ReadFile(f); string(dat) != expected[i] {
+			t.Errorf("%s: %q was expected but found %q", f, expected[i], dat)
+		}
+	}
+}
+
+func TestInterruptContext(t *testing.T) {
+	ctx, stop := interruptContext()
+	defer stop()
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("The context should be canceled on SIGINT")
+	}
 }
@@ -0,0 +1,625 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// yamlCanonicalScalars maps the boolean and null spellings
+// resolved by the YAML parser to their canonical form.
+var yamlCanonicalScalars = map[string]string{
+	"y": "true", "Y": "true", "yes": "true", "Yes": "true", "YES": "true",
+	"on": "true", "On": "true", "ON": "true", "True": "true", "TRUE": "true",
+	"n": "false", "N": "false", "no": "false", "No": "false", "NO": "false",
+	"off": "false", "Off": "false", "OFF": "false", "False": "false", "FALSE": "false",
+	"~": "null", "Null": "null", "NULL": "null",
+}
+
+// yamlValueRegex matches a line with a key or a sequence entry followed by a plain value.
+var yamlValueRegex = regexp.MustCompile(`^( *(?:- +)*(?:(?:[^ #'"-][^:#]*|"[^"]*"|'[^']*') *: +|- +))([^ #][^#]*?) *(?: #.*)?$`)
+
+// CanonicalizeYamlScalars rewrites the booleans of a YAML file to true/false
+// and the nulls to null, e.g. "enabled: yes" becomes "enabled: true". Quoted
+// strings, block scalars and comments are left untouched. The file must be
+// valid YAML and loads to the same values before and after the rewrite.
+//
+// proc:
+//  -
+//    name: CanonicalizeYamlScalars
+func (p *Procedures) CanonicalizeYamlScalars(dat []byte) ([]byte, error) {
+	var before interface{}
+	if err := yaml.Unmarshal(dat, &before); err != nil {
+		return dat, err
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	blockIndent := -1
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		indent := len(content) - len(strings.TrimLeft(content, " "))
+
+		// Skip the content of block scalars
+		if blockIndent >= 0 {
+			if strings.TrimSpace(content) == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+
+		m := yamlValueRegex.FindStringSubmatchIndex(content)
+		if m == nil {
+			continue
+		}
+		value := content[m[4]:m[5]]
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+			continue
+		}
+		if canonical, ok := yamlCanonicalScalars[value]; ok {
+			lines[i] = content[:m[4]] + canonical + line[m[5]:]
+		}
+	}
+	res := []byte(strings.Join(lines, ""))
+
+	var after interface{}
+	if err := yaml.Unmarshal(res, &after); err != nil || !reflect.DeepEqual(before, after) {
+		return dat, fmt.Errorf("canonicalizing the scalars would change the document values")
+	}
+	return res, nil
+}
+
+// yamlKeyRegex matches a line starting with a mapping key, possibly inside a sequence entry.
+var yamlKeyRegex = regexp.MustCompile(`^( *(?:- +)*)("[^"]*"|'[^']*'|[^ #'"-][^:#]*?) *:(?: +|$)`)
+
+// yamlEntry is a line of a YAML document holding a key.
+type yamlEntry struct {
+	line       int    // index of the line
+	path       string // dotted path of the key, e.g. "spec.template.name"
+	valueStart int    // offset of the value in the line
+	valueEnd   int
+}
+
+// yamlEntries returns the lines of a YAML document holding a key, along with
+// the position of their inline value. Keys of the mappings nested in sequences
+// are part of the path of the sequence, e.g. "modules.path" for the "path" key
+// of each entry of the "modules" sequence. The content of the block scalars
+// is skipped.
+func yamlEntries(lines []string) []yamlEntry {
+	type key struct {
+		indent int
+		name   string
+	}
+	var entries []yamlEntry
+	var stack []key
+	blockIndent := -1
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(content)
+		indent := len(content) - len(strings.TrimLeft(content, " "))
+		if blockIndent >= 0 {
+			if trimmed == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		m := yamlKeyRegex.FindStringSubmatchIndex(content)
+		if m == nil {
+			continue
+		}
+		name := strings.Trim(content[m[4]:m[5]], `"'`)
+		keyIndent := m[3]
+		for len(stack) > 0 && stack[len(stack)-1].indent >= keyIndent {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, key{keyIndent, name})
+
+		var names []string
+		for _, k := range stack {
+			names = append(names, k.name)
+		}
+		start, end := m[1], len(content)
+		if comment := strings.Index(content[start:], " #"); comment != -1 {
+			end = start + comment
+		}
+		end = start + len(strings.TrimRight(content[start:end], " "))
+		entries = append(entries, yamlEntry{i, strings.Join(names, "."), start, end})
+
+		if value := content[start:end]; strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+		}
+	}
+	return entries
+}
+
+// rewriteYamlScalar applies fn to the value of a YAML scalar and formats the
+// result with the same quoting style.
+func rewriteYamlScalar(value string, fn func(string) string) (string, error) {
+	var s string
+	if err := yaml.Unmarshal([]byte(value), &s); err != nil {
+		return value, err
+	}
+	res := fn(s)
+	switch {
+	case res == s:
+		return value, nil
+	case strings.HasPrefix(value, "'") && !strings.Contains(res, "'"),
+		strings.HasPrefix(value, `"`) && !strings.ContainsAny(res, `"\`):
+		return value[:1] + res + value[:1], nil
+	}
+	out, err := yaml.Marshal(res)
+	return strings.TrimSpace(string(out)), err
+}
+
+// NormalizeModulePaths lower cases the values of the given keys of a YAML file
+// and replaces their backslashes by slashes, e.g. "Modules\App" becomes
+// "modules/app". Keys are given as dotted paths and no other value is modified.
+//
+// proc:
+//  -
+//    name: NormalizeModulePaths
+//    params:
+//      - "build.module"
+//      - "dependencies.path"
+func (p *Procedures) NormalizeModulePaths(dat []byte, paths ...string) ([]byte, error) {
+	targets := make(map[string]bool)
+	for _, path := range paths {
+		targets[path] = true
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	for _, e := range yamlEntries(lines) {
+		line := lines[e.line]
+		value := line[e.valueStart:e.valueEnd]
+		if !targets[e.path] || value == "" {
+			continue
+		}
+		res, err := rewriteYamlScalar(value, func(s string) string {
+			return strings.ToLower(strings.Replace(s, "\\", "/", -1))
+		})
+		if err != nil {
+			return dat, fmt.Errorf("invalid value for %s: %v", e.path, err)
+		}
+		lines[e.line] = line[:e.valueStart] + res + line[e.valueEnd:]
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// yamlLeadingRegex matches the indentation and the sequence dashes starting a line.
+var yamlLeadingRegex = regexp.MustCompile(`^ *(?:- +)*`)
+
+// yamlLevel is a nesting level of a YAML document, with its original and new column.
+type yamlLevel struct {
+	orig, new int
+}
+
+// ReindentYaml changes the indentation width of a YAML document, e.g. from 4 to
+// 2 spaces. The nested mappings and sequences are indented by the given width,
+// the compact entries of a sequence staying aligned after their dash. Scalars,
+// quoting, comments and the relative indentation of the block scalars are
+// preserved. The document must be valid and loads to the same values before
+// and after the rewrite.
+//
+// proc:
+//  -
+//    name: ReindentYaml
+//    params: "2"
+func (p *Procedures) ReindentYaml(dat []byte, width string) ([]byte, error) {
+	n, err := strconv.Atoi(width)
+	if err != nil || n < 1 {
+		return dat, fmt.Errorf("invalid indentation width: %s", width)
+	}
+	var before interface{}
+	if err := yaml.Unmarshal(dat, &before); err != nil {
+		return dat, err
+	}
+
+	stack := []yamlLevel{{0, 0}}
+	// blockIndent is the column of the node holding the current block scalar,
+	// whose content is shifted from its first line to blockNew
+	blockIndent, blockNew, blockShift := -1, 0, 0
+	blockStart := false
+	lines := strings.SplitAfter(string(dat), "\n")
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+		trimmed := strings.TrimSpace(content)
+		indent := len(content) - len(strings.TrimLeft(content, " "))
+
+		if blockIndent >= 0 {
+			if trimmed == "" {
+				continue
+			}
+			if indent > blockIndent {
+				if blockStart {
+					blockShift, blockStart = blockNew-indent, false
+				}
+				lines[i] = strings.Repeat(" ", indent+blockShift) + content[indent:] + eol
+				continue
+			}
+			blockIndent = -1
+		}
+		if trimmed == "" {
+			continue
+		}
+		if indent == 0 && (strings.HasPrefix(trimmed, "---") || strings.HasPrefix(trimmed, "...")) {
+			stack = []yamlLevel{{0, 0}}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			// Comments are aligned on the level they would belong to, without changing it
+			level := stack[0]
+			for _, l := range stack {
+				if l.orig <= indent {
+					level = l
+				}
+			}
+			newIndent := level.new
+			if level.orig < indent {
+				newIndent += n
+			}
+			lines[i] = strings.Repeat(" ", newIndent) + content[indent:] + eol
+			continue
+		}
+
+		for len(stack) > 1 && stack[len(stack)-1].orig > indent {
+			stack = stack[:len(stack)-1]
+		}
+		top := stack[len(stack)-1]
+		newIndent := top.new
+		if top.orig < indent {
+			newIndent = top.new + n
+			stack = append(stack, yamlLevel{indent, newIndent})
+		}
+		lines[i] = strings.Repeat(" ", newIndent) + content[indent:] + eol
+
+		// The content of the sequence entries is aligned after the dashes
+		leading := yamlLeadingRegex.FindString(content)
+		for col := indent; col < len(leading); {
+			next := col + 1 + len(leading[col+1:]) - len(strings.TrimLeft(leading[col+1:], " "))
+			stack = append(stack, yamlLevel{next, next - indent + newIndent})
+			col = next
+		}
+
+		if m := yamlValueRegex.FindStringSubmatchIndex(content); m != nil {
+			if value := content[m[4]:m[5]]; strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+				// The block holder is the last key of the line, or the last dash
+				blockIndent = len(leading)
+				if !yamlKeyRegex.MatchString(content) {
+					blockIndent = strings.LastIndex(leading, "-")
+				}
+				blockNew = blockIndent - indent + newIndent + n
+				blockStart = true
+			}
+		}
+	}
+	res := []byte(strings.Join(lines, ""))
+
+	var after interface{}
+	if err := yaml.Unmarshal(res, &after); err != nil || !reflect.DeepEqual(before, after) {
+		return dat, fmt.Errorf("reindenting would change the document values")
+	}
+	return res, nil
+}
+
+// yamlItem is an entry of a YAML sequence, with its comment lines.
+type yamlItem struct {
+	value string
+	lines []string
+}
+
+type byItemValue []yamlItem
+
+func (s byItemValue) Len() int           { return len(s) }
+func (s byItemValue) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byItemValue) Less(i, j int) bool { return s[i].value < s[j].value }
+
+// SortYamlList sorts the scalar entries of the sequences at the given dotted
+// path and removes their duplicates, keeping the first occurrence. Block and
+// flow sequences are supported, the comment lines preceding an entry being
+// moved along with it. The rest of the document is left untouched.
+//
+// proc:
+//  -
+//    name: SortYamlList
+//    params: "security.allowlist"
+func (p *Procedures) SortYamlList(dat []byte, path string) ([]byte, error) {
+	lines := strings.SplitAfter(string(dat), "\n")
+	entries := yamlEntries(lines)
+	// Sequences are rewritten from the end so that the line indexes stay valid
+	for k := len(entries) - 1; k >= 0; k-- {
+		e := entries[k]
+		if e.path != path {
+			continue
+		}
+		line := lines[e.line]
+		if value := line[e.valueStart:e.valueEnd]; value != "" {
+			sorted, err := sortYamlFlowList(value)
+			if err != nil {
+				return dat, fmt.Errorf("invalid sequence for %s: %v", path, err)
+			}
+			lines[e.line] = line[:e.valueStart] + sorted + line[e.valueEnd:]
+			continue
+		}
+		sorted, end, err := sortYamlBlockList(lines, e.line+1)
+		if err != nil {
+			return dat, fmt.Errorf("invalid sequence for %s: %v", path, err)
+		}
+		lines = append(lines[:e.line+1], append(sorted, lines[end:]...)...)
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// sortYamlBlockList sorts the block sequence starting at the given line. It
+// returns the sorted lines and the index of the line following the sequence.
+func sortYamlBlockList(lines []string, start int) ([]string, int, error) {
+	var items []yamlItem
+	var pending []string
+	end, itemIndent := start, -1
+	for i := start; i < len(lines); i++ {
+		content := strings.TrimRight(lines[i], "\r\n")
+		trimmed := strings.TrimSpace(content)
+		indent := len(content) - len(strings.TrimLeft(content, " "))
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			pending = append(pending, lines[i])
+			continue
+		}
+		if itemIndent == -1 {
+			itemIndent = indent
+		}
+		if indent < itemIndent || (indent == itemIndent && !strings.HasPrefix(trimmed, "- ") && trimmed != "-") {
+			break
+		}
+		m := yamlValueRegex.FindStringSubmatch(content)
+		if indent > itemIndent || m == nil || len(m[1]) != indent+2 || yamlKeyRegex.MatchString(content) ||
+			strings.HasPrefix(m[2], "|") || strings.HasPrefix(m[2], ">") {
+			return nil, 0, fmt.Errorf("only scalar entries can be sorted: %s", trimmed)
+		}
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(m[2]), &value); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, yamlItem{fmt.Sprint(value), append(pending, lines[i])})
+		pending = nil
+		end = i + 1
+	}
+	// The last line of the document may be moved inside the sequence
+	noEol := end > start && !strings.HasSuffix(lines[end-1], "\n")
+	if noEol {
+		last := items[len(items)-1].lines
+		last[len(last)-1] += "\n"
+	}
+
+	var sorted []string
+	for _, item := range sortYamlItems(items) {
+		sorted = append(sorted, item.lines...)
+	}
+	if noEol {
+		sorted[len(sorted)-1] = strings.TrimSuffix(sorted[len(sorted)-1], "\n")
+	}
+	return sorted, end, nil
+}
+
+// sortYamlFlowList sorts a flow sequence of scalars, e.g. "[b, a]".
+func sortYamlFlowList(value string) (string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return value, fmt.Errorf("a sequence was expected but found %s", value)
+	}
+	inner := value[1 : len(value)-1]
+	padding := inner[:len(inner)-len(strings.TrimLeft(inner, " "))]
+
+	var items []yamlItem
+	var quote rune
+	start := 0
+	for i, r := range inner + "," {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == ']' || r == '{' || r == '}':
+			return value, fmt.Errorf("only scalar entries can be sorted: %s", value)
+		case r == ',':
+			item := strings.TrimSpace(inner[start:i])
+			start = i + 1
+			if item == "" {
+				continue
+			}
+			var v interface{}
+			if err := yaml.Unmarshal([]byte(item), &v); err != nil {
+				return value, err
+			}
+			items = append(items, yamlItem{fmt.Sprint(v), []string{item}})
+		}
+	}
+
+	var sorted []string
+	for _, item := range sortYamlItems(items) {
+		sorted = append(sorted, item.lines[0])
+	}
+	return "[" + padding + strings.Join(sorted, ", ") + padding + "]", nil
+}
+
+// sortYamlItems removes the duplicated values, keeping the first occurrence,
+// and sorts the items by value.
+func sortYamlItems(items []yamlItem) []yamlItem {
+	seen := make(map[string]bool)
+	var unique []yamlItem
+	for _, item := range items {
+		if !seen[item.value] {
+			seen[item.value] = true
+			unique = append(unique, item)
+		}
+	}
+	sort.Stable(byItemValue(unique))
+	return unique
+}
+
+// yamlKeyBlock is a key of a YAML mapping, with its nested lines and the comment
+// lines preceding it.
+type yamlKeyBlock struct {
+	name  string
+	rank  int
+	lines []string
+}
+
+type byKeyRank []yamlKeyBlock
+
+func (s byKeyRank) Len() int      { return len(s) }
+func (s byKeyRank) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byKeyRank) Less(i, j int) bool {
+	if s[i].rank != s[j].rank {
+		return s[i].rank < s[j].rank
+	}
+	return s[i].name < s[j].name
+}
+
+// OrderKeys orders the keys of the mappings at the given dotted path, "." for
+// the top-level mapping of each document, e.g. to get the conventional order of
+// the Kubernetes manifests. The given keys come first in that order, the other
+// ones follow alphabetically. Each key moves with its nested lines and the
+// comment lines right above it, except the first key whose comments stay at the
+// top of the mapping. The mappings of sequence entries are not ordered.
+//
+// proc:
+//  -
+//    name: OrderKeys
+//    params:
+//      - "."
+//      - apiVersion
+//      - kind
+//      - metadata
+//      - spec
+func (p *Procedures) OrderKeys(dat []byte, path string, keys ...string) ([]byte, error) {
+	var before interface{}
+	if err := yaml.Unmarshal(dat, &before); err != nil {
+		return dat, unparsable("OrderKeys", err)
+	}
+
+	lines := strings.SplitAfter(string(dat), "\n")
+	if path == "." {
+		start := 0
+		for i := 0; i <= len(lines); i++ {
+			if i == len(lines) || strings.HasPrefix(lines[i], "---") || strings.HasPrefix(lines[i], "...") {
+				orderYamlMapping(lines[start:i], 0, keys)
+				start = i + 1
+			}
+		}
+	} else {
+		for _, e := range yamlEntries(lines) {
+			line := strings.TrimRight(lines[e.line], "\r\n")
+			indent := len(line) - len(strings.TrimLeft(line, " "))
+			if e.path != path || e.valueEnd > e.valueStart || strings.HasPrefix(line[indent:], "-") {
+				continue
+			}
+			// The mapping is made of the following lines indented deeper than the key
+			end, keyIndent := e.line+1, -1
+			for i := e.line + 1; i < len(lines); i++ {
+				content := strings.TrimRight(lines[i], "\r\n")
+				trimmed := strings.TrimSpace(content)
+				if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+					continue
+				}
+				lineIndent := len(content) - len(strings.TrimLeft(content, " "))
+				if keyIndent == -1 {
+					keyIndent = lineIndent
+				}
+				if lineIndent <= indent || lineIndent < keyIndent {
+					break
+				}
+				end = i + 1
+			}
+			if keyIndent > indent {
+				orderYamlMapping(lines[e.line+1:end], keyIndent, keys)
+			}
+		}
+	}
+	res := []byte(strings.Join(lines, ""))
+
+	var after interface{}
+	if err := yaml.Unmarshal(res, &after); err != nil || !reflect.DeepEqual(before, after) {
+		return dat, fmt.Errorf("ordering the keys of %s would change the document values", path)
+	}
+	return res, nil
+}
+
+// orderYamlMapping orders in place the keys of the mapping made of the lines, its
+// keys being at the given indentation. The lines before the first key, e.g. a file
+// header, and after the last key stay in place. Lines which are not part
+// of a mapping are left untouched.
+func orderYamlMapping(lines []string, indent int, keys []string) {
+	if len(lines) == 0 {
+		return
+	}
+	rank := make(map[string]int)
+	for i := len(keys) - 1; i >= 0; i-- {
+		rank[keys[i]] = i
+	}
+	// The last line of the document may be moved before other keys
+	last := lines[len(lines)-1]
+	noEol := !strings.HasSuffix(last, "\n")
+
+	var head, pending []string
+	var blocks []yamlKeyBlock
+	for i, line := range lines {
+		if noEol && i == len(lines)-1 {
+			line += "\n"
+		}
+		content := strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(content)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			pending = append(pending, line)
+			continue
+		}
+		lineIndent := len(content) - len(strings.TrimLeft(content, " "))
+		m := yamlKeyRegex.FindStringSubmatchIndex(content)
+		if m != nil && lineIndent == indent && m[3] == indent {
+			if len(blocks) == 0 {
+				head, pending = pending, nil
+			}
+			name := strings.Trim(content[m[4]:m[5]], `"'`)
+			r, ok := rank[name]
+			if !ok {
+				r = len(keys)
+			}
+			blocks = append(blocks, yamlKeyBlock{name, r, append(pending, line)})
+			pending = nil
+			continue
+		}
+		if len(blocks) == 0 || lineIndent <= indent && !strings.HasPrefix(trimmed, "-") {
+			return
+		}
+		b := &blocks[len(blocks)-1]
+		b.lines = append(b.lines, append(pending, line)...)
+		pending = nil
+	}
+
+	sort.Stable(byKeyRank(blocks))
+	ordered := append([]string{}, head...)
+	for _, b := range blocks {
+		ordered = append(ordered, b.lines...)
+	}
+	ordered = append(ordered, pending...)
+	if noEol {
+		ordered[len(ordered)-1] = strings.TrimSuffix(ordered[len(ordered)-1], "\n")
+	}
+	copy(lines, ordered)
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2013-2015 by The SeedStack authors. All rights reserved.
+
+// This file is part of SeedStack, An enterprise-oriented full development stack.
+
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var scalarsYml = `enabled: yes
+debug: No
+parent: ~
+answer: "yes please"
+plain: yes please
+quoted: 'no'
+list:
+ - on
+ - OFF # disabled
+ - key: Y
+text: |
+  yes
+  no
+after: NULL
+`
+
+var expectedScalarsYml = `enabled: true
+debug: false
+parent: null
+answer: "yes please"
+plain: yes please
+quoted: 'no'
+list:
+ - true
+ - false # disabled
+ - key: true
+text: |
+  yes
+  no
+after: null
+`
+
+func TestCanonicalizeYamlScalars(t *testing.T) {
+	var p *Procedures
+	res, err := p.CanonicalizeYamlScalars([]byte(scalarsYml))
+	if err != nil || string(res) != expectedScalarsYml {
+		t.Errorf("CanonicalizeYamlScalars: expected\n%s\nbut found %v\n%s", expectedScalarsYml, err, res)
+	}
+
+	if _, err := p.CanonicalizeYamlScalars([]byte("a: [b\n")); err == nil {
+		t.Error("CanonicalizeYamlScalars should fail on invalid YAML")
+	}
+}
+
+var modulesYml = `build:
+  module: Core\Main # the main module
+  name: Core\Main
+dependencies:
+  - path: "Libs\\Common"
+    version: 1.0
+  - path: 'Libs\Extra'
+description: |
+  module: Not\A\Key
+`
+
+var expectedModulesYml = `build:
+  module: core/main # the main module
+  name: Core\Main
+dependencies:
+  - path: "libs/common"
+    version: 1.0
+  - path: 'libs/extra'
+description: |
+  module: Not\A\Key
+`
+
+func TestYamlEntries(t *testing.T) {
+	var paths []string
+	for _, e := range yamlEntries(strings.SplitAfter(modulesYml, "\n")) {
+		paths = append(paths, e.path)
+	}
+	expected := []string{"build", "build.module", "build.name", "dependencies",
+		"dependencies.path", "dependencies.version", "dependencies.path", "description"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("yamlEntries: %q was expected but found %q", expected, paths)
+	}
+}
+
+func TestNormalizeModulePaths(t *testing.T) {
+	var p *Procedures
+	res, err := p.NormalizeModulePaths([]byte(modulesYml), "build.module", "dependencies.path")
+	if err != nil || string(res) != expectedModulesYml {
+		t.Errorf("NormalizeModulePaths: expected\n%s\nbut found %v\n%s", expectedModulesYml, err, res)
+	}
+}
+
+var wideYml = `# service configuration
+server:
+    host: "localhost"
+    ports:
+        - 8080
+        - 8443
+    tls:
+        enabled: yes
+        # the certificate files
+        files: ['cert.pem', "key.pem"]
+routes:
+    -   path: /api
+        target: 'backend:9000'
+        headers:
+            X-Debug: "on"
+    - path: /
+      script: |
+          echo "start"
+            indented
+description: >
+    folded
+    text
+`
+
+var narrowYml = `# service configuration
+server:
+  host: "localhost"
+  ports:
+    - 8080
+    - 8443
+  tls:
+    enabled: yes
+    # the certificate files
+    files: ['cert.pem', "key.pem"]
+routes:
+  -   path: /api
+      target: 'backend:9000'
+      headers:
+        X-Debug: "on"
+  - path: /
+    script: |
+      echo "start"
+        indented
+description: >
+  folded
+  text
+`
+
+func TestReindentYaml(t *testing.T) {
+	var p *Procedures
+	res, err := p.ReindentYaml([]byte(wideYml), "2")
+	if err != nil || string(res) != narrowYml {
+		t.Errorf("ReindentYaml: expected\n%s\nbut found %v\n%s", narrowYml, err, res)
+	}
+
+	if res, err = p.ReindentYaml([]byte(narrowYml), "2"); err != nil || string(res) != narrowYml {
+		t.Errorf("ReindentYaml should keep a document already indented but found %v\n%s", err, res)
+	}
+	if _, err := p.ReindentYaml([]byte(wideYml), "zero"); err == nil {
+		t.Error("ReindentYaml should reject an invalid width")
+	}
+	if _, err := p.ReindentYaml([]byte("a: [b\n"), "2"); err == nil {
+		t.Error("ReindentYaml should fail on invalid YAML")
+	}
+}
+
+var allowlistYml = `name: scanner
+security:
+  allowlist:
+    - zlib
+    # needed by the parser
+    - "expat"
+    - zlib
+    - 'bzip2' # archives
+  denylist:
+    - zlib
+    - bzip2
+  hosts: [b.example.com, a.example.com, "b.example.com"]
+allowlist:
+  - z
+  - a
+`
+
+var sortedAllowlistYml = `name: scanner
+security:
+  allowlist:
+    - 'bzip2' # archives
+    # needed by the parser
+    - "expat"
+    - zlib
+  denylist:
+    - zlib
+    - bzip2
+  hosts: [a.example.com, b.example.com]
+allowlist:
+  - z
+  - a
+`
+
+func TestSortYamlList(t *testing.T) {
+	var p *Procedures
+	res, err := p.SortYamlList([]byte(allowlistYml), "security.allowlist")
+	if err == nil {
+		res, err = p.SortYamlList(res, "security.hosts")
+	}
+	if err != nil || string(res) != sortedAllowlistYml {
+		t.Errorf("SortYamlList: expected\n%s\nbut found %v\n%s", sortedAllowlistYml, err, res)
+	}
+
+	res, err = p.SortYamlList([]byte("list:\n  - b\n  - a"), "list")
+	if expected := "list:\n  - a\n  - b"; err != nil || string(res) != expected {
+		t.Errorf("SortYamlList: %q was expected but found %q, %v", expected, res, err)
+	}
+	if _, err := p.SortYamlList([]byte("list:\n  - b\n  - name: a\n"), "list"); err == nil {
+		t.Error("SortYamlList should only sort scalar entries")
+	}
+}
+
+var unorderedManifest = `# Web deployment
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+metadata:
+  name: web
+  labels:
+    app: web
+# the object kind
+kind: Deployment
+apiVersion: apps/v1
+---
+data:
+  key: value
+kind: ConfigMap
+apiVersion: v1`
+
+var orderedManifest = `# Web deployment
+apiVersion: apps/v1
+# the object kind
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  key: value`
+
+func TestOrderKeys(t *testing.T) {
+	var p *Procedures
+	keys := []string{"apiVersion", "kind", "metadata", "spec"}
+	res, err := p.OrderKeys([]byte(unorderedManifest), ".", keys...)
+	if err != nil || string(res) != orderedManifest {
+		t.Errorf("OrderKeys: expected\n%s\nbut found %v\n%s", orderedManifest, err, res)
+	}
+	res, err = p.OrderKeys(res, ".", keys...)
+	if err != nil || string(res) != orderedManifest {
+		t.Errorf("OrderKeys should leave the ordered keys untouched but found %v\n%s", err, res)
+	}
+
+	res, err = p.OrderKeys([]byte(orderedManifest), "metadata", "labels")
+	expected := strings.Replace(orderedManifest, "  name: web\n  labels:\n    app: web\n", "  labels:\n    app: web\n  name: web\n", 1)
+	if err != nil || string(res) != expected {
+		t.Errorf("OrderKeys: expected\n%s\nbut found %v\n%s", expected, err, res)
+	}
+	res, err = p.OrderKeys([]byte(orderedManifest), "spec.template.spec.containers", "image")
+	if err != nil || string(res) != orderedManifest {
+		t.Errorf("OrderKeys should not order the mappings of sequence entries but found %v\n%s", err, res)
+	}
+	res, err = p.OrderKeys([]byte(orderedManifest), "spec", "template")
+	expected = strings.Replace(orderedManifest, "  replicas: 2\n  template:\n    spec:\n      containers:\n      - name: web\n        image: web:1.0\n",
+		"  template:\n    spec:\n      containers:\n      - name: web\n        image: web:1.0\n  replicas: 2\n", 1)
+	if err != nil || string(res) != expected {
+		t.Errorf("OrderKeys: expected\n%s\nbut found %v\n%s", expected, err, res)
+	}
+	res, err = p.OrderKeys([]byte("spec:\n  b: 1\n  a:\n    d: 2\n    c: 3\nkind: x\n"), "spec", "c")
+	if expected := "spec:\n  a:\n    d: 2\n    c: 3\n  b: 1\nkind: x\n"; err != nil || string(res) != expected {
+		t.Errorf("OrderKeys: %q was expected but found %q, %v", expected, res, err)
+	}
+	if res, err = p.OrderKeys([]byte("b: [1\na: 2\n"), "."); err != nil || string(res) != "b: [1\na: 2\n" {
+		t.Errorf("OrderKeys should skip the invalid documents but found %q, %v", res, err)
+	}
+}